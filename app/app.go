@@ -1,78 +1,169 @@
 package app
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"maps"
-	"net/http"
-	"net/url"
 	"os"
 	"slices"
-	"strings"
+	"time"
 
 	"github.com/josephwest2/schwab-portfolio-manager/auth"
 	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/broker"
+	"github.com/josephwest2/schwab-portfolio-manager/report"
 	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
-	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
 	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
-	"golang.org/x/oauth2"
 )
 
-const SchwabTraderApiAddress = "https://api.schwabapi.com/trader/v1/"
-const SchwabMarketDataApiAddress = "https://api.schwabapi.com/marketdata/v1/"
-
-type Account struct {
-	SecuritiesAccount trader.SecuritiesAccount
-	AccountHashValue  string
-}
+type Account = broker.Account
 
 type App struct {
-	client    *http.Client
-	tokenChan chan *oauth2.Token
+	broker    broker.Broker
+	tokenChan chan auth.CallbackResult
 	stateChan chan string
 	accounts  []Account
 	next      AppHandler
+	pricing   PricingPolicy
+	// banded rebalances trade out of band assets to their band edge instead of their target
+	bandEdge bool
+	// lots relieved by sales, set as the tax lot method of sell orders
+	lotMethod taxlot.Method
+	// limits the gains single account rebalances realize, nil for no limit
+	gainBudget *balance.GainBudget
+	// purchases that would wash a loss are dropped from plans instead of flagged
+	blockWashSales bool
+
+	// orders fill against the paper ledger when set
+	paperLedgerFile string
+	// purchases waiting for settlement
+	pendingPlansFile string
 }
 
 type AppHandler func(*App) AppHandler
 
 func NewApp() *App {
 	return &App{
-		tokenChan: make(chan *oauth2.Token),
+		tokenChan: make(chan auth.CallbackResult),
 		next:      PrintAccountsHandler,
+		pricing:   DefaultPricingPolicy,
+		lotMethod: taxlot.FIFO,
+
+		pendingPlansFile: PendingPlansFile,
 	}
 }
 
+// app that talks to the given broker instead of authenticating with schwab
+func NewAppWithBroker(b broker.Broker) *App {
+	app := NewApp()
+	app.broker = b
+	return app
+}
+
+func (a *App) SetPricingPolicy(policy PricingPolicy) {
+	a.pricing = policy
+}
+
+// routes orders to virtual accounts persisted in ledgerFile, quotes still come from schwab
+func (a *App) EnablePaperTrading(ledgerFile string) error {
+	a.paperLedgerFile = ledgerFile
+	a.pendingPlansFile = paperPendingPlansFile(ledgerFile)
+	if a.broker != nil {
+		return a.useBroker(a.broker)
+	}
+	return nil
+}
+
+func (a *App) PaperTrading() bool {
+	return a.paperLedgerFile != ""
+}
+
+// talks to the broker, through the paper ledger when paper trading
+func (a *App) useBroker(b broker.Broker) error {
+	if a.PaperTrading() {
+		if _, ok := b.(*broker.PaperBroker); !ok {
+			paperBroker, err := broker.NewPaperBroker(b, a.paperLedgerFile)
+			if err != nil {
+				return err
+			}
+			b = paperBroker
+		}
+	}
+	a.broker = b
+	return nil
+}
+
 func (a *App) Run() {
-	go auth.InitAuthCallbackServer(a.tokenChan)
-	a.client = auth.InitClient(a.tokenChan, a.stateChan)
+	if err := a.Connect(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	printDuePendingPlans(a)
+
+	for a.next != nil {
+		a.next = a.next(a)
+	}
+
+}
+
+// authenticates and loads the accounts, prompting for login if needed
+func (a *App) Connect() error {
+	if err := a.ConnectClient(); err != nil {
+		return err
+	}
 
 	for a.accounts == nil {
 		accounts, err := a.GetAccounts()
 		if err != nil {
-			if err == auth.ErrUnauthorized {
-				a.client = auth.Authenticate(a.tokenChan)
+			if errors.Is(err, auth.ErrUnauthorized) {
+				if err := a.Reauthenticate(); err != nil {
+					return err
+				}
 			} else {
-				log.Fatal(err)
+				return errors.New("failed to load accounts: " + err.Error())
 			}
 		}
 		a.accounts = accounts
 	}
+	return nil
+}
 
-	for a.next != nil {
-		a.next = a.next(a)
+// prompts for login and replaces the client
+func (a *App) Reauthenticate() error {
+	client, err := auth.Authenticate(a.tokenChan)
+	if err != nil {
+		return err
 	}
+	return a.useBroker(broker.NewSchwabBroker(client, auth.ApiBaseUrl))
+}
 
+// connects to schwab unless the app already has a broker
+func (a *App) ConnectClient() error {
+	if a.broker != nil {
+		return nil
+	}
+	go auth.InitAuthCallbackServer(a.tokenChan)
+	client, err := auth.InitClient(a.tokenChan, a.stateChan)
+	if err != nil {
+		return err
+	}
+	return a.useBroker(broker.NewSchwabBroker(client, auth.ApiBaseUrl))
 }
 
 func MainOptionsHandler(a *App) AppHandler {
+	if a.PaperTrading() {
+		fmt.Println("PAPER TRADING, orders fill against " + a.paperLedgerFile)
+	}
 	fmt.Println("1. Print accounts")
 	fmt.Println("2. Invest cash")
 	fmt.Println("3. Rebalance accounts")
-	fmt.Println("4. Exit")
+	fmt.Println("4. Rebalance household")
+	fmt.Println("5. Manage orders")
+	fmt.Println("6. Pending purchases")
+	fmt.Println("7. Harvest tax losses")
+	fmt.Println("8. Exit")
 
 	for {
 		var input int
@@ -90,6 +181,14 @@ func MainOptionsHandler(a *App) AppHandler {
 		case 3:
 			return RebalanceAccountsSelectAccountHandler
 		case 4:
+			return RebalanceHouseholdHandler
+		case 5:
+			return ManageOrdersSelectAccountHandler
+		case 6:
+			return PendingPlansHandler
+		case 7:
+			return HarvestLossesHandler
+		case 8:
 			return nil
 		default:
 			fmt.Println("invalid input")
@@ -97,6 +196,20 @@ func MainOptionsHandler(a *App) AppHandler {
 	}
 }
 
+// reports the error and returns to the main menu, prompting for login if the session expired
+func ErrorHandlerFunc(err error) AppHandler {
+	return func(a *App) AppHandler {
+		fmt.Println(err)
+		if errors.Is(err, auth.ErrUnauthorized) {
+			fmt.Println("Session expired, log in again")
+			if err := a.Reauthenticate(); err != nil {
+				fmt.Println(err)
+			}
+		}
+		return MainOptionsHandler
+	}
+}
+
 func RebalanceAccountsSelectAccountHandler(a *App) AppHandler {
 	PrintAccounts(a.accounts)
 	fmt.Println("\nSelect account to rebalance, q to cancel")
@@ -116,11 +229,7 @@ func RebalanceAccountsSelectAccountHandler(a *App) AppHandler {
 }
 
 func PrintAccounts(accounts []Account) {
-	for i, acc := range accounts {
-		fmt.Fprintf(os.Stdout, "\n#%v ********%v\n", i, acc.SecuritiesAccount.AccountNumber[len(acc.SecuritiesAccount.AccountNumber)-3:])
-		fmt.Fprintf(os.Stdout, "Account value: $%v\n", acc.SecuritiesAccount.InitialBalances.AccountValue)
-		fmt.Fprintf(os.Stdout, "Cash: $%.2f\n\n", acc.SecuritiesAccount.InitialBalances.CashBalance)
-	}
+	report.Write(os.Stdout, report.Table, AccountsReport(accounts))
 }
 
 func InvestCashSelectAccountHandler(a *App) AppHandler {
@@ -160,277 +269,260 @@ func GetTrackedHoldings(positions []trader.Position, targetAllocation targetAllo
 }
 
 func PrintCurrentPositions(positions []trader.Position, accountValue float64, targetAllocation targetAllocation.TargetAllocation) {
-	fmt.Printf("Curent positions:\n")
-	for _, pos := range positions {
-		proportion := pos.MarketValue / accountValue
-		fmt.Fprintf(os.Stdout, "%v: %v shares, $%.2f, %.2f%%\n", pos.Instrument.Symbol, pos.LongQuantity, pos.MarketValue, proportion*100)
-		if targetAllocation[pos.Instrument.Symbol].Proportion == 0 && targetAllocation[pos.Instrument.Symbol].FixedCashValue == 0 {
-			fmt.Fprintf(os.Stdout, "No desired allocation for %v, skipping inclusion in further calculations\n", pos.Instrument.Symbol)
-		}
-		fmt.Println()
-	}
+	report.Write(os.Stdout, report.Table, PositionsReport(positions, accountValue, targetAllocation))
 }
 
 func InvestCashHandlerFunc(a *App, account *Account) AppHandler {
 	return func(a *App) AppHandler {
-
-		targetAllocations, err := targetAllocation.LoadTargetAllocations(targetAllocation.TargetAllocationFile)
+		plan, err := PlanInvestCash(a, account)
 		if err != nil {
-			fmt.Println("failed to load targetAllocations", err)
-			return MainOptionsHandler
-		}
-
-		targetAllocation := targetAllocations[account.SecuritiesAccount.AccountNumber[len(account.SecuritiesAccount.AccountNumber)-3:]]
-
-		trackedHoldings := GetTrackedHoldings(account.SecuritiesAccount.Positions, targetAllocation)
-		PrintCurrentPositions(account.SecuritiesAccount.Positions, account.SecuritiesAccount.InitialBalances.AccountValue, targetAllocation)
-
-		tickers := slices.Collect(maps.Keys(trackedHoldings))
-		for ticker := range targetAllocations {
-			if _, ok := trackedHoldings[ticker]; !ok {
-				tickers = append(tickers, ticker)
-			}
+			return ErrorHandlerFunc(err)
 		}
-		trackedPrices := GetAssetPrices(a, tickers)
-
-		purchases, cash := balance.BalancePurchase(account.SecuritiesAccount.InitialBalances.CashBalance, trackedHoldings, trackedPrices, targetAllocation)
+		PrintCurrentPositions(account.SecuritiesAccount.Positions, account.SecuritiesAccount.InitialBalances.AccountValue, plan.Allocation)
 
-		if len(purchases) == 0 {
+		if len(plan.Purchases()) == 0 {
 			fmt.Println("Not enough cash to make any purchases")
 			return MainOptionsHandler
 		}
-		fmt.Println("Optimal purchases:")
-		for k, v := range purchases {
-			fmt.Fprintf(os.Stdout, "%v: %v shares\n", k, v)
+		PrintPlan(plan)
+		ok, err := PrintPreviews(a, []Plan{plan})
+		if err != nil {
+			return ErrorHandlerFunc(err)
 		}
-		fmt.Fprintf(os.Stdout, "Resulting cash: $%.2f\n\n", cash)
-
-		fmt.Println("type \"proceed\" to place the orders, anything else to cancel")
 
-		for {
-			var input string
-			_, err := fmt.Scan(&input)
-			if err != nil {
-				fmt.Println("invalid input", err)
-				continue
-			}
-			if input == "proceed" {
-				return PlaceBuyOrderHandlerFunc(a, account, purchases)
-			} else {
-				return MainOptionsHandler
-			}
+		if ok && ConfirmProceed() {
+			return PlaceOrdersHandlerFunc(a, plan)
 		}
+		return MainOptionsHandler
 	}
 }
 
-func GetAssetPrices(a *App, tickers []string) map[string]float64 {
-	addr := fmt.Sprintf(SchwabMarketDataApiAddress+"quotes?symbols=%s", strings.Join(tickers, "%2C")) + "&fields=quote&indicative=false"
-	resp, err := a.client.Get(addr)
+// tickers without a quote are left out, balance reports them as missing prices
+func GetAssetPrices(a *App, tickers []string) (map[string]float64, error) {
+	assets, err := GetAssets(a, tickers)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		log.Fatal(resp.Status)
-	}
-
-	quoteResponse := make(marketData.QuoteResponse)
-	err = json.NewDecoder(resp.Body).Decode(&quoteResponse)
-	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return assets.Prices(), nil
+}
 
-	prices := make(map[string]float64)
-	for _, data := range quoteResponse {
-		prices[data.Symbol] = data.Quote.LastPrice
+func PlaceOrdersHandlerFunc(a *App, plan Plan) AppHandler {
+	return func(a *App) AppHandler {
+		results, err := PlaceOrders(a, plan)
+		report.Write(os.Stdout, report.Table, results)
+		if err != nil {
+			return ErrorHandlerFunc(err)
+		}
+		return TrackOrdersHandlerFunc(results)
 	}
-	return prices
 }
 
-func PlaceTriggerOrderHandlerFunc(a *App, account *Account, orders map[string]float64) AppHandler {
-	return func(a *App) AppHandler {
-		fmt.Println("placing trigger order")
-		order := trader.Order{
-			OrderType:          "MARKET",
-			Session:            "NORMAL",
-			Duration:           "DAY",
-			Cancelable:         true,
-			OrderStrategyType:  "TRIGGER",
-			OrderLegCollection: make([]trader.OrderLeg, 0),
-			ChildOrderStrategies: []trader.Order{
-				{
-					OrderType:          "MARKET",
-					Session:            "NORMAL",
-					Cancelable:         true,
-					Duration:           "DAY",
-					OrderStrategyType:  "SINGLE",
-					OrderLegCollection: make([]trader.OrderLeg, 0),
-				},
+// sells in the parent order, buys in a child order triggered once the sells fill
+func BuildTriggerOrder(orders map[string]float64, assets Assets) trader.Order {
+	order := trader.Order{
+		OrderType:          "MARKET",
+		Session:            "NORMAL",
+		Duration:           "DAY",
+		Cancelable:         true,
+		OrderStrategyType:  "TRIGGER",
+		OrderLegCollection: make([]trader.OrderLeg, 0),
+		ChildOrderStrategies: []trader.Order{
+			{
+				OrderType:          "MARKET",
+				Session:            "NORMAL",
+				Cancelable:         true,
+				Duration:           "DAY",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: make([]trader.OrderLeg, 0),
 			},
+		},
+	}
+	for _, ticker := range slices.Sorted(maps.Keys(orders)) {
+		count := orders[ticker]
+		if count < 0 {
+			order.OrderLegCollection = append(order.OrderLegCollection, orderLeg("SELL", ticker, -count, assets))
+		} else if count > 0 {
+			order.ChildOrderStrategies[0].OrderLegCollection = append(order.ChildOrderStrategies[0].OrderLegCollection, orderLeg("BUY", ticker, count, assets))
 		}
-		for ticker, count := range orders {
-			if count < 0 {
-				order.OrderLegCollection = append(order.OrderLegCollection, trader.OrderLeg{
-					Instruction: "SELL",
-					Quantity:    -count,
-					Instrument: trader.Instrument{
-						Symbol:    ticker,
-						AssetType: "EQUITY",
-					},
-				})
-			} else if count > 0 {
-				order.ChildOrderStrategies[0].OrderLegCollection = append(order.ChildOrderStrategies[0].OrderLegCollection, trader.OrderLeg{
-					Instruction: "BUY",
-					Quantity:    count,
-					Instrument: trader.Instrument{
-						Symbol:    ticker,
-						AssetType: "EQUITY",
-					},
-				})
-			}
-		}
+	}
 
-		orderData, err := json.Marshal(order)
-		fmt.Println("serialized order", string(orderData))
-		if err != nil {
-			log.Fatal(err)
-		}
-		resp, err := a.client.Post(
-			SchwabTraderApiAddress+fmt.Sprintf("accounts/%v/orders", account.AccountHashValue),
-			"application/json",
-			bytes.NewBuffer(orderData),
-		)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer resp.Body.Close()
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if resp.StatusCode != 201 {
+	return order
+}
 
-			log.Fatal("Failed to place order", string(respBody))
+func PlaceTriggerOrder(a *App, account *Account, orders map[string]float64, assets Assets) (report.OrderResult, error) {
+	fmt.Fprintln(os.Stderr, "placing trigger order")
+	return placeOrder(a, account, a.withLotMethod(BuildTriggerOrder(orders, assets)))
+}
+
+// sales of a plan without purchases, a trigger order would have a child without legs
+func BuildSellOrder(orders map[string]float64, assets Assets) trader.Order {
+	order := trader.Order{
+		OrderType:          "MARKET",
+		Session:            "NORMAL",
+		Cancelable:         true,
+		Duration:           "DAY",
+		OrderStrategyType:  "SINGLE",
+		OrderLegCollection: make([]trader.OrderLeg, 0),
+	}
+	for _, ticker := range slices.Sorted(maps.Keys(orders)) {
+		if count := orders[ticker]; count < 0 {
+			order.OrderLegCollection = append(order.OrderLegCollection, orderLeg("SELL", ticker, -count, assets))
 		}
-		fmt.Println("\n\n Order Placed \n\n", string(respBody))
-		return MainOptionsHandler
 	}
+	return order
+}
 
+func PlaceSellOrder(a *App, account *Account, orders map[string]float64, assets Assets) (report.OrderResult, error) {
+	fmt.Fprintln(os.Stderr, "placing sell order")
+	return placeOrder(a, account, a.withLotMethod(BuildSellOrder(orders, assets)))
 }
 
-func PlaceBuyOrderHandlerFunc(a *App, account *Account, orders map[string]float64) AppHandler {
-	return func(a *App) AppHandler {
-		fmt.Println("placing buy order")
-		order := trader.Order{
-			OrderType:          "MARKET",
-			Session:            "NORMAL",
-			Cancelable:         true,
-			Duration:           "DAY",
-			OrderStrategyType:  "SINGLE",
-			OrderLegCollection: make([]trader.OrderLeg, 0),
-		}
-		for ticker, count := range orders {
-			if count < 1 {
-				continue
-			}
-			order.OrderLegCollection = append(order.OrderLegCollection, trader.OrderLeg{
-				Instruction: "BUY",
-				Quantity:    count,
-				Instrument: trader.Instrument{
-					Symbol:    ticker,
-					AssetType: "EQUITY",
-				},
-			})
-		}
-		orderData, err := json.Marshal(order)
-		fmt.Println("serialized order", string(orderData))
-		if err != nil {
-			log.Fatal(err)
-		}
-		resp, err := a.client.Post(
-			SchwabTraderApiAddress+fmt.Sprintf("accounts/%v/orders", account.AccountHashValue),
-			"application/json",
-			bytes.NewBuffer(orderData),
-		)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer resp.Body.Close()
-		respBody, _ := io.ReadAll(resp.Body)
-		if resp.StatusCode != 201 {
-			log.Fatal("Failed to place order", string(respBody))
+// whole shares or multiples of the increment of the ticker, or at least a cent for tickers that trade in dollars
+func BuildBuyOrder(orders map[string]float64, assets Assets) trader.Order {
+	order := trader.Order{
+		OrderType:          "MARKET",
+		Session:            "NORMAL",
+		Cancelable:         true,
+		Duration:           "DAY",
+		OrderStrategyType:  "SINGLE",
+		OrderLegCollection: make([]trader.OrderLeg, 0),
+	}
+	for _, ticker := range slices.Sorted(maps.Keys(orders)) {
+		leg := orderLeg("BUY", ticker, orders[ticker], assets)
+		if leg.Quantity <= 0 || (leg.QuantityType != "DOLLARS" && leg.Quantity < assets.MinShares(ticker)-1e-9) {
+			continue
 		}
+		order.OrderLegCollection = append(order.OrderLegCollection, leg)
+	}
+	return order
+}
 
-		fmt.Println("\n\n Order Placed\n\n", string(respBody))
-		return MainOptionsHandler
+func PlaceBuyOrder(a *App, account *Account, orders map[string]float64, assets Assets) (report.OrderResult, error) {
+	fmt.Fprintln(os.Stderr, "placing buy order")
+	return placeOrder(a, account, BuildBuyOrder(orders, assets))
+}
+
+func placeOrder(a *App, account *Account, order trader.Order) (report.OrderResult, error) {
+	orderData, err := json.Marshal(order)
+	if err != nil {
+		return report.OrderResult{}, err
 	}
+	fmt.Fprintln(os.Stderr, "serialized order", string(orderData))
+
+	orderID, err := a.broker.PlaceOrder(account.AccountHashValue, order)
+	if err != nil {
+		return report.OrderResult{}, fmt.Errorf("failed to place order for account ********%v: %w", AccountIdentifier(account), err)
+	}
+	return report.OrderResult{
+		Account: AccountIdentifier(account),
+		OrderID: orderID,
+	}, nil
 }
 
 func RebalanceAccountHandlerFunc(a *App, account *Account) AppHandler {
 	return func(a *App) AppHandler {
-
-		targetAllocations, err := targetAllocation.LoadTargetAllocations(targetAllocation.TargetAllocationFile)
+		plan, err := PlanRebalance(a, account)
 		if err != nil {
-			fmt.Println("failed to load targetAllocations", err)
-			return MainOptionsHandler
+			return ErrorHandlerFunc(err)
 		}
+		PrintCurrentPositions(account.SecuritiesAccount.Positions, account.SecuritiesAccount.InitialBalances.AccountValue, plan.Allocation)
 
-		targetAllocation := targetAllocations[account.SecuritiesAccount.AccountNumber[len(account.SecuritiesAccount.AccountNumber)-3:]]
-
-		trackedHoldings := GetTrackedHoldings(account.SecuritiesAccount.Positions, targetAllocation)
-		PrintCurrentPositions(account.SecuritiesAccount.Positions, account.SecuritiesAccount.InitialBalances.AccountValue, targetAllocation)
-
-		tickers := slices.Collect(maps.Keys(trackedHoldings))
-		for ticker := range targetAllocation {
-			if _, ok := trackedHoldings[ticker]; !ok {
-				tickers = append(tickers, ticker)
-			}
-		}
-		trackedPrices := GetAssetPrices(a, tickers)
-
-		orders, cash := balance.RebalanceWithSelling(account.SecuritiesAccount.InitialBalances.CashBalance, trackedHoldings, trackedPrices, targetAllocation)
-		purchases := make(map[string]float64)
-		sales := make(map[string]float64)
-		for k, v := range orders {
-			if v > 0 {
-				purchases[k] = v
-			} else if v < 0 {
-				sales[k] = v
-			}
-		}
-		if len(purchases) == 0 {
+		if len(plan.Orders) == 0 {
 			fmt.Println("Portfolio is already optimally balanced")
 			return MainOptionsHandler
 		}
-		fmt.Println("Optimal sales:")
-		for k, v := range sales {
-			fmt.Fprintf(os.Stdout, "%v: %v shares\n", k, v)
+		PrintPlan(plan)
+		ok, err := PrintPreviews(a, []Plan{plan})
+		if err != nil {
+			return ErrorHandlerFunc(err)
 		}
-		fmt.Println("Optimal purchases:")
-		for k, v := range purchases {
-			fmt.Fprintf(os.Stdout, "%v: %v shares\n", k, v)
+
+		if ok && ConfirmProceed() {
+			return PlaceOrdersHandlerFunc(a, plan)
 		}
-		fmt.Fprintf(os.Stdout, "Resulting cash: $%.2f\n\n", cash)
+		return MainOptionsHandler
+	}
+}
 
-		fmt.Println("type \"proceed\" to place the orders, anything else to cancel")
+func RebalanceHouseholdHandler(a *App) AppHandler {
+	plans, err := PlanHousehold(a)
+	if err != nil {
+		return ErrorHandlerFunc(err)
+	}
+
+	plansWithOrders := make([]Plan, 0)
+	for _, plan := range plans {
+		fmt.Fprintf(os.Stdout, "\n********%v\n", AccountIdentifier(plan.Account))
+		PrintCurrentPositions(plan.Account.SecuritiesAccount.Positions, plan.Account.SecuritiesAccount.InitialBalances.AccountValue, plan.Allocation)
+		if len(plan.Orders) > 0 {
+			plansWithOrders = append(plansWithOrders, plan)
+		}
+	}
+	if len(plansWithOrders) == 0 {
+		fmt.Println("Household is already optimally balanced")
+		return MainOptionsHandler
+	}
+	report.Write(os.Stdout, report.Table, PlansReport(plansWithOrders))
+	ok, err := PrintPreviews(a, plansWithOrders)
+	if err != nil {
+		return ErrorHandlerFunc(err)
+	}
 
-		for {
-			var input string
-			_, err := fmt.Scan(&input)
+	if ok && ConfirmProceed() {
+		results := make(report.OrderResults, 0)
+		for _, plan := range plans {
+			placed, err := PlaceOrders(a, plan)
+			results = append(results, placed...)
 			if err != nil {
-				fmt.Println("invalid input", err)
-				continue
+				// orders placed before the failure are still reported
+				report.Write(os.Stdout, report.Table, results)
+				return ErrorHandlerFunc(err)
 			}
-			if input == "proceed" {
-				if len(sales) == 0 {
-					return PlaceBuyOrderHandlerFunc(a, account, purchases)
-				}
-				return PlaceTriggerOrderHandlerFunc(a, account, orders)
-			} else {
-				return MainOptionsHandler
+		}
+		report.Write(os.Stdout, report.Table, results)
+		return TrackOrdersHandlerFunc(results)
+	}
+	return MainOptionsHandler
+}
+
+// harvests positions losing at least DefaultHarvestMinLoss, the sales relieve lots with the loss harvester method
+func HarvestLossesHandler(a *App) AppHandler {
+	plans, err := PlanHarvest(a, DefaultHarvestMinLoss, time.Now())
+	if err != nil {
+		return ErrorHandlerFunc(err)
+	}
+	if len(plans) == 0 {
+		fmt.Println("No positions with losses to harvest")
+		return MainOptionsHandler
+	}
+	method := a.lotMethod
+	a.lotMethod = taxlot.LossHarvester
+	defer func() { a.lotMethod = method }()
+
+	report.Write(os.Stdout, report.Table, PlansReport(plans))
+	ok, err := PrintPreviews(a, plans)
+	if err != nil {
+		return ErrorHandlerFunc(err)
+	}
+	if ok && ConfirmProceed() {
+		results := make(report.OrderResults, 0)
+		for _, plan := range plans {
+			placed, err := PlaceOrders(a, plan)
+			results = append(results, placed...)
+			if err != nil {
+				report.Write(os.Stdout, report.Table, results)
+				return ErrorHandlerFunc(err)
 			}
 		}
+		report.Write(os.Stdout, report.Table, results)
+		return TrackOrdersHandlerFunc(results)
 	}
+	return MainOptionsHandler
+}
+
+// last 3 digits of the account number, used as key in the target allocation file
+func AccountIdentifier(account *Account) targetAllocation.AccountIdentifier {
+	return account.SecuritiesAccount.AccountNumber[len(account.SecuritiesAccount.AccountNumber)-3:]
 }
 
 func PrintAccountsHandler(a *App) AppHandler {
@@ -439,42 +531,33 @@ func PrintAccountsHandler(a *App) AppHandler {
 }
 
 func (a *App) GetAccounts() ([]Account, error) {
-	resp, err := a.client.Get(SchwabTraderApiAddress + "accounts/accountNumbers")
-	if err != nil {
-		ue := err.(*url.Error)
-		if _, ok := ue.Err.(*oauth2.RetrieveError); ok {
-			return nil, auth.ErrUnauthorized
-		}
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusUnauthorized {
-		return nil, auth.ErrUnauthorized
-	}
+	return a.broker.ListAccounts()
+}
 
-	var accounts trader.AccountNumbersResponse
-	err = json.NewDecoder(resp.Body).Decode(&accounts)
+// reloads the accounts so balances and positions reflect filled orders
+func (a *App) RefreshAccounts() error {
+	accounts, err := a.GetAccounts()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	a.accounts = accounts
+	return nil
+}
 
-	var res []Account
-	for _, acc := range accounts {
-		resp, err := a.client.Get(SchwabTraderApiAddress + "accounts/" + acc.HashValue + "?fields=positions")
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode == http.StatusUnauthorized {
-			return nil, auth.ErrUnauthorized
-		}
+// orders of the account entered within the given time range
+func GetOrders(a *App, account *Account, from time.Time, to time.Time) ([]trader.Order, error) {
+	return a.broker.ListOrders(account.AccountHashValue, from, to)
+}
 
-		var securitiesAccount trader.AccountResponse
-		err = json.NewDecoder(resp.Body).Decode(&securitiesAccount)
-		if err != nil {
-			log.Fatal(err)
+func FindAccount(accounts []Account, identifier targetAllocation.AccountIdentifier) (*Account, error) {
+	for i := range accounts {
+		if AccountIdentifier(&accounts[i]) == identifier {
+			return &accounts[i], nil
 		}
-		res = append(res, Account{securitiesAccount.SecuritiesAccount, acc.HashValue})
 	}
+	return nil, fmt.Errorf("no account ending in %v", identifier)
+}
 
-	return res, nil
+func PrintOrders(orders []trader.Order) {
+	report.Write(os.Stdout, report.Table, OrdersReport(orders))
 }
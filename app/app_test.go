@@ -0,0 +1,131 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/broker"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func newTestApp(t *testing.T) (*App, *broker.FakeBroker) {
+	allocationFile := targetAllocation.TargetAllocationFile
+	targetAllocation.TargetAllocationFile = "testing/targetAllocation_appTest1.yaml"
+	t.Cleanup(func() {
+		targetAllocation.TargetAllocationFile = allocationFile
+	})
+
+	fb := broker.NewFakeBroker()
+	for ticker, price := range map[string]float64{
+		"DFAC":  30,
+		"DFIC":  20,
+		"DFEM":  10,
+		"SWVXX": 1,
+		"VTI":   10,
+		"VSAIX": 10,
+		"VXUS":  10,
+		"VWO":   10,
+	} {
+		fb.SetQuote(ticker, price)
+	}
+	fb.AddAccount("00000123", "hash123", 503.1, map[string]float64{
+		"DFAC":  30,
+		"DFIC":  20,
+		"DFEM":  10,
+		"SWVXX": 3998,
+	})
+	fb.AddAccount("00000567", "hash567", 202.12, map[string]float64{
+		"VTI":   10,
+		"VSAIX": 10,
+		"VXUS":  10,
+		"VWO":   10,
+		"SWVXX": 4000,
+	})
+
+	a := NewAppWithBroker(fb)
+	a.pendingPlansFile = filepath.Join(t.TempDir(), "pendingPlans.json")
+	if err := a.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return a, fb
+}
+
+func holdings(t *testing.T, fb *broker.FakeBroker, accountHash string) map[string]float64 {
+	account, err := fb.GetAccount(accountHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]float64)
+	for _, pos := range account.SecuritiesAccount.Positions {
+		result[pos.Instrument.Symbol] = pos.LongQuantity
+	}
+	return result
+}
+
+func assertAllFilled(t *testing.T, fb *broker.FakeBroker, accountHash string) {
+	orders, err := fb.ListOrders(accountHash, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range OrdersReport(orders) {
+		if order.Status != "FILLED" {
+			t.Errorf("expected order %v to be FILLED, got %v", order.OrderID, order.Status)
+		}
+	}
+}
+
+func TestBuildBuyOrderDollars(t *testing.T) {
+	assets := Assets{
+		"FUND": {AssetType: "MUTUAL_FUND", Price: 12.34},
+		"VTI":  {AssetType: "EQUITY", Price: 300},
+	}
+	order := BuildBuyOrder(map[string]float64{"FUND": 2.5, "VTI": 0.5}, assets)
+	expected := []trader.OrderLeg{
+		{
+			Instruction:  "BUY",
+			Quantity:     30.85,
+			QuantityType: "DOLLARS",
+			Instrument:   trader.Instrument{Symbol: "FUND", AssetType: "MUTUAL_FUND"},
+		},
+	}
+	if !reflect.DeepEqual(order.OrderLegCollection, expected) {
+		t.Errorf("expected legs %+v, got %+v", expected, order.OrderLegCollection)
+	}
+}
+
+func TestBuildBuyOrderIncrements(t *testing.T) {
+	assets := Assets{
+		"VTI":  {AssetType: "EQUITY", Price: 300, Increment: 0.001},
+		"VXUS": {AssetType: "EQUITY", Price: 60},
+	}
+	order := BuildBuyOrder(map[string]float64{"VTI": 0.125, "VXUS": 0.5}, assets)
+	expected := []trader.OrderLeg{
+		{
+			Instruction: "BUY",
+			Quantity:    0.125,
+			Instrument:  trader.Instrument{Symbol: "VTI", AssetType: "EQUITY"},
+		},
+	}
+	if !reflect.DeepEqual(order.OrderLegCollection, expected) {
+		t.Errorf("expected legs %+v, got %+v", expected, order.OrderLegCollection)
+	}
+}
+
+// a corrupt paper ledger is reported instead of ending the session
+func TestEnablePaperTradingCorruptLedger(t *testing.T) {
+	a, fb := newTestApp(t)
+	ledgerFile := filepath.Join(t.TempDir(), "paperLedger.json")
+	if err := os.WriteFile(ledgerFile, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.EnablePaperTrading(ledgerFile); err == nil {
+		t.Fatal("expected an error for the corrupt ledger")
+	}
+	if a.broker != fb {
+		t.Errorf("expected the app to keep its broker, got %T", a.broker)
+	}
+}
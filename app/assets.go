@@ -0,0 +1,107 @@
+package app
+
+import (
+	"cmp"
+	"fmt"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+// asset type and price of a ticker, Increment is the shares it trades in, 0 for whole shares
+type Asset struct {
+	AssetType string
+	Price     float64
+	Increment float64
+}
+
+// by ticker
+type Assets map[string]Asset
+
+// mutual funds, money market funds included, trade in dollar amounts at their end of day nav
+func TradesInDollars(assetType string) bool {
+	return assetType == "MUTUAL_FUND"
+}
+
+func AssetFromQuote(instrument marketData.Instrument) Asset {
+	asset := Asset{AssetType: instrument.AssetMainType, Price: instrument.Quote.LastPrice}
+	if TradesInDollars(asset.AssetType) && instrument.Quote.NAV > 0 {
+		asset.Price = instrument.Quote.NAV
+	}
+	return asset
+}
+
+// tickers without a quote are left out
+func GetAssets(a *App, tickers []string) (Assets, error) {
+	quoteResponse, err := a.broker.GetQuotes(tickers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quotes: %w", err)
+	}
+
+	assets := make(Assets)
+	for _, data := range quoteResponse {
+		assets[data.Symbol] = AssetFromQuote(data)
+	}
+	return assets, nil
+}
+
+// takes the increments of the allocation's tickers that trade in shares
+func (as Assets) SetIncrements(allocation targetAllocation.TargetAllocation) {
+	for ticker, asset := range as {
+		alloc, ok := allocation[ticker]
+		if !ok || TradesInDollars(asset.AssetType) || asset.Price <= 0 {
+			continue
+		}
+		if increment := alloc.Increment(asset.Price); increment != 1 {
+			asset.Increment = increment
+			as[ticker] = asset
+		}
+	}
+}
+
+// smallest share quantity of the ticker that can be traded
+func (as Assets) MinShares(ticker string) float64 {
+	return cmp.Or(as[ticker].Increment, 1)
+}
+
+func (as Assets) Prices() map[string]float64 {
+	prices := make(map[string]float64)
+	for ticker, asset := range as {
+		prices[ticker] = asset.Price
+	}
+	return prices
+}
+
+func (as Assets) DollarTickers() balance.DollarTickers {
+	dollarTickers := make(balance.DollarTickers)
+	for ticker, asset := range as {
+		if TradesInDollars(asset.AssetType) {
+			dollarTickers[ticker] = true
+		}
+	}
+	return dollarTickers
+}
+
+// tickers without a quote are taken to be equities
+func (as Assets) AssetType(ticker string) string {
+	return cmp.Or(as[ticker].AssetType, "EQUITY")
+}
+
+// leg for quantity shares of the ticker, a DOLLARS leg worth the shares for dollar tickers
+func orderLeg(instruction string, ticker string, quantity float64, assets Assets) trader.OrderLeg {
+	leg := trader.OrderLeg{
+		Instruction: instruction,
+		Quantity:    quantity,
+		Instrument: trader.Instrument{
+			Symbol:    ticker,
+			AssetType: assets.AssetType(ticker),
+		},
+	}
+	if TradesInDollars(leg.Instrument.AssetType) {
+		leg.QuantityType = "DOLLARS"
+		leg.Quantity = balance.DollarAmount(quantity, assets[ticker].Price)
+	}
+	return leg
+}
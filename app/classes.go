@@ -0,0 +1,86 @@
+package app
+
+import (
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+// ticker an account buys for an asset class, the first eligible one it holds or else the first listed
+func classTicker(positions []trader.Position) func(eligible []string) string {
+	return func(eligible []string) string {
+		for _, ticker := range eligible {
+			for _, pos := range positions {
+				if pos.Instrument.Symbol == ticker && pos.LongQuantity > 0 {
+					return ticker
+				}
+			}
+		}
+		return eligible[0]
+	}
+}
+
+// allocation with its asset classes resolved to the tickers the account buys for them
+func resolveClasses(allocation targetAllocation.TargetAllocation, positions []trader.Position) (targetAllocation.TargetAllocation, targetAllocation.ClassTickers) {
+	return targetAllocation.Resolve(allocation, classTicker(positions))
+}
+
+// Shares of the eligible tickers in the positions other than the ones chosen for their class, by ticker.
+func heldClassTickers(positions []trader.Position, classes targetAllocation.ClassTickers) map[string]float64 {
+	held := make(map[string]float64)
+	for _, pos := range positions {
+		ticker := pos.Instrument.Symbol
+		if _, chosen := classes[ticker]; chosen || pos.LongQuantity <= 0 {
+			continue
+		}
+		if _, ok := classes.Chosen(ticker); ok {
+			held[ticker] = pos.LongQuantity
+		}
+	}
+	return held
+}
+
+// holdings with every held eligible ticker counted as shares of the ticker chosen for its class of the same value
+func foldClasses(holdings map[string]float64, held map[string]float64, classes targetAllocation.ClassTickers, prices map[string]float64) map[string]float64 {
+	folded := maps.Clone(holdings)
+	for ticker, quantity := range held {
+		chosen, _ := classes.Chosen(ticker)
+		folded[chosen] += quantity * prices[ticker] / prices[chosen]
+	}
+	return folded
+}
+
+// orders on the held tickers from orders on the folded class holdings, and the cash left after rounding
+func unfoldClasses(orders map[string]float64, cash float64, holdings map[string]float64, held map[string]float64, classes targetAllocation.ClassTickers, prices map[string]float64, dollarTickers balance.DollarTickers) (map[string]float64, float64) {
+	unfolded := maps.Clone(orders)
+	for _, chosen := range slices.Sorted(maps.Keys(classes)) {
+		order := orders[chosen]
+		if -order <= holdings[chosen]+1e-9 {
+			continue
+		}
+		dollars := (-order - holdings[chosen]) * prices[chosen]
+		if holdings[chosen] > 0 {
+			unfolded[chosen] = -holdings[chosen]
+		} else {
+			delete(unfolded, chosen)
+		}
+		for _, ticker := range slices.Backward(classes[chosen]) {
+			if held[ticker] <= 0 || dollars <= 1e-9 {
+				continue
+			}
+			shares := dollars / prices[ticker]
+			if !dollarTickers[ticker] {
+				shares = math.Ceil(shares - 1e-9)
+			}
+			shares = math.Min(shares, held[ticker])
+			unfolded[ticker] = -shares
+			dollars -= shares * prices[ticker]
+		}
+		cash -= dollars
+	}
+	return unfolded, cash
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+func TestRebalanceClasses(t *testing.T) {
+	a, _ := newTestApp(t)
+	targetAllocation.TargetAllocationFile = "testing/targetAllocation_appTest4.yaml"
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// VTI is the first eligible ticker held for US equity, the VSAIX held counts toward the class and is kept
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOrders := map[string]float64{"VTI": 22, "VXUS": -1, "VWO": -1}
+	if !reflect.DeepEqual(plan.Orders, expectedOrders) {
+		t.Errorf("expected orders %v, got %v", expectedOrders, plan.Orders)
+	}
+	if _, ok := plan.Allocation["VTI"]; !ok || len(plan.Allocation) != 4 {
+		t.Errorf("expected the classes resolved to VTI, VXUS and VWO, got %v", plan.Allocation)
+	}
+}
+
+func TestUnfoldClasses(t *testing.T) {
+	classes := targetAllocation.ClassTickers{"VTI": {"VTI", "VSAIX", "SWTSX"}}
+	prices := map[string]float64{"VTI": 10, "VSAIX": 10, "SWTSX": 4}
+	held := map[string]float64{"VSAIX": 10, "SWTSX": 10}
+	// the $110 of VTI sold beyond the 5 shares held sell SWTSX, the least preferred, before VSAIX
+	orders, cash := unfoldClasses(map[string]float64{"VTI": -16}, 0, map[string]float64{"VTI": 5}, held, classes, prices, nil)
+	expected := map[string]float64{"VTI": -5, "SWTSX": -10, "VSAIX": -7}
+	if !reflect.DeepEqual(orders, expected) {
+		t.Errorf("expected orders %v, got %v", expected, orders)
+	}
+	if !util.AlmostEqual(cash, 0, 1e-9) {
+		t.Errorf("expected no cash from rounding, got %v", cash)
+	}
+}
+
+func TestHouseholdClasses(t *testing.T) {
+	a, _ := newTestApp(t)
+	targetAllocation.TargetAllocationFile = "testing/targetAllocation_appTest4.yaml"
+
+	plans, err := PlanHousehold(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every account trades the tickers it holds for the classes
+	eligible := map[string][]string{
+		"123": {"DFAC", "DFIC", "DFEM", "SWVXX"},
+		"567": {"VTI", "VXUS", "VWO", "SWVXX"},
+	}
+	for _, plan := range plans {
+		for ticker := range plan.Orders {
+			if !slices.Contains(eligible[AccountIdentifier(plan.Account)], ticker) {
+				t.Errorf("expected account %v to trade %v only, got %v", AccountIdentifier(plan.Account), eligible[AccountIdentifier(plan.Account)], plan.Orders)
+			}
+		}
+	}
+}
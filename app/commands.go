@@ -0,0 +1,707 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/broker"
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/schwabMock"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+const (
+	ExitOK       = 0
+	ExitError    = 1
+	ExitUsage    = 2
+	ExitCanceled = 3
+)
+
+// non-interactive entry point, used when the program is run with arguments
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(a *App, args []string) int
+}
+
+var Commands []Command
+
+func init() {
+	Commands = []Command{
+		{"accounts", "accounts [--output table|json|csv]", "print accounts", AccountsCommand},
+		{"invest", "invest --account 123 [--dry-run] [--yes] [--wait] [--output ...]", "invest the cash of an account", InvestCommand},
+		{"rebalance", "rebalance --account 123 [--dry-run] [--yes] [--wait] [--output ...]", "rebalance an account, selling if needed", RebalanceCommand},
+		{"household", "household [--dry-run] [--yes] [--wait] [--output ...]", "rebalance all accounts against the global allocation", HouseholdCommand},
+		{"harvest", "harvest [--min-loss 100] [--dry-run] [--yes] [--wait] [--output ...]", "sell lots at a loss and buy their substitutes", HarvestCommand},
+		{"location", "location [--output ...]", "split the global allocation into per account targets by account type", LocationCommand},
+		{"validate-allocations", "validate-allocations [--file targetAllocation.yaml] [--offline] [--schema] [--output ...]", "report every problem of the allocation file, --schema prints its JSON Schema", ValidateAllocationsCommand},
+		{"quote", "quote [--output ...] TICKER...", "print the last price of each ticker", QuoteCommand},
+		{"orders", "orders --account 123 [--days 7 | --from 2025-01-01 [--to 2025-01-31]] [--open] [--output ...]", "list orders of an account", OrdersCommand},
+		{"cancel-order", "cancel-order --account 123 --id 1001", "cancel an open order", CancelOrderCommand},
+		{"replace-order", "replace-order --account 123 --id 1001 [--quantity VTI=5]... [--price 101.5] [--dry-run] [--yes] [--wait] [--output ...]", "replace an open order with new quantities or limit price", ReplaceOrderCommand},
+		{"pending", "pending [--place] [--yes] [--wait] [--output ...]", "list purchases waiting for sales to settle, placing the settled ones with --place", PendingCommand},
+		{"mock-server", "mock-server [--addr 127.0.0.1:8183] [--fixtures schwabMock/fixtures]", "serve a local stand-in for the schwab api", MockServerCommand},
+	}
+}
+
+func PrintUsage() {
+	fmt.Fprintln(os.Stderr, "usage: schwab-portfolio-manager [--paper] [--paper-ledger file] [--pricing market] [--reprices 3] [--reprice-after 30s] [--band-edge] [--lot-method fifo] [--max-gain 1000] [--no-short-term-gains] [--block-wash-sales] [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\nwithout a command the interactive menu is started")
+	fmt.Fprintln(os.Stderr, "--paper fills orders against virtual accounts in the paper ledger instead of placing them")
+	fmt.Fprintln(os.Stderr, "--pricing other than market places a limit order per ticker, sales first, repricing unfilled orders\n\ncommands:")
+	for _, cmd := range Commands {
+		fmt.Fprintf(os.Stderr, "  %v\n        %v\n", cmd.Usage, cmd.Description)
+	}
+}
+
+// parses the flags given before the command and returns the remaining arguments
+func (a *App) ParseGlobalFlags(args []string) ([]string, error) {
+	fs := newFlagSet("schwab-portfolio-manager")
+	fs.Usage = PrintUsage
+	paper := fs.Bool("paper", false, "paper trade against the ledger file")
+	ledgerFile := fs.String("paper-ledger", broker.PaperLedgerFile, "ledger file used for paper trading")
+	pricing := fs.String("pricing", MarketPricing, "order pricing: market, mid, ask+N (cents) or marketable:P (percent slippage cap)")
+	reprices := fs.Int("reprices", DefaultPricingPolicy.Reprices, "times an unfilled limit order is canceled and replaced at a new price")
+	repriceAfter := fs.Duration("reprice-after", DefaultPricingPolicy.RepriceAfter, "time a limit order is given to fill before it is repriced")
+	bandEdge := fs.Bool("band-edge", false, "rebalance assets outside of their drift band to the band edge instead of the target")
+	lotMethod := fs.String("lot-method", string(taxlot.FIFO), "lots sales relieve: fifo, lifo, highest-cost or loss-harvester")
+	budget := balance.GainBudget{MaxGain: math.Inf(1)}
+	budgeted := false
+	fs.Func("max-gain", "largest net capital gain a rebalance may realize, in dollars", func(s string) error {
+		maxGain, err := strconv.ParseFloat(s, 64)
+		budget.MaxGain, budgeted = maxGain, true
+		return err
+	})
+	fs.BoolFunc("no-short-term-gains", "rebalances may not realize net short term gains", func(s string) error {
+		noShortTermGains, err := strconv.ParseBool(s)
+		budget.NoShortTermGains, budgeted = noShortTermGains, true
+		return err
+	})
+	blockWashSales := fs.Bool("block-wash-sales", false, "drop purchases that would wash a loss realized in any account in the last 30 days instead of flagging them")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	policy, err := ParsePricingPolicy(*pricing)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	policy.Reprices = *reprices
+	policy.RepriceAfter = *repriceAfter
+	a.SetPricingPolicy(policy)
+	a.bandEdge = *bandEdge
+	method, err := taxlot.ParseMethod(*lotMethod)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	a.lotMethod = method
+	if budgeted {
+		a.gainBudget = &budget
+	}
+	a.blockWashSales = *blockWashSales
+	if *paper {
+		if err := a.EnablePaperTrading(*ledgerFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, err
+		}
+	}
+	return fs.Args(), nil
+}
+
+// runs the command named by the first argument and returns the exit code
+func (a *App) RunCommand(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		PrintUsage()
+		if len(args) == 0 {
+			return ExitUsage
+		}
+		return ExitOK
+	}
+	for _, cmd := range Commands {
+		if cmd.Name == args[0] {
+			return cmd.Run(a, args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "unknown command:", args[0])
+	PrintUsage()
+	return ExitUsage
+}
+
+// flags shared by commands that place orders
+type orderFlags struct {
+	account string
+	dryRun  bool
+	yes     bool
+	wait    bool
+	output  *outputFlag
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+type outputFlag struct {
+	format report.Format
+}
+
+func (of *outputFlag) String() string {
+	if of == nil || of.format == "" {
+		return string(report.Table)
+	}
+	return string(of.format)
+}
+
+func (of *outputFlag) Set(s string) error {
+	format, err := report.ParseFormat(s)
+	if err != nil {
+		return err
+	}
+	of.format = format
+	return nil
+}
+
+func registerOutput(fs *flag.FlagSet) *outputFlag {
+	of := &outputFlag{report.Table}
+	fs.Var(of, "output", "output format: table, json or csv")
+	return of
+}
+
+// writes the report to stdout in the requested format
+func (of *outputFlag) write(r report.Report) int {
+	if err := report.Write(os.Stdout, of.format, r); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+func (of *orderFlags) register(fs *flag.FlagSet, withAccount bool) {
+	of.output = registerOutput(fs)
+	if withAccount {
+		fs.StringVar(&of.account, "account", "", "last 3 digits of the account number")
+	}
+	fs.BoolVar(&of.dryRun, "dry-run", false, "print the plan without placing orders")
+	fs.BoolVar(&of.yes, "yes", false, "place orders without asking for confirmation")
+	fs.BoolVar(&of.wait, "wait", false, "wait for the orders to fill and print their final state")
+}
+
+func AccountsCommand(a *App, args []string) int {
+	fs := newFlagSet("accounts")
+	output := registerOutput(fs)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if err := a.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	return output.write(AccountsReport(a.accounts))
+}
+
+func LocationCommand(a *App, args []string) int {
+	fs := newFlagSet("location")
+	output := registerOutput(fs)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if err := a.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	located, err := PlanLocation(a)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	return output.write(LocationsReport(located))
+}
+
+// reports every problem of the allocation file, account keys are checked unless offline
+func ValidateAllocationsCommand(a *App, args []string) int {
+	fs := newFlagSet("validate-allocations")
+	output := registerOutput(fs)
+	file := fs.String("file", targetAllocation.TargetAllocationFile, "allocation file to validate")
+	offline := fs.Bool("offline", false, "do not check the account keys against the accounts of the broker")
+	schema := fs.Bool("schema", false, "print the JSON Schema of the allocation file instead")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *schema {
+		os.Stdout.Write(targetAllocation.Schema)
+		return ExitOK
+	}
+
+	var accounts []targetAllocation.AccountIdentifier
+	if !*offline {
+		if err := a.Connect(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+		accounts = make([]targetAllocation.AccountIdentifier, len(a.accounts))
+		for i := range a.accounts {
+			accounts[i] = AccountIdentifier(&a.accounts[i])
+		}
+	}
+	problems, err := targetAllocation.ValidateTargetAllocations(*file, accounts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	if exitCode := output.write(AllocationProblemsReport(*file, problems)); exitCode != ExitOK {
+		return exitCode
+	}
+	if len(problems) > 0 {
+		return ExitError
+	}
+	return ExitOK
+}
+
+func AllocationProblemsReport(file string, problems []targetAllocation.Problem) report.AllocationProblems {
+	result := make(report.AllocationProblems, len(problems))
+	for i, p := range problems {
+		result[i] = report.AllocationProblem{
+			File:    file,
+			Line:    p.Line,
+			Column:  p.Column,
+			Account: p.Account,
+			Ticker:  p.Ticker,
+			Message: p.Message,
+		}
+	}
+	return result
+}
+
+func InvestCommand(a *App, args []string) int {
+	return planCommand(a, "invest", args, PlanInvestCash)
+}
+
+func RebalanceCommand(a *App, args []string) int {
+	return planCommand(a, "rebalance", args, PlanRebalance)
+}
+
+func planCommand(a *App, name string, args []string, planFunc func(*App, *Account) (Plan, error)) int {
+	fs := newFlagSet(name)
+	var of orderFlags
+	of.register(fs, true)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if of.account == "" {
+		fmt.Fprintln(os.Stderr, "--account is required")
+		fs.Usage()
+		return ExitUsage
+	}
+
+	if err := a.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	account, err := FindAccount(a.accounts, of.account)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	plan, err := planFunc(a, account)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	return executePlans(a, []Plan{plan}, of)
+}
+
+func HouseholdCommand(a *App, args []string) int {
+	fs := newFlagSet("household")
+	var of orderFlags
+	of.register(fs, false)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if err := a.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	plans, err := PlanHousehold(a)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	return executePlans(a, plans, of)
+}
+
+// sales relieve lots with the loss harvester method whatever the --lot-method
+func HarvestCommand(a *App, args []string) int {
+	fs := newFlagSet("harvest")
+	var of orderFlags
+	of.register(fs, false)
+	minLoss := fs.Float64("min-loss", DefaultHarvestMinLoss, "smallest unrealized loss of a position worth harvesting, in dollars")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if err := a.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	a.lotMethod = taxlot.LossHarvester
+	plans, err := PlanHarvest(a, *minLoss, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	return executePlans(a, plans, of)
+}
+
+// shows the plans and their previews and places them unless a preview rejects one
+func executePlans(a *App, plans []Plan, of orderFlags) int {
+	anyOrders := false
+	for _, plan := range plans {
+		anyOrders = anyOrders || len(plan.Orders) > 0
+	}
+
+	if of.output.format == report.Table || of.dryRun || !anyOrders {
+		if exitCode := of.output.write(PlansReport(plans)); exitCode != ExitOK || !anyOrders {
+			return exitCode
+		}
+	} else if !of.yes {
+		report.Write(os.Stderr, report.Table, PlansReport(plans))
+	}
+
+	previews, err := PreviewPlans(a, plans)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	if of.output.format == report.Table {
+		report.Write(os.Stdout, report.Table, previews)
+	} else {
+		report.Write(os.Stderr, report.Table, previews)
+	}
+	if previews.Rejected() {
+		fmt.Fprintln(os.Stderr, "orders were rejected, nothing was placed")
+		return ExitError
+	}
+	if of.dryRun {
+		return ExitOK
+	}
+
+	if !of.yes && !ConfirmProceed() {
+		return ExitCanceled
+	}
+	results := make(report.OrderResults, 0)
+	for _, plan := range plans {
+		placed, err := PlaceOrders(a, plan)
+		results = append(results, placed...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			of.output.write(results)
+			return ExitError
+		}
+	}
+	if !of.wait {
+		return of.output.write(results)
+	}
+	if of.output.format == report.Table {
+		of.output.write(results)
+	}
+	return waitForOrders(a, results, of)
+}
+
+// exits with an error unless every order and child order filled
+func waitForOrders(a *App, results report.OrderResults, of orderFlags) int {
+	orders := make([]trader.Order, 0, len(results))
+	exitCode := ExitOK
+	for _, result := range results {
+		account, err := FindAccount(a.accounts, result.Account)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+		order, err := TrackOrder(a, account, result.OrderID, TrackInterval, TrackTimeout, PrintOrderUpdate)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+		if !OrderFilled(order) {
+			exitCode = ExitError
+		}
+		orders = append(orders, order)
+	}
+	if writeExitCode := of.output.write(OrdersReport(orders)); writeExitCode != ExitOK {
+		return writeExitCode
+	}
+	return exitCode
+}
+
+func QuoteCommand(a *App, args []string) int {
+	fs := newFlagSet("quote")
+	output := registerOutput(fs)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "at least one ticker is required")
+		return ExitUsage
+	}
+	tickers := make([]string, fs.NArg())
+	for i, ticker := range fs.Args() {
+		tickers[i] = strings.ToUpper(ticker)
+	}
+
+	if err := a.ConnectClient(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	prices, err := GetAssetPrices(a, tickers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	exitCode := ExitOK
+	quotes := make(report.Quotes, 0, len(tickers))
+	for _, ticker := range tickers {
+		price, ok := prices[ticker]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "no quote for", ticker)
+			exitCode = ExitError
+			continue
+		}
+		quotes = append(quotes, report.Quote{Ticker: ticker, Price: price})
+	}
+	if writeExitCode := output.write(quotes); writeExitCode != ExitOK {
+		return writeExitCode
+	}
+	return exitCode
+}
+
+// connects and finds the account, printing the error and returning false on failure
+func connectAccount(a *App, identifier string) (*Account, bool) {
+	if err := a.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+	account, err := FindAccount(a.accounts, identifier)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+	return account, true
+}
+
+func OrdersCommand(a *App, args []string) int {
+	fs := newFlagSet("orders")
+	account := fs.String("account", "", "last 3 digits of the account number")
+	days := fs.Int("days", 7, "number of days to look back")
+	from := fs.String("from", "", "first day to list, YYYY-MM-DD, instead of --days")
+	to := fs.String("to", "", "last day to list, YYYY-MM-DD, defaults to today")
+	open := fs.Bool("open", false, "only list orders that can still be canceled or replaced")
+	output := registerOutput(fs)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *account == "" {
+		fmt.Fprintln(os.Stderr, "--account is required")
+		fs.Usage()
+		return ExitUsage
+	}
+	fromTime, toTime, err := OrderRange(*from, *to, *days, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	acc, ok := connectAccount(a, *account)
+	if !ok {
+		return ExitError
+	}
+	orders, err := ListOrders(a, acc, fromTime, toTime)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	if *open {
+		// children are listed on their own so they are not repeated under their parent
+		openOrders := OpenOrders(orders)
+		for i := range openOrders {
+			openOrders[i].ChildOrderStrategies = nil
+		}
+		orders = openOrders
+	}
+	return output.write(OrdersReport(orders))
+}
+
+func PendingCommand(a *App, args []string) int {
+	fs := newFlagSet("pending")
+	var of orderFlags
+	of.output = registerOutput(fs)
+	place := fs.Bool("place", false, "place the purchases that settled")
+	fs.BoolVar(&of.yes, "yes", false, "place without asking for confirmation")
+	fs.BoolVar(&of.wait, "wait", false, "wait for the orders to fill and print their final state")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	if !*place {
+		return of.output.write(PendingPlansReport(plans, time.Now()))
+	}
+	report.Write(os.Stderr, report.Table, PendingPlansReport(plans, time.Now()))
+	if !of.yes && !ConfirmProceed() {
+		return ExitCanceled
+	}
+	if err := a.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	results, err := PlacePendingPlans(a, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		of.output.write(results)
+		return ExitError
+	}
+	if !of.wait {
+		return of.output.write(results)
+	}
+	return waitForOrders(a, results, of)
+}
+
+func CancelOrderCommand(a *App, args []string) int {
+	fs := newFlagSet("cancel-order")
+	account := fs.String("account", "", "last 3 digits of the account number")
+	id := fs.Int64("id", 0, "id of the order")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *account == "" || *id == 0 {
+		fmt.Fprintln(os.Stderr, "--account and --id are required")
+		fs.Usage()
+		return ExitUsage
+	}
+
+	acc, ok := connectAccount(a, *account)
+	if !ok {
+		return ExitError
+	}
+	if err := CancelOrder(a, acc, *id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	fmt.Fprintf(os.Stderr, "Order #%v canceled\n", *id)
+	return ExitOK
+}
+
+// repeatable TICKER=QUANTITY flag
+type quantitiesFlag map[string]float64
+
+func (qf quantitiesFlag) String() string {
+	return fmt.Sprint(map[string]float64(qf))
+}
+
+func (qf quantitiesFlag) Set(s string) error {
+	ticker, quantity, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected TICKER=QUANTITY, got %q", s)
+	}
+	q, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return fmt.Errorf("invalid quantity in %q", s)
+	}
+	qf[strings.ToUpper(ticker)] = q
+	return nil
+}
+
+func ReplaceOrderCommand(a *App, args []string) int {
+	fs := newFlagSet("replace-order")
+	var of orderFlags
+	of.register(fs, true)
+	id := fs.Int64("id", 0, "id of the order")
+	quantities := make(quantitiesFlag)
+	fs.Var(quantities, "quantity", "new quantity of a leg as TICKER=QUANTITY, 0 drops the leg, repeatable")
+	price := fs.Float64("price", 0, "new limit price")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if of.account == "" || *id == 0 {
+		fmt.Fprintln(os.Stderr, "--account and --id are required")
+		fs.Usage()
+		return ExitUsage
+	}
+
+	acc, ok := connectAccount(a, of.account)
+	if !ok {
+		return ExitError
+	}
+	order, err := a.broker.GetOrder(acc.AccountHashValue, *id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to get order:", err)
+		return ExitError
+	}
+	if !OrderCancelable(order) {
+		fmt.Fprintf(os.Stderr, "order #%v is %v and can not be replaced\n", order.OrderID, order.Status)
+		return ExitError
+	}
+	replacement, err := BuildReplacementOrder(order, quantities, *price)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+
+	if of.dryRun {
+		return of.output.write(OrdersReport([]trader.Order{replacement}))
+	}
+	if !of.yes {
+		report.Write(os.Stderr, report.Table, OrdersReport([]trader.Order{replacement}))
+		if !ConfirmProceed() {
+			return ExitCanceled
+		}
+	}
+	result, err := ReplaceOrder(a, acc, *id, replacement)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	results := report.OrderResults{result}
+	if !of.wait {
+		return of.output.write(results)
+	}
+	if of.output.format == report.Table {
+		of.output.write(results)
+	}
+	return waitForOrders(a, results, of)
+}
+
+func MockServerCommand(a *App, args []string) int {
+	fs := newFlagSet("mock-server")
+	addr := fs.String("addr", "127.0.0.1:8183", "address to listen on")
+	fixtures := fs.String("fixtures", "schwabMock/fixtures", "directory containing accounts.json and quotes.json")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	fb, err := schwabMock.LoadFixtures(*fixtures)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	fmt.Fprintf(os.Stderr, "serving mock schwab api, run the app with SCHWAB_API_BASE_URL=http://%v\n", *addr)
+	err = http.ListenAndServe(*addr, schwabMock.NewServer(fb))
+	fmt.Fprintln(os.Stderr, err)
+	return ExitError
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+func TestValidateAllocationsCommand(t *testing.T) {
+	a, _ := newTestApp(t)
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"--output", "csv"}, ExitOK},
+		// 456 in the accounts section is not an account
+		{[]string{"--output", "csv", "--file", "../targetAllocation/testing/targetAllocation_targetAllocationTest6.yaml"}, ExitError},
+		{[]string{"--output", "csv", "--offline", "--file", "../targetAllocation/testing/targetAllocation_targetAllocationTest6.yaml"}, ExitOK},
+		{[]string{"--file", "missing.yaml"}, ExitError},
+		{[]string{"--output", "xml"}, ExitUsage},
+	}
+	for i, test := range tests {
+		if code := ValidateAllocationsCommand(a, test.args); code != test.expected {
+			t.Errorf("expected exit code %v, got %v on test index %v", test.expected, code, i)
+		}
+	}
+
+	problems := AllocationProblemsReport("targetAllocation.yaml", []targetAllocation.Problem{{Account: "123", Ticker: "DFAC", Line: 3, Column: 5, Message: "negative proportion"}})
+	expected := report.AllocationProblem{File: "targetAllocation.yaml", Line: 3, Column: 5, Account: "123", Ticker: "DFAC", Message: "negative proportion"}
+	if len(problems) != 1 || problems[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, problems)
+	}
+}
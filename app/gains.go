@@ -0,0 +1,80 @@
+package app
+
+import (
+	"maps"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+)
+
+// orders of a plan with the tracking error they leave and the gains they realize
+type PlanOutcome struct {
+	Orders        map[string]float64
+	Cash          float64
+	TrackingError float64
+	Gains         taxlot.Gains
+}
+
+// the plan within the gain budget next to the plan without it
+type GainBudgetComparison struct {
+	Budget        balance.GainBudget
+	Unconstrained PlanOutcome
+	Constrained   PlanOutcome
+}
+
+// gains of selling the first shares the lot method relieves
+func lotGains(lots map[string][]taxlot.Lot, prices map[string]float64, method taxlot.Method, now time.Time) balance.GainFunc {
+	return func(ticker string, shares float64) (float64, float64) {
+		if shares <= 0 {
+			return 0, 0
+		}
+		gains := taxlot.Realized(taxlot.Select(lots[ticker], shares, prices[ticker], method, now))
+		return gains.ShortTerm, gains.LongTerm
+	}
+}
+
+// outcome of the orders placed with the cash before trading
+func planOutcome(orders map[string]float64, cash float64, holdings map[string]float64, plan Plan, gains balance.GainFunc) PlanOutcome {
+	prices := plan.Assets.Prices()
+	after := maps.Clone(holdings)
+	for ticker, quantity := range orders {
+		cash -= quantity * prices[ticker]
+	}
+	outcome := PlanOutcome{Orders: orders, Cash: cash}
+	for ticker, quantity := range orders {
+		after[ticker] += quantity
+		if quantity < 0 {
+			shortTerm, longTerm := gains(ticker, -quantity)
+			outcome.Gains.ShortTerm += shortTerm
+			outcome.Gains.LongTerm += longTerm
+		}
+	}
+	outcome.TrackingError = balance.TrackingError(cash, after, prices, plan.Allocation)
+	return outcome
+}
+
+// replaces the sales of the plan with those realizing gains within the app's gain budget
+func ApplyGainBudget(a *App, plan Plan, holdings map[string]float64, lots map[string][]taxlot.Lot, now time.Time) Plan {
+	prices := plan.Assets.Prices()
+	gains := lotGains(lots, prices, a.lotMethod, now)
+	cash := plan.Account.SecuritiesAccount.InitialBalances.CashBalance
+	var orders map[string]float64
+	var cashLeft float64
+	if targetAllocation.HasBands(plan.Allocation) {
+		orders, cashLeft = balance.RebalanceWithBandsGainBudget(cash, holdings, prices, plan.Allocation, plan.Assets.DollarTickers(), a.bandEdge, plan.Orders, gains, *a.gainBudget)
+	} else {
+		orders, cashLeft = balance.RebalanceWithGainBudget(cash, holdings, prices, plan.Allocation, plan.Assets.DollarTickers(), plan.Orders, gains, *a.gainBudget)
+	}
+
+	comparison := &GainBudgetComparison{
+		Budget:        *a.gainBudget,
+		Unconstrained: planOutcome(plan.Orders, cash, holdings, plan, gains),
+		Constrained:   planOutcome(orders, cash, holdings, plan, gains),
+	}
+	plan.Orders = orders
+	plan.Cash = cashLeft
+	plan.GainBudget = comparison
+	return plan
+}
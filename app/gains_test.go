@@ -0,0 +1,62 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+func TestRebalanceGainBudget(t *testing.T) {
+	a, _ := newTestApp(t)
+	// the VWO shares have no cost basis, every share sold gains $10
+	a.gainBudget = &balance.GainBudget{MaxGain: 25}
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Orders["VWO"] != -2 {
+		t.Errorf("expected 2 VWO to be sold within the budget, got %v", plan.Orders)
+	}
+	comparison := plan.GainBudget
+	if comparison == nil || comparison.Unconstrained.Orders["VWO"] != -4 || comparison.Unconstrained.Gains.LongTerm != 40 {
+		t.Fatalf("expected the unconstrained plan to sell 4 VWO for $40 of gains, got %+v", comparison)
+	}
+	if comparison.Constrained.Gains.LongTerm != 20 || comparison.Constrained.TrackingError < comparison.Unconstrained.TrackingError {
+		t.Errorf("expected $20 of gains at a higher tracking error, got %+v", comparison)
+	}
+	if gains := plan.Lots.Gains(); gains.LongTerm != 20 {
+		t.Errorf("expected the lot estimate to match the budgeted gains, got %+v", gains)
+	}
+}
+
+// the proceeds of the budgeted sales only buy the assets outside of their bands
+func TestRebalanceGainBudgetBands(t *testing.T) {
+	a, _ := newTestApp(t)
+	targetAllocation.TargetAllocationFile = "testing/targetAllocation_appTest2.yaml"
+	a.gainBudget = &balance.GainBudget{MaxGain: 15}
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]float64{"VWO": -1, "VTI": 20}; !reflect.DeepEqual(plan.Orders, expected) {
+		t.Errorf("expected orders %v, got %v", expected, plan.Orders)
+	}
+	// both outcomes start from the 202.12 of cash
+	comparison := plan.GainBudget
+	if !util.AlmostEqual(comparison.Unconstrained.Cash, 32.12, 1e-9) || !util.AlmostEqual(comparison.Constrained.Cash, 12.12, 1e-9) {
+		t.Errorf("expected $32.12 and $12.12 left, got %+v", comparison)
+	}
+}
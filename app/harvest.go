@@ -0,0 +1,93 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+)
+
+// unrealized loss in dollars a position needs to be harvested when no other is given
+const DefaultHarvestMinLoss = 100
+
+// ticker a harvested position is swapped into: the substitute of an original and the original of a substitute
+func harvestReplacement(substitutes targetAllocation.Substitutes, ticker string) (string, bool) {
+	if substitute, ok := substitutes[ticker]; ok {
+		return substitute, true
+	}
+	return substitutes.Original(ticker)
+}
+
+// plans selling the lots at a loss of positions losing at least minLoss and buying their substitutes
+func PlanHarvest(a *App, minLoss float64, now time.Time) ([]Plan, error) {
+	substitutes, err := LoadSubstitutes()
+	if err != nil {
+		return nil, err
+	}
+	if len(substitutes) == 0 {
+		return nil, fmt.Errorf("no substitutes in %v to harvest into", targetAllocation.TargetAllocationFile)
+	}
+
+	plans := make([]Plan, 0)
+	for i := range a.accounts {
+		account := &a.accounts[i]
+		candidates := make([]string, 0)
+		for _, pos := range account.SecuritiesAccount.Positions {
+			if _, ok := harvestReplacement(substitutes, pos.Instrument.Symbol); ok && pos.LongOpenProfitLoss <= -minLoss {
+				candidates = append(candidates, pos.Instrument.Symbol)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		tickers := slices.Clone(candidates)
+		for _, ticker := range candidates {
+			replacement, _ := harvestReplacement(substitutes, ticker)
+			tickers = append(tickers, replacement)
+		}
+		assets, err := GetAssets(a, tickers)
+		if err != nil {
+			return nil, err
+		}
+		lots, err := GetLots(a, account, now)
+		if err != nil {
+			return nil, err
+		}
+
+		plan := Plan{Account: account, Orders: make(map[string]float64), Cash: account.SecuritiesAccount.InitialBalances.CashBalance, Assets: assets}
+		for _, ticker := range candidates {
+			replacement, _ := harvestReplacement(substitutes, ticker)
+			price, replacementPrice := assets[ticker].Price, assets[replacement].Price
+			if price <= 0 || replacementPrice <= 0 {
+				return nil, fmt.Errorf("no price to harvest %v into %v", ticker, replacement)
+			}
+			shares, loss := 0.0, 0.0
+			for _, lot := range lots[ticker] {
+				if lot.Cost > price {
+					shares += lot.Quantity
+					loss += lot.Quantity * (lot.Cost - price)
+				}
+			}
+			if loss < minLoss || shares <= 0 {
+				continue
+			}
+			proceeds := shares * price
+			purchase := proceeds / replacementPrice
+			if !TradesInDollars(assets.AssetType(replacement)) {
+				purchase = math.Floor(purchase + 1e-9)
+			}
+			plan.Orders[ticker] -= shares
+			plan.Orders[replacement] += purchase
+			plan.Cash += proceeds - purchase*replacementPrice
+		}
+		if len(plan.Orders) == 0 {
+			continue
+		}
+		plans = append(plans, estimateLotSales(plan, lots, taxlot.LossHarvester, now))
+	}
+	return GuardWashSales(a, plans, now)
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+)
+
+func TestHarvestSubstitutes(t *testing.T) {
+	a, fb := newTestApp(t)
+	fb.SetQuote("ITOT", 20)
+	// 10 VTI bought at 15 trading at 10
+	fb.Ledger.Accounts[1].SecuritiesAccount.Positions[2].AveragePrice = 15
+	fb.SetQuote("VTI", 10)
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	plans, err := PlanHarvest(a, 60, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 0 {
+		t.Errorf("expected a loss of 50 not to be harvested with a minimum of 60, got %+v", plans)
+	}
+	plans, err = PlanHarvest(a, 40, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 || !reflect.DeepEqual(plans[0].Orders, map[string]float64{"VTI": -10, "ITOT": 5}) {
+		t.Fatalf("expected VTI to be swapped for ITOT, got %+v", plans)
+	}
+	if plans[0].Lots == nil || plans[0].Lots.Method != taxlot.LossHarvester || plans[0].Lots.Gains().LongTerm != -50 {
+		t.Errorf("expected a long term loss of 50 relieved by the loss harvester, got %+v", plans[0].Lots)
+	}
+	if _, err := PlaceOrders(a, plans[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	// ITOT counts toward VTI, underweight VTI buys more ITOT instead of VTI
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plan.Orders["VTI"]; ok || plan.Orders["ITOT"] <= 0 {
+		t.Errorf("expected ITOT to be bought in place of VTI, got %v", plan.Orders)
+	}
+
+	orders, cash := unfoldSubstitutes(map[string]float64{"VTI": -15}, 0, map[string]float64{"VTI": 10}, map[string]float64{"ITOT": 5}, targetAllocation.Substitutes{"VTI": "ITOT"}, map[string]float64{"VTI": 10, "ITOT": 20}, nil)
+	if !reflect.DeepEqual(orders, map[string]float64{"VTI": -10, "ITOT": -3}) || cash != 10 {
+		t.Errorf("expected the 5 VTI sold beyond the holdings to sell 3 ITOT, got %v and cash %v", orders, cash)
+	}
+}
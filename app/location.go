@@ -0,0 +1,98 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+// target allocation of an account placed by asset location
+type LocatedAllocation struct {
+	Account    *Account
+	Settings   targetAllocation.AccountSettings
+	Allocation targetAllocation.TargetAllocation
+}
+
+// per account targets holding the global allocation in the account types of its location preferences
+func PlanLocation(a *App) ([]LocatedAllocation, error) {
+	targetAllocations, err := targetAllocation.LoadTargetAllocations(targetAllocation.TargetAllocationFile)
+	if err != nil {
+		return nil, errors.New("failed to load targetAllocations: " + err.Error())
+	}
+	globalAllocation, ok := targetAllocations[targetAllocation.GlobalAccountIdentifier]
+	if !ok {
+		return nil, errors.New("no global allocation found in " + targetAllocation.TargetAllocationFile)
+	}
+	settings, err := targetAllocation.LoadAccountSettings(targetAllocation.TargetAllocationFile)
+	if err != nil {
+		return nil, errors.New("failed to load account settings: " + err.Error())
+	}
+	// locate by current account values, not those of the last account listing
+	if err := a.RefreshAccounts(); err != nil {
+		return nil, err
+	}
+
+	accounts := make([]balance.LocationAccount, len(a.accounts))
+	for i := range a.accounts {
+		accountSettings, ok := settings[AccountIdentifier(&a.accounts[i])]
+		if !ok {
+			return nil, fmt.Errorf("no type for account ********%v in the %v section of %v", AccountIdentifier(&a.accounts[i]), targetAllocation.AccountsKey, targetAllocation.TargetAllocationFile)
+		}
+		accounts[i] = balance.LocationAccount{
+			Type:    accountSettings.Type,
+			Value:   a.accounts[i].SecuritiesAccount.InitialBalances.AccountValue,
+			MaxCash: math.Inf(1),
+		}
+		if accountSettings.MaxCash != nil {
+			accounts[i].MaxCash = *accountSettings.MaxCash
+		}
+	}
+	located, err := balance.LocateAssets(accounts, targetAllocation.Flatten(globalAllocation))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]LocatedAllocation, len(a.accounts))
+	for i := range a.accounts {
+		result[i] = LocatedAllocation{
+			Account:    &a.accounts[i],
+			Settings:   settings[AccountIdentifier(&a.accounts[i])],
+			Allocation: located[i],
+		}
+	}
+	return result, nil
+}
+
+func LocationsReport(located []LocatedAllocation) report.Locations {
+	result := make(report.Locations, 0, len(located))
+	for _, l := range located {
+		accountValue := l.Account.SecuritiesAccount.InitialBalances.AccountValue
+		fixed := 0.0
+		for _, allocation := range l.Allocation {
+			fixed += allocation.FixedCashValue
+		}
+		targets := make([]report.LocatedTarget, 0, len(l.Allocation))
+		for _, ticker := range slices.Sorted(maps.Keys(l.Allocation)) {
+			allocation := l.Allocation[ticker]
+			targets = append(targets, report.LocatedTarget{
+				Ticker:         ticker,
+				Proportion:     allocation.Proportion,
+				FixedCashValue: allocation.FixedCashValue,
+				Value:          allocation.FixedCashValue + allocation.Proportion*(accountValue-fixed),
+			})
+		}
+		result = append(result, report.LocatedAccount{
+			Account:      AccountIdentifier(l.Account),
+			Type:         string(l.Settings.Type),
+			AccountValue: accountValue,
+			Targets:      targets,
+		})
+	}
+	return result
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+func TestPlanLocation(t *testing.T) {
+	a, _ := newTestApp(t)
+	targetAllocation.TargetAllocationFile = "testing/targetAllocation_appTest3.yaml"
+	// a stale listing is refreshed before locating
+	a.accounts[1].SecuritiesAccount.InitialBalances.AccountValue = 0
+
+	located, err := PlanLocation(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(located) != 2 || AccountIdentifier(located[0].Account) != "123" || located[0].Settings.Type != targetAllocation.Taxable {
+		t.Fatalf("expected targets for the taxable account 123 first, got %+v", located)
+	}
+	// the cash only fits in 567, whose remaining 602.12 goes to DFIC, the rest of DFIC and DFAC and DFEM fill 123
+	roth := located[1].Allocation
+	if len(roth) != 2 || roth["SWVXX"].FixedCashValue != 4000 || !util.AlmostEqual(roth["DFIC"].Proportion, 1, 1e-9) {
+		t.Errorf("expected the cash and DFIC in the roth account, got %+v", roth)
+	}
+	taxable := located[0].Allocation
+	sum := 0.0
+	for _, allocation := range taxable {
+		sum += allocation.Proportion
+	}
+	if !util.AlmostEqual(sum, 1, 1e-9) || !util.AlmostEqual(taxable["DFIC"].Proportion*5901.1, 0.27*6503.22-602.12, 1e-6) {
+		t.Errorf("expected the rest of DFIC with DFAC and DFEM in the taxable account, got %+v", taxable)
+	}
+
+	report := LocationsReport(located)
+	if len(report) != 2 || report[1].Targets[0].Ticker != "DFIC" || !util.AlmostEqual(report[1].Targets[0].Value, 602.12, 1e-6) {
+		t.Errorf("expected $602.12 of DFIC in 567, got %+v", report)
+	}
+}
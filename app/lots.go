@@ -0,0 +1,85 @@
+package app
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+// lots relieved by the sales of a plan when its orders are placed with Method
+type LotSales struct {
+	Method taxlot.Method
+	Sales  map[string][]taxlot.Sale
+}
+
+func (ls *LotSales) Gains() taxlot.Gains {
+	if ls == nil {
+		return taxlot.Gains{}
+	}
+	return taxlot.RealizedAll(ls.Sales)
+}
+
+// open lots of the positions, rebuilt from the last year of trades and the average cost of the rest
+func GetLots(a *App, account *Account, now time.Time) (map[string][]taxlot.Lot, error) {
+	transactions, err := a.broker.ListTransactions(account.AccountHashValue, now.AddDate(-1, 0, 0), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions of account ********%v: %w", AccountIdentifier(account), err)
+	}
+	traded, err := taxlot.FromTransactions(transactions)
+	if err != nil {
+		return nil, err
+	}
+	lots := make(map[string][]taxlot.Lot)
+	for _, pos := range account.SecuritiesAccount.Positions {
+		ticker := pos.Instrument.Symbol
+		lots[ticker] = taxlot.Reconcile(traded[ticker], ticker, pos.LongQuantity, cmp.Or(pos.TaxLotAverageLongPrice, pos.AveragePrice))
+	}
+	return lots, nil
+}
+
+// estimates the lots the sales of the plan relieve with the lot method of the app
+func EstimateLotSales(a *App, plan Plan, now time.Time) (Plan, error) {
+	sales := plan.Sales()
+	if len(sales) == 0 {
+		return plan, nil
+	}
+	lots, err := GetLots(a, plan.Account, now)
+	if err != nil {
+		return plan, err
+	}
+	return estimateLotSales(plan, lots, a.lotMethod, now), nil
+}
+
+func estimateLotSales(plan Plan, lots map[string][]taxlot.Lot, method taxlot.Method, now time.Time) Plan {
+	sales := plan.Sales()
+	plan.Lots = nil
+	if len(sales) == 0 {
+		return plan
+	}
+	plan.Lots = &LotSales{Method: method, Sales: make(map[string][]taxlot.Sale)}
+	for _, ticker := range slices.Sorted(maps.Keys(sales)) {
+		plan.Lots.Sales[ticker] = taxlot.Select(lots[ticker], -sales[ticker], plan.Assets[ticker].Price, method, now)
+	}
+	return plan
+}
+
+// sets the lot method of the app on the order and its child orders that sell
+func (a *App) withLotMethod(order trader.Order) trader.Order {
+	for _, leg := range order.OrderLegCollection {
+		if leg.Instruction == "SELL" {
+			order.TaxLotMethod = string(a.lotMethod)
+			break
+		}
+	}
+	children := slices.Clone(order.ChildOrderStrategies)
+	for i := range children {
+		children[i] = a.withLotMethod(children[i])
+	}
+	order.ChildOrderStrategies = children
+	return order
+}
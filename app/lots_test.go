@@ -0,0 +1,57 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+)
+
+func TestRebalanceLotSales(t *testing.T) {
+	a, fb := newTestApp(t)
+	a.lotMethod = taxlot.LIFO
+	fb.Ledger.Accounts[1].SecuritiesAccount.Positions[3].AveragePrice = 8
+
+	// 5 more VWO bought two months ago at 12, now trading at 10
+	now := time.Now()
+	fb.Now = func() time.Time { return now.AddDate(0, -2, 0) }
+	fb.SetQuote("VWO", 12)
+	if _, err := fb.PlaceOrder("hash567", BuildBuyOrder(map[string]float64{"VWO": 5}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	fb.Now = func() time.Time { return now }
+	fb.SetQuote("VWO", 10)
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Orders["VWO"] != -9 {
+		t.Fatalf("expected 9 VWO to be sold, got %v", plan.Orders)
+	}
+	if plan.Lots == nil || plan.Lots.Method != taxlot.LIFO {
+		t.Fatalf("expected lots estimated with LIFO, got %+v", plan.Lots)
+	}
+	sales := plan.Lots.Sales["VWO"]
+	if len(sales) != 2 || sales[0].Quantity != 5 || sales[0].Lot.Cost != 12 || sales[0].LongTerm || sales[1].Quantity != 4 || sales[1].Lot.Cost != 8 || !sales[1].LongTerm {
+		t.Errorf("expected the 5 recent shares and then 4 older shares to be sold, got %+v", sales)
+	}
+	if gains := plan.Lots.Gains(); gains.ShortTerm != -10 || gains.LongTerm != 8 {
+		t.Errorf("expected a short term loss of 10 and a long term gain of 8, got %+v", gains)
+	}
+
+	if _, err := PlaceOrders(a, plan); err != nil {
+		t.Fatal(err)
+	}
+	orders := fb.Ledger.Orders["hash567"]
+	if method := orders[len(orders)-1].TaxLotMethod; method != "LIFO" {
+		t.Errorf("expected the sell order to relieve lots LIFO, got %q", method)
+	}
+}
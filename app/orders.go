@@ -0,0 +1,278 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+const DateFormat = "2006-01-02"
+
+// entered times to list orders for, from and to are dates and both are included
+func OrderRange(from string, to string, days int, now time.Time) (time.Time, time.Time, error) {
+	if from == "" {
+		if to != "" {
+			return time.Time{}, time.Time{}, errors.New("a to date needs a from date")
+		}
+		return now.AddDate(0, 0, -days), now, nil
+	}
+	fromTime, err := time.ParseInLocation(DateFormat, from, now.Location())
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", from)
+	}
+	toTime := now
+	if to != "" {
+		toTime, err = time.ParseInLocation(DateFormat, to, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", to)
+		}
+		toTime = toTime.AddDate(0, 0, 1).Add(-time.Millisecond)
+	}
+	if toTime.Before(fromTime) {
+		return time.Time{}, time.Time{}, errors.New("from date is after to date")
+	}
+	return fromTime, toTime, nil
+}
+
+// searches child orders as well
+func FindOrder(orders []trader.Order, orderID int64) *trader.Order {
+	for i := range orders {
+		if orders[i].OrderID == orderID {
+			return &orders[i]
+		}
+		if child := FindOrder(orders[i].ChildOrderStrategies, orderID); child != nil {
+			return child
+		}
+	}
+	return nil
+}
+
+func OrderCancelable(order trader.Order) bool {
+	return order.Cancelable && !slices.Contains(TerminalOrderStatuses, order.Status)
+}
+
+// cancelable orders including child orders, e.g. the buys of a trigger order whose sells did not fill
+func OpenOrders(orders []trader.Order) []trader.Order {
+	open := make([]trader.Order, 0)
+	for _, order := range orders {
+		if OrderCancelable(order) {
+			open = append(open, order)
+		}
+		open = append(open, OpenOrders(order.ChildOrderStrategies)...)
+	}
+	return open
+}
+
+// copy of the order with new leg quantities by ticker and a new limit price unless price is 0
+func BuildReplacementOrder(order trader.Order, quantities map[string]float64, price float64) (trader.Order, error) {
+	if len(order.ChildOrderStrategies) > 0 {
+		return trader.Order{}, fmt.Errorf("order #%v triggers other orders, cancel it instead", order.OrderID)
+	}
+	if price != 0 && order.OrderType != "LIMIT" {
+		return trader.Order{}, fmt.Errorf("order #%v is a %v order and has no price", order.OrderID, order.OrderType)
+	}
+	for ticker := range quantities {
+		if !slices.ContainsFunc(order.OrderLegCollection, func(leg trader.OrderLeg) bool { return leg.Instrument.Symbol == ticker }) {
+			return trader.Order{}, fmt.Errorf("order #%v has no leg for %v", order.OrderID, ticker)
+		}
+	}
+
+	replacement := trader.Order{
+		OrderType:          order.OrderType,
+		Session:            order.Session,
+		Duration:           order.Duration,
+		Price:              order.Price,
+		TaxLotMethod:       order.TaxLotMethod,
+		Cancelable:         true,
+		OrderStrategyType:  "SINGLE",
+		OrderLegCollection: make([]trader.OrderLeg, 0, len(order.OrderLegCollection)),
+	}
+	if price != 0 {
+		replacement.Price = price
+	}
+	for _, leg := range order.OrderLegCollection {
+		quantity, ok := quantities[leg.Instrument.Symbol]
+		if !ok {
+			quantity = leg.Quantity
+		}
+		if quantity < 0 {
+			return trader.Order{}, fmt.Errorf("negative quantity for %v", leg.Instrument.Symbol)
+		}
+		if quantity == 0 {
+			continue
+		}
+		replacement.OrderLegCollection = append(replacement.OrderLegCollection, trader.OrderLeg{
+			Instruction:  leg.Instruction,
+			Quantity:     quantity,
+			QuantityType: leg.QuantityType,
+			Instrument:   trader.Instrument{Symbol: leg.Instrument.Symbol, AssetType: leg.Instrument.AssetType},
+		})
+	}
+	if len(replacement.OrderLegCollection) == 0 {
+		return trader.Order{}, errors.New("replacement has no legs, cancel the order instead")
+	}
+	return replacement, nil
+}
+
+func CancelOrder(a *App, account *Account, orderID int64) error {
+	if err := a.broker.CancelOrder(account.AccountHashValue, orderID); err != nil {
+		return fmt.Errorf("failed to cancel order #%v: %w", orderID, err)
+	}
+	return nil
+}
+
+func ReplaceOrder(a *App, account *Account, orderID int64, replacement trader.Order) (report.OrderResult, error) {
+	newID, err := a.broker.ReplaceOrder(account.AccountHashValue, orderID, replacement)
+	if err != nil {
+		return report.OrderResult{}, fmt.Errorf("failed to replace order #%v: %w", orderID, err)
+	}
+	return report.OrderResult{Account: AccountIdentifier(account), OrderID: newID}, nil
+}
+
+// orders of the range, newest first
+func ListOrders(a *App, account *Account, from time.Time, to time.Time) ([]trader.Order, error) {
+	orders, err := GetOrders(a, account, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	slices.SortFunc(orders, func(x, y trader.Order) int { return strings.Compare(y.EnteredTime, x.EnteredTime) })
+	return orders, nil
+}
+
+func ManageOrdersSelectAccountHandler(a *App) AppHandler {
+	PrintAccounts(a.accounts)
+	fmt.Println("\nSelect account to manage orders of, q to cancel")
+
+	for {
+		var input int
+		_, err := fmt.Scan(&input)
+		if err != nil {
+			return MainOptionsHandler
+		}
+
+		if input >= 0 && input < len(a.accounts) {
+			return ManageOrdersHandlerFunc(a, &a.accounts[input])
+		}
+		fmt.Println("invalid input")
+	}
+}
+
+// prompts for a from date or a number of days to look back, and a to date
+func ScanOrderRange() (time.Time, time.Time, error) {
+	fmt.Println("From date (YYYY-MM-DD) or number of days to look back")
+	var from string
+	if _, err := fmt.Scan(&from); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if days, err := strconv.Atoi(from); err == nil {
+		return OrderRange("", "", days, time.Now())
+	}
+	fmt.Println("To date (YYYY-MM-DD), or \"now\"")
+	var to string
+	if _, err := fmt.Scan(&to); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if to == "now" {
+		to = ""
+	}
+	return OrderRange(from, to, 0, time.Now())
+}
+
+func ManageOrdersHandlerFunc(a *App, account *Account) AppHandler {
+	return func(a *App) AppHandler {
+		from, to, err := ScanOrderRange()
+		if err != nil {
+			return ErrorHandlerFunc(err)
+		}
+		orders, err := ListOrders(a, account, from, to)
+		if err != nil {
+			return ErrorHandlerFunc(err)
+		}
+		PrintOrders(orders)
+
+		if len(OpenOrders(orders)) == 0 {
+			fmt.Println("No open orders")
+			return MainOptionsHandler
+		}
+		fmt.Println("\nEnter the id of an open order to cancel or replace it, q to go back")
+		for {
+			var input int64
+			_, err := fmt.Scan(&input)
+			if err != nil {
+				return MainOptionsHandler
+			}
+
+			order := FindOrder(orders, input)
+			if order != nil && OrderCancelable(*order) {
+				return OrderActionHandlerFunc(a, account, *order)
+			}
+			fmt.Println("invalid input, not an open order")
+		}
+	}
+}
+
+func OrderActionHandlerFunc(a *App, account *Account, order trader.Order) AppHandler {
+	return func(a *App) AppHandler {
+		PrintOrders([]trader.Order{order})
+		fmt.Println("\nc to cancel, r to replace, anything else to go back")
+
+		var input string
+		fmt.Scan(&input)
+		switch input {
+		case "c":
+			if err := CancelOrder(a, account, order.OrderID); err != nil {
+				return ErrorHandlerFunc(err)
+			}
+			fmt.Printf("Order #%v canceled\n", order.OrderID)
+			if err := a.RefreshAccounts(); err != nil {
+				return ErrorHandlerFunc(err)
+			}
+		case "r":
+			return ReplaceOrderHandlerFunc(a, account, order)
+		}
+		return MainOptionsHandler
+	}
+}
+
+func ReplaceOrderHandlerFunc(a *App, account *Account, order trader.Order) AppHandler {
+	return func(a *App) AppHandler {
+		quantities := make(map[string]float64)
+		for _, leg := range order.OrderLegCollection {
+			fmt.Printf("New quantity to %v of %v (currently %v), 0 to drop\n", leg.Instruction, leg.Instrument.Symbol, leg.Quantity)
+			var quantity float64
+			if _, err := fmt.Scan(&quantity); err != nil {
+				return MainOptionsHandler
+			}
+			quantities[leg.Instrument.Symbol] = quantity
+		}
+		price := 0.0
+		if order.OrderType == "LIMIT" {
+			fmt.Printf("New limit price (currently $%.2f), 0 to keep\n", order.Price)
+			if _, err := fmt.Scan(&price); err != nil {
+				return MainOptionsHandler
+			}
+		}
+
+		replacement, err := BuildReplacementOrder(order, quantities, price)
+		if err != nil {
+			return ErrorHandlerFunc(err)
+		}
+		report.Write(os.Stdout, report.Table, OrdersReport([]trader.Order{replacement}))
+		if !ConfirmProceed() {
+			return MainOptionsHandler
+		}
+		result, err := ReplaceOrder(a, account, order.OrderID, replacement)
+		if err != nil {
+			return ErrorHandlerFunc(err)
+		}
+		fmt.Printf("Order #%v replaced by #%v\n", order.OrderID, result.OrderID)
+		return TrackOrdersHandlerFunc(report.OrderResults{result})
+	}
+}
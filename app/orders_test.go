@@ -0,0 +1,127 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func TestManageOrders(t *testing.T) {
+	a, fb := newTestApp(t)
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := fb.PlaceOrder("hash567", trader.Order{
+		OrderType:         "LIMIT",
+		Price:             9,
+		Session:           "NORMAL",
+		Duration:          "DAY",
+		OrderStrategyType: "SINGLE",
+		OrderLegCollection: []trader.OrderLeg{
+			{Instruction: "BUY", Quantity: 5, Instrument: trader.Instrument{Symbol: "VTI", AssetType: "EQUITY"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to, err := OrderRange("", "", 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := ListOrders(a, account, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := OpenOrders(orders)
+	if len(open) != 1 || open[0].OrderID != id {
+		t.Fatalf("expected order %v to be open, got %+v", id, open)
+	}
+
+	if _, err := BuildReplacementOrder(open[0], map[string]float64{"VXUS": 1}, 0); err == nil {
+		t.Error("expected error replacing a leg the order does not have")
+	}
+	replacement, err := BuildReplacementOrder(open[0], map[string]float64{"VTI": 3}, 9.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ReplaceOrder(a, account, id, replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced, _ := fb.GetOrder("hash567", result.OrderID)
+	if replaced.Status != "WORKING" || replaced.Price != 9.5 || replaced.OrderLegCollection[0].Quantity != 3 {
+		t.Errorf("expected working replacement for 3 VTI at 9.5, got %+v", replaced)
+	}
+
+	if err := CancelOrder(a, account, result.OrderID); err != nil {
+		t.Fatal(err)
+	}
+	if err := CancelOrder(a, account, result.OrderID); err == nil {
+		t.Error("expected error canceling a canceled order")
+	}
+	orders, _ = ListOrders(a, account, from, to)
+	if len(OpenOrders(orders)) != 0 {
+		t.Errorf("expected no open orders, got %+v", OpenOrders(orders))
+	}
+}
+
+func TestOrderRange(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		from         string
+		to           string
+		days         int
+		expectedFrom time.Time
+		expectedTo   time.Time
+		expectErr    bool
+	}{
+		{days: 7, expectedFrom: now.AddDate(0, 0, -7), expectedTo: now},
+		{from: "2025-03-01", expectedFrom: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), expectedTo: now},
+		{from: "2025-03-01", to: "2025-03-02", expectedFrom: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), expectedTo: time.Date(2025, 3, 2, 23, 59, 59, 999000000, time.UTC)},
+		{from: "2025-03-02", to: "2025-03-01", expectErr: true},
+		{to: "2025-03-01", expectErr: true},
+		{from: "03/01/2025", expectErr: true},
+	}
+	for i, test := range tests {
+		from, to, err := OrderRange(test.from, test.to, test.days, now)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("expected error on test index %v", i)
+			}
+			continue
+		}
+		if err != nil || !from.Equal(test.expectedFrom) || !to.Equal(test.expectedTo) {
+			t.Errorf("expected %v to %v, got %v to %v, %v, on test index %v", test.expectedFrom, test.expectedTo, from, to, err, i)
+		}
+	}
+}
+
+// dollar amounts stay dollar amounts and sales keep their lot method
+func TestBuildReplacementOrderMutualFund(t *testing.T) {
+	order := trader.Order{
+		OrderID:           1001,
+		OrderType:         "MARKET",
+		Session:           "NORMAL",
+		Duration:          "DAY",
+		OrderStrategyType: "SINGLE",
+		TaxLotMethod:      "HIGH_COST",
+		Status:            "QUEUED",
+		OrderLegCollection: []trader.OrderLeg{
+			{Instruction: "SELL", Quantity: 500, QuantityType: "DOLLARS", Instrument: trader.Instrument{Symbol: "SWVXX", AssetType: "MUTUAL_FUND"}},
+		},
+	}
+	replacement, err := BuildReplacementOrder(order, map[string]float64{"SWVXX": 250}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replacement.TaxLotMethod != "HIGH_COST" {
+		t.Errorf("expected the HIGH_COST lot method to be kept, got %q", replacement.TaxLotMethod)
+	}
+	leg := replacement.OrderLegCollection[0]
+	if leg.QuantityType != "DOLLARS" || leg.Quantity != 250 || leg.Instrument.AssetType != "MUTUAL_FUND" {
+		t.Errorf("expected a $250 SWVXX sale, got %+v", leg)
+	}
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func isTracked(allocation targetAllocation.TargetAllocation, ticker string) bool {
+	if allocation[ticker].Proportion != 0 || allocation[ticker].FixedCashValue != 0 {
+		return true
+	}
+	_, ok := targetAllocation.ClassOf(allocation, ticker)
+	return ok
+}
+
+func PositionsReport(positions []trader.Position, accountValue float64, allocation targetAllocation.TargetAllocation) report.Positions {
+	result := make(report.Positions, 0, len(positions))
+	for _, pos := range positions {
+		proportion := 0.0
+		if accountValue != 0 {
+			proportion = pos.MarketValue / accountValue
+		}
+		result = append(result, report.Position{
+			Ticker:      pos.Instrument.Symbol,
+			Quantity:    pos.LongQuantity,
+			MarketValue: pos.MarketValue,
+			Proportion:  proportion,
+			Tracked:     isTracked(allocation, pos.Instrument.Symbol),
+		})
+	}
+	return result
+}
+
+// positions are marked tracked when the target allocation file has an entry or an asset class for them
+func AccountsReport(accounts []Account) report.Accounts {
+	targetAllocations, _ := targetAllocation.LoadTargetAllocations(targetAllocation.TargetAllocationFile)
+	result := make(report.Accounts, 0, len(accounts))
+	for i := range accounts {
+		acc := &accounts[i]
+		result = append(result, report.Account{
+			Index:        i,
+			Account:      AccountIdentifier(acc),
+			AccountValue: acc.SecuritiesAccount.InitialBalances.AccountValue,
+			Cash:         acc.SecuritiesAccount.InitialBalances.CashBalance,
+			Positions:    PositionsReport(acc.SecuritiesAccount.Positions, acc.SecuritiesAccount.InitialBalances.AccountValue, targetAllocations[AccountIdentifier(acc)]),
+		})
+	}
+	return result
+}
+
+// orders sorted sales first, then by ticker, so output is stable
+func planOrdersReport(orders map[string]float64, assets Assets, lots *LotSales) []report.PlanOrder {
+	result := make([]report.PlanOrder, 0, len(orders))
+	for ticker, quantity := range orders {
+		order := report.PlanOrder{Ticker: ticker, Instruction: report.Buy, Quantity: quantity}
+		if quantity < 0 {
+			order.Instruction = report.Sell
+			order.Quantity = -quantity
+		} else if quantity == 0 {
+			continue
+		}
+		if TradesInDollars(assets.AssetType(ticker)) {
+			order.Dollars = balance.DollarAmount(order.Quantity, assets[ticker].Price)
+		}
+		if lots != nil && quantity < 0 {
+			order.Lots = saleLotsReport(lots.Sales[ticker])
+		}
+		result = append(result, order)
+	}
+	slices.SortFunc(result, func(x, y report.PlanOrder) int {
+		if x.Instruction != y.Instruction {
+			return -strings.Compare(x.Instruction, y.Instruction)
+		}
+		return strings.Compare(x.Ticker, y.Ticker)
+	})
+	return result
+}
+
+func PlansReport(plans []Plan) report.Plans {
+	result := make(report.Plans, 0, len(plans))
+	for _, plan := range plans {
+		drift := make([]report.Drift, 0, len(plan.Drift))
+		for _, d := range plan.Drift {
+			drift = append(drift, report.Drift{
+				Ticker:           d.Ticker,
+				Value:            d.Value,
+				TargetValue:      d.TargetValue,
+				Proportion:       d.Proportion,
+				TargetProportion: d.TargetProportion,
+				Band:             d.Band,
+				InBand:           d.InBand,
+			})
+		}
+		reportPlan := report.Plan{
+			Account:       AccountIdentifier(plan.Account),
+			Drift:         drift,
+			Orders:        planOrdersReport(plan.Orders, plan.Assets, plan.Lots),
+			ResultingCash: plan.Cash,
+		}
+		if plan.Lots != nil {
+			gains := plan.Lots.Gains()
+			reportPlan.LotMethod = string(plan.Lots.Method)
+			reportPlan.ShortTermGain = gains.ShortTerm
+			reportPlan.LongTermGain = gains.LongTerm
+		}
+		if plan.GainBudget != nil {
+			reportPlan.GainBudget = gainBudgetReport(*plan.GainBudget, plan.Assets)
+		}
+		for _, ws := range plan.WashSales {
+			washSale := report.WashSale{
+				Ticker:      ws.Ticker,
+				SoldTicker:  ws.Sale.Ticker,
+				SoldAccount: ws.Sale.Account,
+				SoldOn:      ws.Sale.Sold.Format(DateFormat),
+				Loss:        ws.Sale.Loss,
+				Blocked:     ws.Blocked,
+			}
+			if ws.Purchase != nil {
+				washSale.BoughtTicker = ws.Purchase.Ticker
+				washSale.BoughtAccount = ws.Purchase.Account
+				washSale.BoughtOn = ws.Purchase.Bought.Format(DateFormat)
+			}
+			reportPlan.WashSales = append(reportPlan.WashSales, washSale)
+		}
+		result = append(result, reportPlan)
+	}
+	return result
+}
+
+func gainBudgetReport(comparison GainBudgetComparison, assets Assets) *report.GainBudget {
+	result := &report.GainBudget{
+		NoShortTermGains:           comparison.Budget.NoShortTermGains,
+		TrackingError:              comparison.Constrained.TrackingError,
+		UnconstrainedTrackingError: comparison.Unconstrained.TrackingError,
+		UnconstrainedOrders:        planOrdersReport(comparison.Unconstrained.Orders, assets, nil),
+		UnconstrainedShortTermGain: comparison.Unconstrained.Gains.ShortTerm,
+		UnconstrainedLongTermGain:  comparison.Unconstrained.Gains.LongTerm,
+	}
+	if !math.IsInf(comparison.Budget.MaxGain, 1) {
+		result.MaxGain = &comparison.Budget.MaxGain
+	}
+	return result
+}
+
+func saleLotsReport(sales []taxlot.Sale) []report.SaleLot {
+	lots := make([]report.SaleLot, 0, len(sales))
+	for _, sale := range sales {
+		lot := report.SaleLot{
+			Quantity: sale.Quantity,
+			Cost:     sale.Lot.Cost,
+			Gain:     sale.Gain(),
+			LongTerm: sale.LongTerm,
+		}
+		if !sale.Lot.Acquired.IsZero() {
+			lot.Acquired = sale.Lot.Acquired.Format(DateFormat)
+		}
+		lots = append(lots, lot)
+	}
+	return lots
+}
+
+// executed quantity and quantity weighted price of a leg, from the execution activities of the order
+func legFill(order trader.Order, legID int64) (float64, float64) {
+	quantity := 0.0
+	value := 0.0
+	for _, activity := range order.OrderActivityCollection {
+		if activity.ActivityType != "EXECUTION" {
+			continue
+		}
+		for _, execution := range activity.ExecutionLegs {
+			if execution.LegID == legID {
+				quantity += execution.Quantity
+				value += execution.Quantity * execution.Price
+			}
+		}
+	}
+	if quantity == 0 {
+		return 0, 0
+	}
+	return quantity, value / quantity
+}
+
+// child orders are flattened after their parent
+func OrdersReport(orders []trader.Order) report.Orders {
+	result := make(report.Orders, 0, len(orders))
+	var add func(order trader.Order, parentID int64)
+	add = func(order trader.Order, parentID int64) {
+		legs := make([]report.OrderLeg, 0, len(order.OrderLegCollection))
+		for _, leg := range order.OrderLegCollection {
+			filled, price := legFill(order, leg.LegID)
+			legs = append(legs, report.OrderLeg{
+				Ticker:         leg.Instrument.Symbol,
+				Instruction:    leg.Instruction,
+				Quantity:       leg.Quantity,
+				QuantityType:   leg.QuantityType,
+				FilledQuantity: filled,
+				AveragePrice:   price,
+			})
+		}
+		result = append(result, report.Order{
+			OrderID:           order.OrderID,
+			ParentID:          parentID,
+			EnteredTime:       order.EnteredTime,
+			Strategy:          order.OrderStrategyType,
+			OrderType:         order.OrderType,
+			Status:            order.Status,
+			StatusDescription: order.StatusDescription,
+			Legs:              legs,
+		})
+		for _, child := range order.ChildOrderStrategies {
+			add(child, order.OrderID)
+		}
+	}
+	for _, order := range orders {
+		add(order, 0)
+	}
+	return result
+}
@@ -0,0 +1,22 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+// accounts without value still encode as json
+func TestPositionsReportZeroAccountValue(t *testing.T) {
+	positions := []trader.Position{{Instrument: trader.Instrument{Symbol: "VTI"}}}
+	result := PositionsReport(positions, 0, nil)
+	if len(result) != 1 || result[0].Proportion != 0 {
+		t.Fatalf("expected a proportion of 0, got %+v", result)
+	}
+	var out bytes.Buffer
+	if err := report.Write(&out, report.JSON, report.Accounts{{Account: "123", Positions: result}}); err != nil {
+		t.Errorf("expected the report to encode, got %v", err)
+	}
+}
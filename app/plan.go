@@ -0,0 +1,349 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+// purchases and sales computed for a single account, sales have negative quantities
+type Plan struct {
+	Account    *Account
+	Allocation targetAllocation.TargetAllocation
+	Orders     map[string]float64
+	Cash       float64
+	// asset types and prices the orders were computed with
+	Assets Assets
+	// drift of every asset before the orders, only set for rebalances of a single account
+	Drift []balance.Drift
+	// estimated lots relieved by the sales, set for plans that sell
+	Lots *LotSales
+	// plan without the gain budget, set when the app has one
+	GainBudget *GainBudgetComparison
+	// purchases washing a recent loss, dropped from the orders when blocked
+	WashSales []WashSale
+}
+
+func (p Plan) Purchases() map[string]float64 {
+	purchases := make(map[string]float64)
+	for k, v := range p.Orders {
+		if v > 0 {
+			purchases[k] = v
+		}
+	}
+	return purchases
+}
+
+func (p Plan) Sales() map[string]float64 {
+	sales := make(map[string]float64)
+	for k, v := range p.Orders {
+		if v < 0 {
+			sales[k] = v
+		}
+	}
+	return sales
+}
+
+// plans of the orders for tickers trading in shares and those trading in dollars
+func (p Plan) SplitDollars() (Plan, Plan) {
+	shares, dollars := p, p
+	shares.Orders = make(map[string]float64)
+	dollars.Orders = make(map[string]float64)
+	for ticker, quantity := range p.Orders {
+		if TradesInDollars(p.Assets.AssetType(ticker)) {
+			dollars.Orders[ticker] = quantity
+		} else {
+			shares.Orders[ticker] = quantity
+		}
+	}
+	return shares, dollars
+}
+
+func LoadAccountAllocation(account *Account) (targetAllocation.TargetAllocation, error) {
+	targetAllocations, err := targetAllocation.LoadTargetAllocations(targetAllocation.TargetAllocationFile)
+	if err != nil {
+		return nil, errors.New("failed to load targetAllocations: " + err.Error())
+	}
+	allocation, ok := targetAllocations[AccountIdentifier(account)]
+	if !ok {
+		return nil, fmt.Errorf("no target allocation for account ********%v in %v", AccountIdentifier(account), targetAllocation.TargetAllocationFile)
+	}
+	return allocation, nil
+}
+
+// tickers of the holdings and the allocation that need a price
+func trackedTickers(holdings map[string]float64, allocation targetAllocation.TargetAllocation) []string {
+	tickers := slices.Collect(maps.Keys(holdings))
+	for ticker := range allocation {
+		if _, ok := holdings[ticker]; !ok {
+			tickers = append(tickers, ticker)
+		}
+	}
+	return tickers
+}
+
+// balance panics on missing prices, a *balance.MissingPriceError is returned instead
+func validatePrices(holdings map[string]float64, allocation targetAllocation.TargetAllocation, prices map[string]float64) error {
+	if err := balance.ValidateDesiredAllocationPrices(slices.Sorted(maps.Keys(allocation)), prices); err != nil {
+		return err
+	}
+	return balance.ValidateHoldingPrices(holdings, prices)
+}
+
+// purchases investing the cash of the account without selling
+func PlanInvestCash(a *App, account *Account) (Plan, error) {
+	allocation, err := LoadAccountAllocation(account)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	substitutes, err := LoadSubstitutes()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	allocation, classes := resolveClasses(allocation, account.SecuritiesAccount.Positions)
+	trackedHoldings := GetTrackedHoldings(account.SecuritiesAccount.Positions, allocation)
+	held := heldSubstitutes(account.SecuritiesAccount.Positions, allocation, substitutes)
+	classHeld := heldClassTickers(account.SecuritiesAccount.Positions, classes)
+	assets, err := GetAssets(a, slices.Concat(trackedTickers(trackedHoldings, allocation), slices.Collect(maps.Keys(held)), slices.Collect(maps.Keys(classHeld))))
+	if err != nil {
+		return Plan{}, err
+	}
+	assets.SetIncrements(allocation)
+	trackedPrices := assets.Prices()
+	if err := validatePrices(trackedHoldings, allocation, trackedPrices); err != nil {
+		return Plan{}, err
+	}
+	if err := balance.ValidateHoldingPrices(held, trackedPrices); err != nil {
+		return Plan{}, err
+	}
+	if err := balance.ValidateHoldingPrices(classHeld, trackedPrices); err != nil {
+		return Plan{}, err
+	}
+	substituteHoldings := foldSubstitutes(trackedHoldings, held, substitutes, trackedPrices)
+	foldedHoldings := foldClasses(substituteHoldings, classHeld, classes, trackedPrices)
+
+	purchases, cash := balance.BalancePurchaseDollars(account.SecuritiesAccount.InitialBalances.CashBalance, foldedHoldings, trackedPrices, allocation, assets.DollarTickers())
+	purchases, cash = unfoldSubstitutes(purchases, cash, trackedHoldings, held, substitutes, trackedPrices, assets.DollarTickers())
+	return guardWashSales(a, Plan{Account: account, Allocation: allocation, Orders: purchases, Cash: cash, Assets: assets}, time.Now())
+}
+
+// purchases and sales bringing the account to its target allocation
+func PlanRebalance(a *App, account *Account) (Plan, error) {
+	allocation, err := LoadAccountAllocation(account)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	substitutes, err := LoadSubstitutes()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	allocation, classes := resolveClasses(allocation, account.SecuritiesAccount.Positions)
+	trackedHoldings := GetTrackedHoldings(account.SecuritiesAccount.Positions, allocation)
+	held := heldSubstitutes(account.SecuritiesAccount.Positions, allocation, substitutes)
+	classHeld := heldClassTickers(account.SecuritiesAccount.Positions, classes)
+	assets, err := GetAssets(a, slices.Concat(trackedTickers(trackedHoldings, allocation), slices.Collect(maps.Keys(held)), slices.Collect(maps.Keys(classHeld))))
+	if err != nil {
+		return Plan{}, err
+	}
+	assets.SetIncrements(allocation)
+	trackedPrices := assets.Prices()
+	if err := validatePrices(trackedHoldings, allocation, trackedPrices); err != nil {
+		return Plan{}, err
+	}
+	if err := balance.ValidateHoldingPrices(held, trackedPrices); err != nil {
+		return Plan{}, err
+	}
+	if err := balance.ValidateHoldingPrices(classHeld, trackedPrices); err != nil {
+		return Plan{}, err
+	}
+	substituteHoldings := foldSubstitutes(trackedHoldings, held, substitutes, trackedPrices)
+	foldedHoldings := foldClasses(substituteHoldings, classHeld, classes, trackedPrices)
+
+	plan := Plan{Account: account, Allocation: allocation, Assets: assets}
+	cash := account.SecuritiesAccount.InitialBalances.CashBalance
+	if targetAllocation.HasBands(allocation) {
+		plan.Orders, plan.Cash, plan.Drift = balance.RebalanceWithBands(cash, foldedHoldings, trackedPrices, allocation, assets.DollarTickers(), a.bandEdge)
+	} else {
+		plan.Drift = balance.MeasureDrift(cash, foldedHoldings, trackedPrices, allocation)
+		plan.Orders, plan.Cash = balance.RebalanceWithSellingDollars(cash, foldedHoldings, trackedPrices, allocation, assets.DollarTickers())
+	}
+	now := time.Now()
+	if len(plan.Sales()) == 0 {
+		plan.Orders, plan.Cash = unfoldSubstitutes(plan.Orders, plan.Cash, trackedHoldings, held, substitutes, trackedPrices, assets.DollarTickers())
+		return guardWashSales(a, plan, now)
+	}
+
+	lots, err := GetLots(a, account, now)
+	if err != nil {
+		return plan, err
+	}
+	if a.gainBudget != nil {
+		plan = ApplyGainBudget(a, plan, foldedHoldings, lots, now)
+	}
+	plan.Orders, plan.Cash = unfoldClasses(plan.Orders, plan.Cash, substituteHoldings, classHeld, classes, trackedPrices, assets.DollarTickers())
+	plan.Orders, plan.Cash = unfoldSubstitutes(plan.Orders, plan.Cash, trackedHoldings, held, substitutes, trackedPrices, assets.DollarTickers())
+	return guardWashSales(a, estimateLotSales(plan, lots, a.lotMethod, now), now)
+}
+
+// one plan per account bringing the combined accounts to the global allocation
+func PlanHousehold(a *App) ([]Plan, error) {
+	targetAllocations, err := targetAllocation.LoadTargetAllocations(targetAllocation.TargetAllocationFile)
+	if err != nil {
+		return nil, errors.New("failed to load targetAllocations: " + err.Error())
+	}
+
+	globalAllocation, ok := targetAllocations[targetAllocation.GlobalAccountIdentifier]
+	if !ok {
+		return nil, errors.New("no global allocation found in " + targetAllocation.TargetAllocationFile)
+	}
+
+	substitutes, err := LoadSubstitutes()
+	if err != nil {
+		return nil, err
+	}
+	// plan from current balances, not those of the last account listing
+	if err := a.RefreshAccounts(); err != nil {
+		return nil, err
+	}
+
+	accountHoldings := make([]balance.AccountHoldings, len(a.accounts))
+	held := make([]map[string]float64, len(a.accounts))
+	classHeld := make([]map[string]float64, len(a.accounts))
+	resolved := make([]targetAllocation.TargetAllocation, len(a.accounts))
+	classes := make([]targetAllocation.ClassTickers, len(a.accounts))
+	choices := make([]map[string]string, len(a.accounts))
+	tickers := make([]string, 0)
+	for i, account := range a.accounts {
+		positions := account.SecuritiesAccount.Positions
+		resolved[i], classes[i] = resolveClasses(globalAllocation, positions)
+		choices[i] = make(map[string]string)
+		for class, alloc := range targetAllocation.Flatten(globalAllocation) {
+			if alloc.AssetClass() {
+				choices[i][class] = classTicker(positions)(alloc.Tickers)
+			}
+		}
+		accountHoldings[i] = balance.AccountHoldings{
+			Cash:     account.SecuritiesAccount.InitialBalances.CashBalance,
+			Holdings: GetTrackedHoldings(positions, resolved[i]),
+		}
+		held[i] = heldSubstitutes(positions, resolved[i], substitutes)
+		classHeld[i] = heldClassTickers(positions, classes[i])
+		for _, ticker := range slices.Concat(trackedTickers(accountHoldings[i].Holdings, resolved[i]), slices.Collect(maps.Keys(held[i])), slices.Collect(maps.Keys(classHeld[i]))) {
+			if !slices.Contains(tickers, ticker) {
+				tickers = append(tickers, ticker)
+			}
+		}
+	}
+	assets, err := GetAssets(a, tickers)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resolved {
+		assets.SetIncrements(resolved[i])
+	}
+	trackedPrices := assets.Prices()
+	substituteHoldings := make([]map[string]float64, len(a.accounts))
+	foldedHoldings := make([]balance.AccountHoldings, len(a.accounts))
+	for i, holdings := range accountHoldings {
+		if err := validatePrices(holdings.Holdings, resolved[i], trackedPrices); err != nil {
+			return nil, err
+		}
+		if err := balance.ValidateHoldingPrices(held[i], trackedPrices); err != nil {
+			return nil, err
+		}
+		if err := balance.ValidateHoldingPrices(classHeld[i], trackedPrices); err != nil {
+			return nil, err
+		}
+		substituteHoldings[i] = foldSubstitutes(holdings.Holdings, held[i], substitutes, trackedPrices)
+		foldedHoldings[i] = balance.AccountHoldings{Cash: holdings.Cash, Holdings: foldClasses(substituteHoldings[i], classHeld[i], classes[i], trackedPrices)}
+	}
+
+	orders, cash := balance.RebalanceHouseholdClasses(foldedHoldings, trackedPrices, globalAllocation, choices, assets.DollarTickers())
+	plans := make([]Plan, len(a.accounts))
+	for i := range a.accounts {
+		orders[i], cash[i] = unfoldClasses(orders[i], cash[i], substituteHoldings[i], classHeld[i], classes[i], trackedPrices, assets.DollarTickers())
+		orders[i], cash[i] = unfoldSubstitutes(orders[i], cash[i], accountHoldings[i].Holdings, held[i], substitutes, trackedPrices, assets.DollarTickers())
+		plans[i], err = EstimateLotSales(a, Plan{Account: &a.accounts[i], Allocation: resolved[i], Orders: orders[i], Cash: cash[i], Assets: assets}, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return GuardWashSales(a, plans, time.Now())
+}
+
+func PrintPlan(plan Plan) {
+	report.Write(os.Stdout, report.Table, PlansReport([]Plan{plan}))
+}
+
+// places the orders of the plan priced by the pricing policy of the app
+func PlaceOrders(a *App, plan Plan) (report.OrderResults, error) {
+	if reasons := CheckPlan(plan); len(reasons) > 0 {
+		return nil, &PlanRejectedError{Account: AccountIdentifier(plan.Account), Reasons: reasons}
+	}
+	var pending *PendingPlan
+	if SettlementSequenced(plan.Account) {
+		plan, pending = SequencePlan(plan, time.Now())
+	}
+
+	results, err := placePlanOrders(a, plan)
+	if err != nil || pending == nil {
+		return results, err
+	}
+	if err := a.SchedulePendingPlan(*pending); err != nil {
+		return results, err
+	}
+	fmt.Fprintf(os.Stderr, "purchases waiting for sales to settle are scheduled for %v, place them from pending purchases\n", pending.SettlesAt.Format(DateFormat))
+	return results, nil
+}
+
+func placePlanOrders(a *App, plan Plan) (report.OrderResults, error) {
+	if !a.pricing.Limit() {
+		return placeMarketOrders(a, plan)
+	}
+	shares, dollars := plan.SplitDollars()
+	results, err := workPlanLimitOrders(a, shares, a.pricing)
+	if err != nil {
+		return results, err
+	}
+	placed, err := placeMarketOrders(a, dollars)
+	return append(results, placed...), err
+}
+
+// a trigger order when the plan sells, otherwise a buy order
+func placeMarketOrders(a *App, plan Plan) (report.OrderResults, error) {
+	var result report.OrderResult
+	var err error
+	switch {
+	case len(plan.Sales()) > 0 && len(plan.Purchases()) > 0:
+		result, err = PlaceTriggerOrder(a, plan.Account, plan.Orders, plan.Assets)
+	case len(plan.Sales()) > 0:
+		result, err = PlaceSellOrder(a, plan.Account, plan.Sales(), plan.Assets)
+	case len(plan.Purchases()) > 0:
+		result, err = PlaceBuyOrder(a, plan.Account, plan.Purchases(), plan.Assets)
+	default:
+		return report.OrderResults{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report.OrderResults{result}, nil
+}
+
+// reads a single word from stdin, only "proceed" confirms
+func ConfirmProceed() bool {
+	fmt.Fprintln(os.Stderr, "type \"proceed\" to place the orders, anything else to cancel")
+	var input string
+	_, err := fmt.Scan(&input)
+	return err == nil && input == "proceed"
+}
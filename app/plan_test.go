@@ -0,0 +1,216 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+func TestInvestCash(t *testing.T) {
+	a, fb := newTestApp(t)
+	account, err := FindAccount(a.accounts, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanInvestCash(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPurchases := map[string]float64{
+		"DFAC":  11,
+		"DFIC":  5,
+		"DFEM":  7,
+		"SWVXX": 2,
+	}
+	if !reflect.DeepEqual(plan.Orders, expectedPurchases) {
+		t.Errorf("expected purchases %v, got %v", expectedPurchases, plan.Orders)
+	}
+
+	if results, err := PlaceOrders(a, plan); err != nil || len(results) == 0 {
+		t.Fatal("expected orders to be placed", err)
+	}
+	assertAllFilled(t, fb, "hash123")
+
+	expectedHoldings := map[string]float64{
+		"DFAC":  41,
+		"DFIC":  25,
+		"DFEM":  17,
+		"SWVXX": 4000,
+	}
+	if h := holdings(t, fb, "hash123"); !reflect.DeepEqual(h, expectedHoldings) {
+		t.Errorf("expected holdings %v, got %v", expectedHoldings, h)
+	}
+	acc, _ := fb.GetAccount("hash123")
+	if !util.AlmostEqual(acc.SecuritiesAccount.InitialBalances.CashBalance, 1.1, 1e-7) {
+		t.Errorf("expected cash 1.1, got %v", acc.SecuritiesAccount.InitialBalances.CashBalance)
+	}
+}
+
+func TestInvestCashMutualFund(t *testing.T) {
+	a, fb := newTestApp(t)
+	fb.SetFundQuote("SWVXX", 1)
+	account, err := FindAccount(a.accounts, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanInvestCash(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := BuildBuyOrder(plan.Purchases(), plan.Assets)
+	legIndex := slices.IndexFunc(order.OrderLegCollection, func(leg trader.OrderLeg) bool { return leg.Instrument.Symbol == "SWVXX" })
+	if legIndex < 0 {
+		t.Fatalf("expected a SWVXX leg, got %+v", order.OrderLegCollection)
+	}
+	leg := order.OrderLegCollection[legIndex]
+	if leg.QuantityType != "DOLLARS" || leg.Quantity != 2 || leg.Instrument.AssetType != "MUTUAL_FUND" {
+		t.Errorf("expected a $2 mutual fund leg, got %+v", leg)
+	}
+
+	if _, err := PlaceOrders(a, plan); err != nil {
+		t.Fatal(err)
+	}
+	assertAllFilled(t, fb, "hash123")
+	if h := holdings(t, fb, "hash123"); h["SWVXX"] != 4000 {
+		t.Errorf("expected 4000 SWVXX, got %v", h["SWVXX"])
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	a, fb := newTestApp(t)
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOrders := map[string]float64{
+		"VTI":   20,
+		"VSAIX": 2,
+		"VXUS":  2,
+		"VWO":   -4,
+	}
+	if !reflect.DeepEqual(plan.Orders, expectedOrders) {
+		t.Errorf("expected orders %v, got %v", expectedOrders, plan.Orders)
+	}
+
+	if results, err := PlaceOrders(a, plan); err != nil || len(results) == 0 {
+		t.Fatal("expected orders to be placed", err)
+	}
+	assertAllFilled(t, fb, "hash567")
+
+	expectedHoldings := map[string]float64{
+		"VTI":   30,
+		"VSAIX": 12,
+		"VXUS":  12,
+		"VWO":   6,
+		"SWVXX": 4000,
+	}
+	if h := holdings(t, fb, "hash567"); !reflect.DeepEqual(h, expectedHoldings) {
+		t.Errorf("expected holdings %v, got %v", expectedHoldings, h)
+	}
+}
+
+func TestRebalanceHousehold(t *testing.T) {
+	a, fb := newTestApp(t)
+
+	plans, err := PlanHousehold(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range plans {
+		if _, err := PlaceOrders(a, plan); err != nil {
+			t.Fatal(err)
+		}
+		assertAllFilled(t, fb, plan.Account.AccountHashValue)
+	}
+
+	accounts, _ := fb.ListAccounts()
+	values := make(map[string]float64)
+	for _, account := range accounts {
+		if account.SecuritiesAccount.InitialBalances.CashBalance < 0 {
+			t.Errorf("account %v has negative cash", account.AccountHashValue)
+		}
+		for _, pos := range account.SecuritiesAccount.Positions {
+			values[pos.Instrument.Symbol] += pos.MarketValue
+		}
+	}
+	if math.Abs(values["SWVXX"]-4000) > 10 {
+		t.Errorf("expected about $4000 of SWVXX across the household, got %v", values["SWVXX"])
+	}
+	proportionValue := values["DFAC"] + values["DFIC"] + values["DFEM"]
+	for ticker, proportion := range map[string]float64{"DFAC": 0.64, "DFIC": 0.27, "DFEM": 0.09} {
+		actual := values[ticker] / proportionValue
+		if math.Abs(actual-proportion) > 0.01 {
+			t.Errorf("expected %v to be %v of the household, got %v", ticker, proportion, actual)
+		}
+	}
+	// not part of the global allocation, so left alone
+	for _, ticker := range []string{"VTI", "VSAIX", "VXUS", "VWO"} {
+		if values[ticker] != 100 {
+			t.Errorf("expected %v to be untouched, holding $%v", ticker, values[ticker])
+		}
+	}
+}
+
+func TestMissingPrice(t *testing.T) {
+	a, fb := newTestApp(t)
+	delete(fb.Quotes, "DFEM")
+	account, err := FindAccount(a.accounts, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = PlanInvestCash(a, account)
+	var missingPrice *balance.MissingPriceError
+	if !errors.As(err, &missingPrice) || missingPrice.Ticker != "DFEM" {
+		t.Errorf("expected a missing price error for DFEM, got %v", err)
+	}
+}
+
+func TestRebalanceWithBands(t *testing.T) {
+	a, fb := newTestApp(t)
+	targetAllocation.TargetAllocationFile = "testing/targetAllocation_appTest2.yaml"
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOrders := map[string]float64{"VWO": -3, "VTI": 20}
+	if !reflect.DeepEqual(plan.Orders, expectedOrders) {
+		t.Errorf("expected orders %v, got %v", expectedOrders, plan.Orders)
+	}
+	inBand := make([]string, 0)
+	for _, drift := range plan.Drift {
+		if drift.InBand {
+			inBand = append(inBand, drift.Ticker)
+		}
+	}
+	if expected := []string{"SWVXX", "VSAIX", "VXUS"}; !reflect.DeepEqual(inBand, expected) {
+		t.Errorf("expected %v in band, got %v", expected, inBand)
+	}
+
+	if _, err := PlaceOrders(a, plan); err != nil {
+		t.Fatal(err)
+	}
+	assertAllFilled(t, fb, "hash567")
+	if h := holdings(t, fb, "hash567"); h["VTI"] != 30 || h["VSAIX"] != 10 || h["VWO"] != 7 {
+		t.Errorf("expected only VTI and VWO to be traded, got %v", h)
+	}
+}
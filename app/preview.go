@@ -0,0 +1,105 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+// the plan was refused before any of its orders were placed
+type PlanRejectedError struct {
+	Account string
+	Reasons []string
+}
+
+func (e *PlanRejectedError) Error() string {
+	return fmt.Sprintf("orders for account ********%v rejected: %v", e.Account, strings.Join(e.Reasons, "; "))
+}
+
+// reasons the account can not carry out the plan, checked without asking the broker
+func CheckPlan(plan Plan) []string {
+	reasons := make([]string, 0)
+	balances := plan.Account.SecuritiesAccount.InitialBalances
+	spend := balances.CashBalance - plan.Cash
+	if spend > 0 && spend > balances.CashAvailableForTrading+0.005 {
+		reasons = append(reasons, fmt.Sprintf("plan spends $%.2f but only $%.2f is available for trading", spend, balances.CashAvailableForTrading))
+	}
+	if plan.Account.SecuritiesAccount.IsClosingOnlyRestricted && len(plan.Purchases()) > 0 {
+		reasons = append(reasons, "account is restricted to closing transactions, purchases are not allowed")
+	}
+	return reasons
+}
+
+// market order carrying out the plan, limit orders placed per ticker are previewed as this order too
+func planOrder(plan Plan) trader.Order {
+	if len(plan.Purchases()) == 0 {
+		return BuildSellOrder(plan.Sales(), plan.Assets)
+	}
+	if len(plan.Sales()) > 0 {
+		return BuildTriggerOrder(plan.Orders, plan.Assets)
+	}
+	return BuildBuyOrder(plan.Purchases(), plan.Assets)
+}
+
+func PreviewPlan(a *App, plan Plan) (report.Preview, error) {
+	result, err := a.broker.PreviewOrder(plan.Account.AccountHashValue, a.withLotMethod(planOrder(plan)))
+	if err != nil {
+		return report.Preview{}, fmt.Errorf("failed to preview orders for account ********%v: %w", AccountIdentifier(plan.Account), err)
+	}
+	preview := report.Preview{
+		Account:                 AccountIdentifier(plan.Account),
+		OrderValue:              result.OrderStrategy.OrderBalance.OrderValue,
+		Commission:              result.CommissionAndFee.Commission.Total(),
+		Fees:                    result.CommissionAndFee.Fee.Total(),
+		ProjectedAvailableFunds: result.OrderStrategy.OrderBalance.ProjectedAvailableFund,
+		ProjectedBuyingPower:    result.OrderStrategy.OrderBalance.ProjectedBuyingPower,
+		Rejections:              CheckPlan(plan),
+	}
+	for _, reject := range result.OrderValidationResult.Rejects {
+		preview.Rejections = append(preview.Rejections, validationMessage(reject))
+	}
+	for _, warning := range result.OrderValidationResult.Warns {
+		preview.Warnings = append(preview.Warnings, validationMessage(warning))
+	}
+	return preview, nil
+}
+
+func validationMessage(detail trader.OrderValidationDetail) string {
+	if detail.Message != "" {
+		return detail.Message
+	}
+	return detail.ValidationRuleName
+}
+
+// previews of the plans that have orders
+func PreviewPlans(a *App, plans []Plan) (report.Previews, error) {
+	previews := make(report.Previews, 0, len(plans))
+	for _, plan := range plans {
+		if len(plan.Orders) == 0 {
+			continue
+		}
+		preview, err := PreviewPlan(a, plan)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, preview)
+	}
+	return previews, nil
+}
+
+// prints the previews, false when any plan was rejected
+func PrintPreviews(a *App, plans []Plan) (bool, error) {
+	previews, err := PreviewPlans(a, plans)
+	if err != nil {
+		return false, err
+	}
+	report.Write(os.Stdout, report.Table, previews)
+	if previews.Rejected() {
+		fmt.Println("Orders were rejected, nothing was placed")
+		return false, nil
+	}
+	return true, nil
+}
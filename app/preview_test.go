@@ -0,0 +1,81 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+)
+
+func TestPreviewRejectsPlan(t *testing.T) {
+	a, fb := newTestApp(t)
+	account, err := FindAccount(a.accounts, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := PlanInvestCash(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previews, err := PreviewPlans(a, []Plan{plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previews.Rejected() || previews[0].OrderValue != 503.1-plan.Cash {
+		t.Errorf("expected an accepted preview worth %v, got %+v", 503.1-plan.Cash, previews)
+	}
+
+	// part of the cash is unsettled
+	account.SecuritiesAccount.InitialBalances.CashAvailableForTrading = 100
+	previews, err = PreviewPlans(a, []Plan{plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !previews.Rejected() {
+		t.Errorf("expected the preview to reject spending more than is available, got %+v", previews)
+	}
+	var rejected *PlanRejectedError
+	if _, err := PlaceOrders(a, plan); !errors.As(err, &rejected) {
+		t.Errorf("expected a PlanRejectedError, got %v", err)
+	}
+
+	account.SecuritiesAccount.InitialBalances.CashAvailableForTrading = account.SecuritiesAccount.InitialBalances.CashBalance
+	account.SecuritiesAccount.IsClosingOnlyRestricted = true
+	if _, err := PlaceOrders(a, plan); !errors.As(err, &rejected) {
+		t.Errorf("expected a PlanRejectedError for a closing only account, got %v", err)
+	}
+	if orders, _ := fb.ListOrders("hash123", time.Time{}, time.Now()); len(orders) != 0 {
+		t.Errorf("expected no orders to be placed, got %v", len(orders))
+	}
+}
+
+func TestPreviewSellOnlyPlan(t *testing.T) {
+	a, fb := newTestApp(t)
+	account, err := FindAccount(a.accounts, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := Plan{
+		Account: account,
+		Orders:  map[string]float64{"DFAC": -10},
+		Assets:  Assets{"DFAC": {AssetType: "EQUITY", Price: 30, Increment: 1}},
+		Cash:    803.1,
+	}
+
+	previews, err := PreviewPlans(a, []Plan{plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previews.Rejected() {
+		t.Fatalf("expected the sales to be accepted, got %+v", previews)
+	}
+	if exitCode := executePlans(a, []Plan{plan}, orderFlags{yes: true, output: &outputFlag{report.JSON}}); exitCode != ExitOK {
+		t.Fatalf("expected exit code %v, got %v", ExitOK, exitCode)
+	}
+	if held := holdings(t, fb, "hash123")["DFAC"]; held != 20 {
+		t.Errorf("expected 20 DFAC left, got %v", held)
+	}
+	assertAllFilled(t, fb, "hash123")
+}
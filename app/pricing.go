@@ -0,0 +1,282 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+const (
+	MarketPricing = "market"
+	// limit at the middle of bid and ask
+	MidPricing = "mid"
+	// limit at the ask plus Cents for purchases, the bid minus Cents for sales
+	AskPricing = "ask"
+	// limit at the mark plus SlippageCap for purchases, minus for sales, fills right away unless the spread is wider
+	MarketablePricing = "marketable"
+)
+
+const DefaultSlippageCap = 0.005
+
+// how orders are priced and how often unfilled limit orders are repriced
+type PricingPolicy struct {
+	Kind         string
+	Cents        float64
+	SlippageCap  float64
+	Reprices     int
+	RepriceAfter time.Duration
+}
+
+var DefaultPricingPolicy = PricingPolicy{Kind: MarketPricing, Reprices: 3, RepriceAfter: 30 * time.Second}
+
+// parses market, mid, ask, ask+N in cents, marketable and marketable:P in percent
+func ParsePricingPolicy(s string) (PricingPolicy, error) {
+	policy := DefaultPricingPolicy
+	kind, arg, hasArg := strings.Cut(s, ":")
+	if strings.HasPrefix(s, AskPricing+"+") {
+		kind, arg, hasArg = AskPricing, strings.TrimPrefix(s, AskPricing+"+"), true
+	}
+	policy.Kind = kind
+
+	switch kind {
+	case MarketPricing, MidPricing:
+		if hasArg {
+			return PricingPolicy{}, fmt.Errorf("pricing %v takes no argument", kind)
+		}
+	case AskPricing:
+		if hasArg {
+			cents, err := strconv.ParseFloat(arg, 64)
+			if err != nil || cents < 0 {
+				return PricingPolicy{}, fmt.Errorf("invalid number of cents in %q", s)
+			}
+			policy.Cents = cents
+		}
+	case MarketablePricing:
+		policy.SlippageCap = DefaultSlippageCap
+		if hasArg {
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(arg, "%"), 64)
+			if err != nil || percent <= 0 {
+				return PricingPolicy{}, fmt.Errorf("invalid slippage cap in %q", s)
+			}
+			policy.SlippageCap = percent / 100
+		}
+	default:
+		return PricingPolicy{}, fmt.Errorf("unknown pricing %q, expected market, mid, ask+N or marketable:P", s)
+	}
+	return policy, nil
+}
+
+func (p PricingPolicy) Limit() bool {
+	return p.Kind != MarketPricing
+}
+
+// prices below a dollar trade in hundredths of a cent
+func roundPrice(price float64) float64 {
+	if price < 1 {
+		return math.Round(price*10000) / 10000
+	}
+	return math.Round(price*100) / 100
+}
+
+// limit price for the instruction, fails when the quote has no bid and ask
+func (p PricingPolicy) LimitPrice(instruction string, quote marketData.Quote) (float64, error) {
+	if quote.BidPrice <= 0 || quote.AskPrice <= 0 {
+		return 0, errors.New("no bid and ask to price a limit order from")
+	}
+	sign := 1.0
+	if instruction == "SELL" {
+		sign = -1
+	}
+	var price float64
+	switch p.Kind {
+	case MidPricing:
+		price = (quote.BidPrice + quote.AskPrice) / 2
+	case AskPricing:
+		price = quote.AskPrice + p.Cents/100
+		if instruction == "SELL" {
+			price = quote.BidPrice - p.Cents/100
+		}
+	case MarketablePricing:
+		mark := quote.Mark
+		if mark <= 0 {
+			mark = (quote.BidPrice + quote.AskPrice) / 2
+		}
+		price = mark * (1 + sign*p.SlippageCap)
+	default:
+		return 0, fmt.Errorf("pricing %v has no limit price", p.Kind)
+	}
+	return roundPrice(price), nil
+}
+
+// limit price of the nth reprice, marketable on the last one
+func (p PricingPolicy) RepricedLimitPrice(instruction string, quote marketData.Quote, reprice int) (float64, error) {
+	price, err := p.LimitPrice(instruction, quote)
+	if err != nil || reprice == 0 || p.Reprices == 0 {
+		return price, err
+	}
+	step := float64(min(reprice, p.Reprices)) / float64(p.Reprices)
+	if instruction == "SELL" && price > quote.BidPrice {
+		price -= (price - quote.BidPrice) * step
+	} else if instruction != "SELL" && price < quote.AskPrice {
+		price += (quote.AskPrice - price) * step
+	}
+	return roundPrice(price), nil
+}
+
+// single leg limit order of the ticker as the asset type it has in assets, a negative quantity sells
+func BuildLimitOrder(ticker string, quantity float64, price float64, assets Assets) trader.Order {
+	instruction := "BUY"
+	if quantity < 0 {
+		instruction = "SELL"
+	}
+	return trader.Order{
+		OrderType:         "LIMIT",
+		Price:             price,
+		Session:           "NORMAL",
+		Duration:          "DAY",
+		Cancelable:        true,
+		OrderStrategyType: "SINGLE",
+		OrderLegCollection: []trader.OrderLeg{
+			{
+				Instruction: instruction,
+				Quantity:    math.Abs(quantity),
+				Instrument:  trader.Instrument{Symbol: ticker, AssetType: assets.AssetType(ticker)},
+			},
+		},
+	}
+}
+
+func limitPrices(a *App, orders map[string]float64, policy PricingPolicy, reprice int) (map[string]float64, error) {
+	quotes, err := a.broker.GetQuotes(slices.Collect(maps.Keys(orders)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quotes: %w", err)
+	}
+	prices := make(map[string]float64)
+	for ticker, quantity := range orders {
+		instrument, ok := quotes[ticker]
+		if !ok {
+			return nil, fmt.Errorf("no quote for %v", ticker)
+		}
+		instruction := "BUY"
+		if quantity < 0 {
+			instruction = "SELL"
+		}
+		price, err := policy.RepricedLimitPrice(instruction, instrument.Quote, reprice)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", ticker, err)
+		}
+		prices[ticker] = price
+	}
+	return prices, nil
+}
+
+// places a limit order per ticker and reprices those still open, returns their last state
+func WorkLimitOrders(a *App, account *Account, orders map[string]float64, assets Assets, policy PricingPolicy) ([]trader.Order, error) {
+	tickers := make([]string, 0)
+	for _, ticker := range slices.Sorted(maps.Keys(orders)) {
+		if orders[ticker] != 0 {
+			tickers = append(tickers, ticker)
+		}
+	}
+	prices, err := limitPrices(a, orders, policy, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]int64)
+	for _, ticker := range tickers {
+		result, err := placeOrder(a, account, a.withLotMethod(BuildLimitOrder(ticker, orders[ticker], prices[ticker], assets)))
+		if err != nil {
+			return nil, err
+		}
+		ids[ticker] = result.OrderID
+	}
+
+	latest := make(map[string]trader.Order)
+	for round := 0; ; round++ {
+		deadline := time.Now().Add(policy.RepriceAfter)
+		open := make(map[string]float64)
+		for _, ticker := range tickers {
+			order, err := TrackOrder(a, account, ids[ticker], TrackInterval, max(0, time.Until(deadline)), PrintOrderUpdate)
+			if err != nil {
+				return nil, err
+			}
+			latest[ticker] = order
+			if !OrderDone(order) {
+				remaining := order.RemainingQuantity
+				if remaining == 0 {
+					remaining = math.Abs(orders[ticker]) - order.FilledQuantity
+				}
+				open[ticker] = math.Copysign(remaining, orders[ticker])
+			}
+		}
+		if len(open) == 0 || round >= policy.Reprices {
+			break
+		}
+
+		prices, err := limitPrices(a, open, policy, round+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, ticker := range slices.Sorted(maps.Keys(open)) {
+			if prices[ticker] == latest[ticker].Price {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "repricing %v from $%v to $%v\n", ticker, latest[ticker].Price, prices[ticker])
+			result, err := ReplaceOrder(a, account, ids[ticker], a.withLotMethod(BuildLimitOrder(ticker, open[ticker], prices[ticker], assets)))
+			if err != nil {
+				return nil, err
+			}
+			ids[ticker] = result.OrderID
+		}
+	}
+
+	result := make([]trader.Order, 0, len(tickers))
+	for _, ticker := range tickers {
+		result = append(result, latest[ticker])
+	}
+	return result, nil
+}
+
+func orderResults(account *Account, orders []trader.Order) report.OrderResults {
+	results := make(report.OrderResults, 0, len(orders))
+	for _, order := range orders {
+		results = append(results, report.OrderResult{Account: AccountIdentifier(account), OrderID: order.OrderID})
+	}
+	return results
+}
+
+// works the sales of the plan, then its purchases once every sale filled
+func workPlanLimitOrders(a *App, plan Plan, policy PricingPolicy) (report.OrderResults, error) {
+	results := make(report.OrderResults, 0)
+	if len(plan.Sales()) > 0 {
+		sales, err := WorkLimitOrders(a, plan.Account, plan.Sales(), plan.Assets, policy)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, orderResults(plan.Account, sales)...)
+		for _, sale := range sales {
+			if !OrderFilled(sale) && len(plan.Purchases()) > 0 {
+				return results, fmt.Errorf("sale order #%v is %v, purchases were not placed", sale.OrderID, sale.Status)
+			}
+		}
+	}
+	if len(plan.Purchases()) > 0 {
+		purchases, err := WorkLimitOrders(a, plan.Account, plan.Purchases(), plan.Assets, policy)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, orderResults(plan.Account, purchases)...)
+	}
+	return results, nil
+}
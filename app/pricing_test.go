@@ -0,0 +1,130 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+func TestParsePricingPolicy(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    PricingPolicy
+		expectedErr bool
+	}{
+		{input: "market", expected: PricingPolicy{Kind: MarketPricing}},
+		{input: "mid", expected: PricingPolicy{Kind: MidPricing}},
+		{input: "ask", expected: PricingPolicy{Kind: AskPricing}},
+		{input: "ask+2", expected: PricingPolicy{Kind: AskPricing, Cents: 2}},
+		{input: "marketable", expected: PricingPolicy{Kind: MarketablePricing, SlippageCap: DefaultSlippageCap}},
+		{input: "marketable:1%", expected: PricingPolicy{Kind: MarketablePricing, SlippageCap: 0.01}},
+		{input: "mid:1", expectedErr: true},
+		{input: "ask+x", expectedErr: true},
+		{input: "best", expectedErr: true},
+	}
+	for i, test := range tests {
+		policy, err := ParsePricingPolicy(test.input)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("expected error on test index %v", i)
+			}
+			continue
+		}
+		test.expected.Reprices = DefaultPricingPolicy.Reprices
+		test.expected.RepriceAfter = DefaultPricingPolicy.RepriceAfter
+		if err != nil || policy != test.expected {
+			t.Errorf("expected %+v, got %+v, %v, on test index %v", test.expected, policy, err, i)
+		}
+	}
+}
+
+func TestLimitPrice(t *testing.T) {
+	quote := marketData.Quote{BidPrice: 9.9, AskPrice: 10.1, Mark: 10}
+	tests := []struct {
+		policy      PricingPolicy
+		instruction string
+		reprice     int
+		expected    float64
+	}{
+		{policy: PricingPolicy{Kind: MidPricing}, instruction: "BUY", expected: 10},
+		{policy: PricingPolicy{Kind: MidPricing, Reprices: 2}, instruction: "BUY", reprice: 1, expected: 10.05},
+		{policy: PricingPolicy{Kind: MidPricing, Reprices: 2}, instruction: "SELL", reprice: 2, expected: 9.9},
+		{policy: PricingPolicy{Kind: AskPricing, Cents: 2}, instruction: "BUY", expected: 10.12},
+		{policy: PricingPolicy{Kind: AskPricing, Cents: 2}, instruction: "SELL", expected: 9.88},
+		{policy: PricingPolicy{Kind: MarketablePricing, SlippageCap: 0.02}, instruction: "BUY", expected: 10.2},
+		{policy: PricingPolicy{Kind: MarketablePricing, SlippageCap: 0.02}, instruction: "SELL", expected: 9.8},
+	}
+	for i, test := range tests {
+		price, err := test.policy.RepricedLimitPrice(test.instruction, quote, test.reprice)
+		if err != nil || !util.AlmostEqual(price, test.expected, 1e-9) {
+			t.Errorf("expected %v, got %v, %v, on test index %v", test.expected, price, err, i)
+		}
+	}
+	if _, err := (PricingPolicy{Kind: MidPricing}).LimitPrice("BUY", marketData.Quote{LastPrice: 10}); err == nil {
+		t.Error("expected error without bid and ask")
+	}
+}
+
+func TestWorkLimitOrders(t *testing.T) {
+	trackInterval := TrackInterval
+	TrackInterval = time.Millisecond
+	t.Cleanup(func() {
+		TrackInterval = trackInterval
+	})
+
+	a, fb := newTestApp(t)
+	fb.SetQuotes(marketData.QuoteResponse{
+		"VTI": {Symbol: "VTI", Quote: marketData.Quote{BidPrice: 9.9, AskPrice: 10.1, LastPrice: 10, Mark: 10}},
+	})
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := PricingPolicy{Kind: MidPricing, Reprices: 2, RepriceAfter: 5 * time.Millisecond}
+	orders, err := WorkLimitOrders(a, account, map[string]float64{"VTI": 5, "VWO": -2}, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || !OrderFilled(orders[0]) || !OrderFilled(orders[1]) {
+		t.Fatalf("expected both orders filled, got %+v", orders)
+	}
+	if price := orders[0].OrderActivityCollection[0].ExecutionLegs[0].Price; orders[0].Price != 10.1 || price != 10.1 {
+		t.Errorf("expected VTI to fill at the ask after two reprices, got limit %v and fill %v", orders[0].Price, price)
+	}
+	if orders[1].Price != 10 {
+		t.Errorf("expected VWO to fill at mid without a spread, got %v", orders[1].Price)
+	}
+
+	from, to, _ := OrderRange("", "", 1, time.Now())
+	listed, _ := ListOrders(a, account, from, to)
+	replaced := 0
+	for _, order := range listed {
+		if order.Status == "REPLACED" {
+			replaced++
+		}
+	}
+	if replaced != 2 {
+		t.Errorf("expected 2 replaced orders, got %v", replaced)
+	}
+}
+
+func TestBuildLimitOrderAssetType(t *testing.T) {
+	assets := Assets{"VTI": {AssetType: "COLLECTIVE_INVESTMENT", Price: 10}}
+	tests := []struct {
+		ticker   string
+		expected string
+	}{
+		{"VTI", "COLLECTIVE_INVESTMENT"},
+		// no asset type without a quote
+		{"DFAC", "EQUITY"},
+	}
+	for i, test := range tests {
+		order := BuildLimitOrder(test.ticker, 5, 10, assets)
+		if assetType := order.OrderLegCollection[0].Instrument.AssetType; assetType != test.expected {
+			t.Errorf("expected asset type %v, got %v on test index %v", test.expected, assetType, i)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+var PendingPlansFile = "pendingPlans.json"
+
+// business days after the trade date that sales settle, market holidays are not skipped
+var SettlementDays = 1
+
+// purchases of a plan waiting for the proceeds of its sales to settle
+type PendingPlan struct {
+	Account    targetAllocation.AccountIdentifier `json:"account"`
+	Purchases  map[string]float64                 `json:"purchases"`
+	Increments map[string]PendingIncrement        `json:"increments,omitempty"`
+	CreatedAt  time.Time                          `json:"createdAt"`
+	SettlesAt  time.Time                          `json:"settlesAt"`
+}
+
+// increment settings of a ticker in the allocation of the plan, see targetAllocation.Allocation
+type PendingIncrement struct {
+	ShareIncrement  float64 `json:"shareIncrement,omitempty"`
+	DollarIncrement float64 `json:"dollarIncrement,omitempty"`
+}
+
+// the increments as an allocation for Assets.SetIncrements
+func (p PendingPlan) incrementAllocation() targetAllocation.TargetAllocation {
+	allocation := make(targetAllocation.TargetAllocation)
+	for ticker, increment := range p.Increments {
+		allocation[ticker] = targetAllocation.Allocation{ShareIncrement: increment.ShareIncrement, DollarIncrement: increment.DollarIncrement}
+	}
+	return allocation
+}
+
+func (p PendingPlan) Due(now time.Time) bool {
+	return !now.Before(p.SettlesAt)
+}
+
+// start of the day sales traded on the given day settle, SettlementDays business days later
+func SettlementDate(trade time.Time) time.Time {
+	date := time.Date(trade.Year(), trade.Month(), trade.Day(), 0, 0, 0, 0, trade.Location())
+	for days := 0; days < SettlementDays; {
+		date = date.AddDate(0, 0, 1)
+		if date.Weekday() != time.Saturday && date.Weekday() != time.Sunday {
+			days++
+		}
+	}
+	return date
+}
+
+// cash accounts only buy with settled cash, margin accounts are not sequenced
+func SettlementSequenced(account *Account) bool {
+	return account.SecuritiesAccount.Type == "CASH"
+}
+
+// cash available for trading that is not proceeds of unsettled sales
+func SettledCash(account *Account) float64 {
+	balances := account.SecuritiesAccount.InitialBalances
+	return max(0, balances.CashAvailableForTrading-balances.UnsettledCash)
+}
+
+// splits the plan into the orders to place now and the purchases waiting for settlement
+func SequencePlan(plan Plan, now time.Time) (Plan, *PendingPlan) {
+	funded := plan
+	funded.Orders = plan.Sales()
+	deferred := make(map[string]float64)
+	deferredCost := 0.0
+
+	cash := SettledCash(plan.Account)
+	purchases := plan.Purchases()
+	for _, ticker := range slices.Sorted(maps.Keys(purchases)) {
+		quantity := purchases[ticker]
+		price := plan.Assets[ticker].Price
+		affordable := 0.0
+		if price > 0 {
+			affordable = math.Min(quantity, balance.TruncateShares(cash/price, plan.Assets.MinShares(ticker)))
+			if TradesInDollars(plan.Assets.AssetType(ticker)) {
+				affordable = math.Min(quantity, balance.DollarAmount(cash, 1)/price)
+			}
+		}
+		if affordable > 0 {
+			funded.Orders[ticker] = affordable
+			cash -= affordable * price
+		}
+		if rest := quantity - affordable; rest > 0 && (price == 0 || rest*price >= 0.01) {
+			deferred[ticker] = rest
+			deferredCost += rest * price
+		}
+	}
+	if len(deferred) == 0 {
+		return plan, nil
+	}
+	funded.Cash += deferredCost
+	increments := make(map[string]PendingIncrement)
+	for ticker := range deferred {
+		if alloc := plan.Allocation[ticker]; alloc.ShareIncrement > 0 || alloc.DollarIncrement > 0 {
+			increments[ticker] = PendingIncrement{ShareIncrement: alloc.ShareIncrement, DollarIncrement: alloc.DollarIncrement}
+		}
+	}
+	return funded, &PendingPlan{
+		Account:    AccountIdentifier(plan.Account),
+		Purchases:  deferred,
+		Increments: increments,
+		CreatedAt:  now,
+		SettlesAt:  SettlementDate(now),
+	}
+}
+
+// no pending plans when the file does not exist
+func LoadPendingPlans(file string) ([]PendingPlan, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return []PendingPlan{}, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read pending plans: " + err.Error())
+	}
+	plans := make([]PendingPlan, 0)
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, errors.New("failed to parse pending plans: " + err.Error())
+	}
+	return plans, nil
+}
+
+func SavePendingPlans(file string, plans []PendingPlan) error {
+	data, err := json.MarshalIndent(plans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return errors.New("failed to save pending plans: " + err.Error())
+	}
+	return nil
+}
+
+// pending plans of paper trading are kept next to the paper ledger
+func paperPendingPlansFile(ledgerFile string) string {
+	return strings.TrimSuffix(ledgerFile, filepath.Ext(ledgerFile)) + "Pending.json"
+}
+
+func (a *App) SchedulePendingPlan(pending PendingPlan) error {
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	if err != nil {
+		return err
+	}
+	return SavePendingPlans(a.pendingPlansFile, append(plans, pending))
+}
+
+// plan buying the pending purchases at current prices, in the increments of their tickers
+func (p PendingPlan) Plan(a *App) (Plan, error) {
+	account, err := FindAccount(a.accounts, p.Account)
+	if err != nil {
+		return Plan{}, err
+	}
+	assets, err := GetAssets(a, slices.Collect(maps.Keys(p.Purchases)))
+	if err != nil {
+		return Plan{}, err
+	}
+	assets.SetIncrements(p.incrementAllocation())
+	cost := 0.0
+	for ticker, quantity := range p.Purchases {
+		asset, ok := assets[ticker]
+		if !ok {
+			return Plan{}, &balance.MissingPriceError{Ticker: ticker}
+		}
+		cost += quantity * asset.Price
+	}
+	return Plan{
+		Account: account,
+		Orders:  maps.Clone(p.Purchases),
+		Cash:    account.SecuritiesAccount.InitialBalances.CashBalance - cost,
+		Assets:  assets,
+	}, nil
+}
+
+// places the pending purchases that are due and schedules those not placed again
+func PlacePendingPlans(a *App, now time.Time) (report.OrderResults, error) {
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.RefreshAccounts(); err != nil {
+		return nil, err
+	}
+
+	results := make(report.OrderResults, 0)
+	for i := 0; i < len(plans); {
+		pending := plans[i]
+		if !pending.Due(now) {
+			i++
+			continue
+		}
+		plan, err := pending.Plan(a)
+		if err != nil {
+			return results, err
+		}
+		plans = slices.Delete(plans, i, i+1)
+		if err := SavePendingPlans(a.pendingPlansFile, plans); err != nil {
+			return results, err
+		}
+		// order times are in whole seconds
+		started := time.Now().Truncate(time.Second)
+		placed, err := PlaceOrders(a, plan)
+		results = append(results, placed...)
+		if err != nil {
+			return results, a.rescheduleUnplaced(pending, started, err)
+		}
+		// PlaceOrders may have scheduled what is still not covered
+		if plans, err = LoadPendingPlans(a.pendingPlansFile); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// schedules the purchases of the pending plan that no order placed since started buys, after placing failed with err
+func (a *App) rescheduleUnplaced(pending PendingPlan, started time.Time, err error) error {
+	account, findErr := FindAccount(a.accounts, pending.Account)
+	if findErr != nil {
+		return fmt.Errorf("%w, pending purchases were not scheduled again: %v", err, findErr)
+	}
+	orders, listErr := ListOrders(a, account, started, time.Now())
+	if listErr != nil {
+		return fmt.Errorf("%w, pending purchases were not scheduled again: %v", err, listErr)
+	}
+	pending.Purchases = maps.Clone(pending.Purchases)
+	for _, order := range orders {
+		if order.Status == "REJECTED" {
+			continue
+		}
+		for _, leg := range order.OrderLegCollection {
+			if leg.Instruction == "BUY" {
+				delete(pending.Purchases, leg.Instrument.Symbol)
+			}
+		}
+	}
+	if len(pending.Purchases) == 0 {
+		return err
+	}
+	if scheduleErr := a.SchedulePendingPlan(pending); scheduleErr != nil {
+		return fmt.Errorf("%w, pending purchases were not scheduled again: %v", err, scheduleErr)
+	}
+	return err
+}
+
+func PendingPlansReport(plans []PendingPlan, now time.Time) report.PendingPlans {
+	result := make(report.PendingPlans, 0, len(plans))
+	for _, plan := range plans {
+		orders := make([]report.PlanOrder, 0, len(plan.Purchases))
+		for _, ticker := range slices.Sorted(maps.Keys(plan.Purchases)) {
+			orders = append(orders, report.PlanOrder{Ticker: ticker, Instruction: report.Buy, Quantity: plan.Purchases[ticker]})
+		}
+		result = append(result, report.PendingPlan{
+			Account:   plan.Account,
+			SettlesAt: plan.SettlesAt.Format(DateFormat),
+			Due:       plan.Due(now),
+			Orders:    orders,
+		})
+	}
+	return result
+}
+
+func PendingPlansHandler(a *App) AppHandler {
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	if err != nil {
+		return ErrorHandlerFunc(err)
+	}
+	report.Write(os.Stdout, report.Table, PendingPlansReport(plans, time.Now()))
+	if !slices.ContainsFunc(plans, func(p PendingPlan) bool { return p.Due(time.Now()) }) {
+		return MainOptionsHandler
+	}
+	if !ConfirmProceed() {
+		return MainOptionsHandler
+	}
+	results, err := PlacePendingPlans(a, time.Now())
+	report.Write(os.Stdout, report.Table, results)
+	if err != nil {
+		return ErrorHandlerFunc(err)
+	}
+	return TrackOrdersHandlerFunc(results)
+}
+
+// tells about pending plans that are due, at startup
+func printDuePendingPlans(a *App) {
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	due := 0
+	for _, plan := range plans {
+		if plan.Due(time.Now()) {
+			due++
+		}
+	}
+	if due > 0 {
+		fmt.Printf("%v pending purchase plans have settled, place them from the pending purchases menu\n", due)
+	}
+}
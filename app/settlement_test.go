@@ -0,0 +1,208 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/broker"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+func TestSettlementDate(t *testing.T) {
+	tests := []struct {
+		trade    time.Time
+		expected time.Time
+	}{
+		{time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC), time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 16, 15, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+	}
+	for i, test := range tests {
+		if settles := SettlementDate(test.trade); !settles.Equal(test.expected) {
+			t.Errorf("expected settlement on %v, got %v on test index %v", test.expected, settles, i)
+		}
+	}
+}
+
+func TestSettlementSequencing(t *testing.T) {
+	a, fb := newTestApp(t)
+	fb.Ledger.Accounts[1].SecuritiesAccount.Type = "CASH"
+	fb.Ledger.Accounts[1].SecuritiesAccount.InitialBalances.UnsettledCash = 150
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	friday := time.Date(2026, 10, 16, 15, 0, 0, 0, time.Local)
+	funded, pending := SequencePlan(plan, friday)
+	expectedFunded := map[string]float64{"VWO": -4, "VSAIX": 2, "VTI": 3}
+	if !reflect.DeepEqual(funded.Orders, expectedFunded) {
+		t.Errorf("expected funded orders %v, got %v", expectedFunded, funded.Orders)
+	}
+	expectedPending := map[string]float64{"VTI": 17, "VXUS": 2}
+	if pending == nil || !reflect.DeepEqual(pending.Purchases, expectedPending) || pending.SettlesAt.Weekday() != time.Monday {
+		t.Fatalf("expected purchases %v pending until monday, got %+v", expectedPending, pending)
+	}
+	if !util.AlmostEqual(funded.Cash, plan.Cash+190, 1e-7) {
+		t.Errorf("expected the deferred $190 to stay in cash, got %v", funded.Cash)
+	}
+
+	if _, err := PlaceOrders(a, plan); err != nil {
+		t.Fatal(err)
+	}
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	if err != nil || len(plans) != 1 || !reflect.DeepEqual(plans[0].Purchases, expectedPending) {
+		t.Fatalf("expected the pending plan to be saved, got %+v %v", plans, err)
+	}
+	if results, err := PlacePendingPlans(a, time.Now()); err != nil || len(results) != 0 {
+		t.Errorf("expected nothing placed before settlement, got %v %v", results, err)
+	}
+
+	fb.Ledger.Accounts[1].SecuritiesAccount.InitialBalances.UnsettledCash = 0
+	if _, err := PlacePendingPlans(a, plans[0].SettlesAt); err != nil {
+		t.Fatal(err)
+	}
+	assertAllFilled(t, fb, "hash567")
+	expectedHoldings := map[string]float64{"VTI": 30, "VSAIX": 12, "VXUS": 12, "VWO": 6, "SWVXX": 4000}
+	if h := holdings(t, fb, "hash567"); !reflect.DeepEqual(h, expectedHoldings) {
+		t.Errorf("expected holdings %v, got %v", expectedHoldings, h)
+	}
+	if plans, _ := LoadPendingPlans(a.pendingPlansFile); len(plans) != 0 {
+		t.Errorf("expected no pending plans left, got %+v", plans)
+	}
+}
+
+// without settled cash only the sales go out, as a single order since a trigger order needs purchases
+func TestSettlementSequencingNoSettledCash(t *testing.T) {
+	a, fb := newTestApp(t)
+	fb.Ledger.Accounts[1].SecuritiesAccount.Type = "CASH"
+	fb.Ledger.Accounts[1].SecuritiesAccount.InitialBalances.UnsettledCash = 202.12
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := PlanRebalance(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := PlaceOrders(a, plan)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected one order placed, got %v %v", results, err)
+	}
+	order, err := fb.GetOrder("hash567", results[0].OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.OrderStrategyType != "SINGLE" || len(order.OrderLegCollection) != 1 || order.OrderLegCollection[0].Instruction != "SELL" || !OrderFilled(order) {
+		t.Errorf("expected a filled single sell order, got %+v", order)
+	}
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	expectedPending := map[string]float64{"VSAIX": 2, "VTI": 20, "VXUS": 2}
+	if err != nil || len(plans) != 1 || !reflect.DeepEqual(plans[0].Purchases, expectedPending) {
+		t.Fatalf("expected purchases %v pending, got %+v %v", expectedPending, plans, err)
+	}
+
+	fb.Ledger.Accounts[1].SecuritiesAccount.InitialBalances.UnsettledCash = 0
+	if _, err := PlacePendingPlans(a, plans[0].SettlesAt); err != nil {
+		t.Fatal(err)
+	}
+	assertAllFilled(t, fb, "hash567")
+	expectedHoldings := map[string]float64{"VTI": 30, "VSAIX": 12, "VXUS": 12, "VWO": 6, "SWVXX": 4000}
+	if h := holdings(t, fb, "hash567"); !reflect.DeepEqual(h, expectedHoldings) {
+		t.Errorf("expected holdings %v, got %v", expectedHoldings, h)
+	}
+}
+
+// deferred fractional purchases are planned in their increment once settled
+func TestPendingPlanIncrements(t *testing.T) {
+	a, fb := newTestApp(t)
+	fb.Ledger.Accounts[1].SecuritiesAccount.Type = "CASH"
+	fb.Ledger.Accounts[1].SecuritiesAccount.InitialBalances.UnsettledCash = 202.12
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := Plan{
+		Account:    account,
+		Allocation: targetAllocation.TargetAllocation{"VTI": {Proportion: 1, ShareIncrement: 0.5}},
+		Orders:     map[string]float64{"VTI": 2.5},
+		Assets:     Assets{"VTI": {AssetType: "EQUITY", Price: 10, Increment: 0.5}},
+	}
+	_, pending := SequencePlan(plan, time.Now())
+	if pending == nil || pending.Increments["VTI"].ShareIncrement != 0.5 {
+		t.Fatalf("expected VTI pending with its increment, got %+v", pending)
+	}
+
+	pendingPlan, err := pending.Plan(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := BuildBuyOrder(pendingPlan.Orders, pendingPlan.Assets)
+	if len(order.OrderLegCollection) != 1 || order.OrderLegCollection[0].Quantity != 2.5 {
+		t.Errorf("expected 2.5 shares of VTI bought, got %+v", order.OrderLegCollection)
+	}
+}
+
+// broker that fails to place orders for the ticker
+type failingBroker struct {
+	broker.Broker
+	ticker string
+}
+
+func (b failingBroker) PlaceOrder(accountHash string, order trader.Order) (int64, error) {
+	for _, leg := range order.OrderLegCollection {
+		if leg.Instrument.Symbol == b.ticker {
+			return 0, errors.New("order not accepted")
+		}
+	}
+	return b.Broker.PlaceOrder(accountHash, order)
+}
+
+// purchases that did not go out when placing fails are scheduled again
+func TestPlacePendingPlansPartialFailure(t *testing.T) {
+	trackInterval := TrackInterval
+	TrackInterval = time.Millisecond
+	t.Cleanup(func() {
+		TrackInterval = trackInterval
+	})
+
+	a, fb := newTestApp(t)
+	a.pricing = PricingPolicy{Kind: MidPricing, RepriceAfter: 5 * time.Millisecond}
+	a.broker = failingBroker{Broker: fb, ticker: "VXUS"}
+	now := time.Now()
+	if err := a.SchedulePendingPlan(PendingPlan{Account: "567", Purchases: map[string]float64{"VTI": 2, "VXUS": 1}, CreatedAt: now, SettlesAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PlacePendingPlans(a, now); err == nil {
+		t.Fatal("expected the VXUS order to fail")
+	}
+	if held := holdings(t, fb, "hash567")["VTI"]; held != 12 {
+		t.Errorf("expected the VTI purchase to be placed, holding %v VTI", held)
+	}
+	plans, err := LoadPendingPlans(a.pendingPlansFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 || !reflect.DeepEqual(plans[0].Purchases, map[string]float64{"VXUS": 1}) {
+		t.Errorf("expected only the VXUS purchase to be pending, got %+v", plans)
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"errors"
+	"maps"
+	"math"
+
+	"github.com/josephwest2/schwab-portfolio-manager/balance"
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func LoadSubstitutes() (targetAllocation.Substitutes, error) {
+	substitutes, err := targetAllocation.LoadSubstitutes(targetAllocation.TargetAllocationFile)
+	if err != nil {
+		return nil, errors.New("failed to load substitutes: " + err.Error())
+	}
+	return substitutes, nil
+}
+
+// held shares of substitutes whose original is in the allocation, by substitute
+func heldSubstitutes(positions []trader.Position, allocation targetAllocation.TargetAllocation, substitutes targetAllocation.Substitutes) map[string]float64 {
+	held := make(map[string]float64)
+	for _, pos := range positions {
+		ticker := pos.Instrument.Symbol
+		if _, ok := allocation[ticker]; ok || pos.LongQuantity <= 0 {
+			continue
+		}
+		if original, ok := substitutes.Original(ticker); ok && isTracked(allocation, original) {
+			held[ticker] = pos.LongQuantity
+		}
+	}
+	return held
+}
+
+// holdings with every held substitute counted as shares of its original of the same value
+func foldSubstitutes(holdings map[string]float64, held map[string]float64, substitutes targetAllocation.Substitutes, prices map[string]float64) map[string]float64 {
+	folded := maps.Clone(holdings)
+	for substitute, quantity := range held {
+		original, _ := substitutes.Original(substitute)
+		folded[original] += quantity * prices[substitute] / prices[original]
+	}
+	return folded
+}
+
+// orders on the held tickers from orders on the folded substitute holdings, and the cash left
+func unfoldSubstitutes(orders map[string]float64, cash float64, holdings map[string]float64, held map[string]float64, substitutes targetAllocation.Substitutes, prices map[string]float64, dollarTickers balance.DollarTickers) (map[string]float64, float64) {
+	unfolded := maps.Clone(orders)
+	for substitute, quantity := range held {
+		original, _ := substitutes.Original(substitute)
+		order := orders[original]
+		switch {
+		case order > 0:
+			dollars := order * prices[original]
+			shares := dollars / prices[substitute]
+			if !dollarTickers[substitute] {
+				shares = math.Floor(shares + 1e-9)
+			}
+			delete(unfolded, original)
+			if shares > 0 {
+				unfolded[substitute] = shares
+			}
+			cash += dollars - shares*prices[substitute]
+		case -order > holdings[original]+1e-9:
+			dollars := (-order - holdings[original]) * prices[original]
+			shares := dollars / prices[substitute]
+			if !dollarTickers[substitute] {
+				shares = math.Ceil(shares - 1e-9)
+			}
+			shares = math.Min(shares, quantity)
+			if holdings[original] > 0 {
+				unfolded[original] = -holdings[original]
+			} else {
+				delete(unfolded, original)
+			}
+			unfolded[substitute] = -shares
+			cash += shares*prices[substitute] - dollars
+		}
+	}
+	return unfolded, cash
+}
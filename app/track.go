@@ -0,0 +1,113 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/report"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+var TerminalOrderStatuses = []string{"FILLED", "CANCELED", "REJECTED", "EXPIRED", "REPLACED"}
+
+var TrackInterval = 2 * time.Second
+
+// market orders placed outside market hours stay queued until the open, tracking gives up after this
+var TrackTimeout = 2 * time.Minute
+
+// the order and its child orders reached a terminal status
+func OrderDone(order trader.Order) bool {
+	if !slices.Contains(TerminalOrderStatuses, order.Status) {
+		return false
+	}
+	if order.Status != "FILLED" {
+		return true
+	}
+	for _, child := range order.ChildOrderStrategies {
+		if !OrderDone(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// the order and all of its child orders filled
+func OrderFilled(order trader.Order) bool {
+	if order.Status != "FILLED" {
+		return false
+	}
+	for _, child := range order.ChildOrderStrategies {
+		if !OrderFilled(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// status and filled quantity of the order and its children, changes when there is something new to show
+func orderProgress(order trader.Order) string {
+	progress := fmt.Sprintf("%v:%v:%v", order.OrderID, order.Status, order.FilledQuantity)
+	for _, child := range order.ChildOrderStrategies {
+		progress += "," + orderProgress(child)
+	}
+	return progress
+}
+
+// polls the order until it is done or the timeout, returns the last polled order
+func TrackOrder(a *App, account *Account, orderID int64, interval time.Duration, timeout time.Duration, onUpdate func(trader.Order)) (trader.Order, error) {
+	deadline := time.Now().Add(timeout)
+	lastProgress := ""
+	for {
+		order, err := a.broker.GetOrder(account.AccountHashValue, orderID)
+		if err != nil {
+			return trader.Order{}, fmt.Errorf("failed to get order #%v: %w", orderID, err)
+		}
+		if progress := orderProgress(order); progress != lastProgress {
+			lastProgress = progress
+			if onUpdate != nil {
+				onUpdate(order)
+			}
+		}
+		if OrderDone(order) || time.Now().Add(interval).After(deadline) {
+			return order, nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+func PrintOrderUpdate(order trader.Order) {
+	statuses := make([]string, 0)
+	for _, o := range OrdersReport([]trader.Order{order}) {
+		statuses = append(statuses, fmt.Sprintf("#%v %v", o.OrderID, o.Status))
+	}
+	fmt.Fprintln(os.Stderr, strings.Join(statuses, ", "))
+}
+
+// tracks the placed orders and prints their final state
+func TrackOrdersHandlerFunc(results report.OrderResults) AppHandler {
+	return func(a *App) AppHandler {
+		orders := make([]trader.Order, 0, len(results))
+		for _, result := range results {
+			account, err := FindAccount(a.accounts, result.Account)
+			if err != nil {
+				return ErrorHandlerFunc(err)
+			}
+			order, err := TrackOrder(a, account, result.OrderID, TrackInterval, TrackTimeout, PrintOrderUpdate)
+			if err != nil {
+				return ErrorHandlerFunc(err)
+			}
+			orders = append(orders, order)
+			if !OrderDone(order) {
+				fmt.Printf("Order #%v is still %v, check on it later from the orders command\n", order.OrderID, order.Status)
+			}
+		}
+		PrintOrders(orders)
+		if err := a.RefreshAccounts(); err != nil {
+			return ErrorHandlerFunc(err)
+		}
+		return MainOptionsHandler
+	}
+}
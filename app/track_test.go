@@ -0,0 +1,46 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func TestTrackOrder(t *testing.T) {
+	a, _ := newTestApp(t)
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := PlaceTriggerOrder(a, account, map[string]float64{"VWO": -4, "VTI": 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := 0
+	order, err := TrackOrder(a, account, result.OrderID, time.Millisecond, time.Second, func(trader.Order) { updates++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !OrderDone(order) || !OrderFilled(order) || updates != 1 {
+		t.Errorf("expected a single update with the filled order, got %v updates and %+v", updates, order)
+	}
+
+	orders := OrdersReport([]trader.Order{order})
+	if len(orders) != 2 || orders[1].ParentID != order.OrderID {
+		t.Fatalf("expected the order and its child, got %+v", orders)
+	}
+	if leg := orders[1].Legs[0]; leg.Ticker != "VTI" || leg.FilledQuantity != 3 || leg.AveragePrice != 10 {
+		t.Errorf("expected 3 VTI filled at 10, got %+v", leg)
+	}
+
+	working := trader.Order{Status: "FILLED", ChildOrderStrategies: []trader.Order{{Status: "AWAITING_PARENT_ORDER"}}}
+	if OrderDone(working) {
+		t.Error("expected an order with a pending child not to be done")
+	}
+	rejected := trader.Order{Status: "REJECTED", ChildOrderStrategies: []trader.Order{{Status: "AWAITING_PARENT_ORDER"}}}
+	if !OrderDone(rejected) || OrderFilled(rejected) {
+		t.Error("expected a rejected order to be done without filling")
+	}
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+)
+
+// days before and after a sale at a loss in which buying a substantially identical ticker is a wash sale
+const WashSaleDays = 30
+
+func LoadIdenticalTickers() (targetAllocation.IdenticalTickers, error) {
+	identical, err := targetAllocation.LoadIdenticalTickers(targetAllocation.TargetAllocationFile)
+	if err != nil {
+		return nil, errors.New("failed to load substantially identical tickers: " + err.Error())
+	}
+	return identical, nil
+}
+
+// sale at a loss in one of the accounts, Loss is 0 when the basis is unknown
+type LossSale struct {
+	Account      string
+	Ticker       string
+	Sold         time.Time
+	Loss         float64
+	UnknownBasis bool
+}
+
+// purchase in one of the accounts
+type Purchase struct {
+	Account string
+	Ticker  string
+	Bought  time.Time
+}
+
+// purchase of the plan washing the loss of the sale, or with Purchase set a sale of the plan washed by it
+type WashSale struct {
+	Ticker   string
+	Sale     LossSale
+	Purchase *Purchase
+	Blocked  bool
+}
+
+// sales at a loss and purchases in the last WashSaleDays days across all accounts
+func GetRecentTrades(a *App, now time.Time) ([]LossSale, []Purchase, error) {
+	since := now.AddDate(0, 0, -WashSaleDays)
+	lossSales := make([]LossSale, 0)
+	purchases := make([]Purchase, 0)
+	for i := range a.accounts {
+		account := &a.accounts[i]
+		transactions, err := a.broker.ListTransactions(account.AccountHashValue, now.AddDate(-1, 0, 0), now)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get transactions of account ********%v: %w", AccountIdentifier(account), err)
+		}
+		disposals, err := taxlot.Disposals(transactions)
+		if err != nil {
+			return nil, nil, err
+		}
+		bought, err := taxlot.Purchases(transactions)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, lot := range bought {
+			if !lot.Acquired.Before(since) {
+				purchases = append(purchases, Purchase{Account: AccountIdentifier(account), Ticker: lot.Ticker, Bought: lot.Acquired})
+			}
+		}
+		averagePrices := make(map[string]float64)
+		for _, pos := range account.SecuritiesAccount.Positions {
+			averagePrices[pos.Instrument.Symbol] = cmp.Or(pos.TaxLotAverageLongPrice, pos.AveragePrice)
+		}
+		for _, disposal := range disposals {
+			if disposal.Sold.Before(since) {
+				continue
+			}
+			sale := LossSale{Account: AccountIdentifier(account), Ticker: disposal.Ticker, Sold: disposal.Sold}
+			basis := disposal.Basis
+			if disposal.Unknown > 1e-9 {
+				if averagePrice, ok := averagePrices[disposal.Ticker]; ok && averagePrice > 0 {
+					basis += disposal.Unknown * averagePrice
+				} else {
+					sale.UnknownBasis = true
+				}
+			}
+			if !sale.UnknownBasis {
+				sale.Loss = basis - disposal.Quantity*disposal.Price
+			}
+			if sale.Loss > 1e-9 || sale.UnknownBasis {
+				lossSales = append(lossSales, sale)
+			}
+		}
+	}
+	return lossSales, purchases, nil
+}
+
+// losses the lot sales of the plans realize now, per account and ticker
+func plannedLossSales(plans []Plan, now time.Time) []LossSale {
+	lossSales := make([]LossSale, 0)
+	for _, plan := range plans {
+		if plan.Lots == nil {
+			continue
+		}
+		for _, ticker := range slices.Sorted(maps.Keys(plan.Lots.Sales)) {
+			loss := 0.0
+			for _, sale := range plan.Lots.Sales[ticker] {
+				loss -= math.Min(sale.Gain(), 0)
+			}
+			if loss > 1e-9 {
+				lossSales = append(lossSales, LossSale{Account: AccountIdentifier(plan.Account), Ticker: ticker, Sold: now, Loss: loss})
+			}
+		}
+	}
+	return lossSales
+}
+
+// purchases of the plan of a ticker substantially identical to one of the loss sales, in ticker order
+func washSales(plan Plan, lossSales []LossSale, identical targetAllocation.IdenticalTickers) []WashSale {
+	found := make([]WashSale, 0)
+	purchases := plan.Purchases()
+	for _, ticker := range slices.Sorted(maps.Keys(purchases)) {
+		for _, sale := range lossSales {
+			if slices.Contains(identical.Of(ticker), sale.Ticker) {
+				found = append(found, WashSale{Ticker: ticker, Sale: sale})
+				break
+			}
+		}
+	}
+	return found
+}
+
+// loss sales of the plan washed by one of the purchases, in ticker order
+func washedSales(plan Plan, plannedSales []LossSale, purchases []Purchase, identical targetAllocation.IdenticalTickers) []WashSale {
+	found := make([]WashSale, 0)
+	for _, sale := range plannedSales {
+		if sale.Account != AccountIdentifier(plan.Account) {
+			continue
+		}
+		for _, purchase := range purchases {
+			if slices.Contains(identical.Of(sale.Ticker), purchase.Ticker) {
+				found = append(found, WashSale{Ticker: sale.Ticker, Sale: sale, Purchase: &purchase})
+				break
+			}
+		}
+	}
+	return found
+}
+
+func guardWashSales(a *App, plan Plan, now time.Time) (Plan, error) {
+	plans, err := GuardWashSales(a, []Plan{plan}, now)
+	if err != nil {
+		return plan, err
+	}
+	return plans[0], nil
+}
+
+// flags the wash sales of the plans, with the app's blockWashSales their purchases are dropped
+func GuardWashSales(a *App, plans []Plan, now time.Time) ([]Plan, error) {
+	plannedSales := plannedLossSales(plans, now)
+	purchases := false
+	for _, plan := range plans {
+		purchases = purchases || len(plan.Purchases()) > 0
+	}
+	if !purchases && len(plannedSales) == 0 {
+		return plans, nil
+	}
+	identical, err := LoadIdenticalTickers()
+	if err != nil {
+		return nil, err
+	}
+	lossSales, recentPurchases, err := GetRecentTrades(a, now)
+	if err != nil {
+		return nil, err
+	}
+	lossSales = append(lossSales, plannedSales...)
+	guarded := slices.Clone(plans)
+	for i, plan := range guarded {
+		plan.WashSales = washSales(plan, lossSales, identical)
+		if a.blockWashSales && len(plan.WashSales) > 0 {
+			plan.Orders = maps.Clone(plan.Orders)
+			for j := range plan.WashSales {
+				ticker := plan.WashSales[j].Ticker
+				plan.Cash += plan.Orders[ticker] * plan.Assets[ticker].Price
+				delete(plan.Orders, ticker)
+				plan.WashSales[j].Blocked = true
+			}
+		}
+		plan.WashSales = append(plan.WashSales, washedSales(plan, plannedSales, recentPurchases, identical)...)
+		guarded[i] = plan
+	}
+	return guarded, nil
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/taxlot"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+func TestWashSales(t *testing.T) {
+	a, fb := newTestApp(t)
+	// 5 VTI bought in account 123 at 12 ten days ago and sold at 10 five days ago
+	now := time.Now()
+	fb.Now = func() time.Time { return now.AddDate(0, 0, -10) }
+	fb.SetQuote("VTI", 12)
+	if _, err := fb.PlaceOrder("hash123", BuildBuyOrder(map[string]float64{"VTI": 5}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	fb.Now = func() time.Time { return now.AddDate(0, 0, -5) }
+	fb.SetQuote("VTI", 10)
+	if _, err := fb.PlaceOrder("hash123", BuildLimitOrder("VTI", -5, 10, nil)); err != nil {
+		t.Fatal(err)
+	}
+	fb.Now = func() time.Time { return now }
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	account, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := PlanInvestCash(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Orders["VTI"] <= 0 || len(plan.WashSales) != 1 {
+		t.Fatalf("expected the VTI purchase to be flagged, got %v and %+v", plan.Orders, plan.WashSales)
+	}
+	if ws := plan.WashSales[0]; ws.Ticker != "VTI" || ws.Blocked || ws.Sale.Account != "123" || ws.Sale.Ticker != "VTI" || math.Abs(ws.Sale.Loss-10) > 1e-9 {
+		t.Errorf("expected the VTI purchase to wash the loss of 10 in account 123, got %+v", ws)
+	}
+
+	a.blockWashSales = true
+	blocked, err := PlanInvestCash(a, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := blocked.Orders["VTI"]; ok || len(blocked.WashSales) != 1 || !blocked.WashSales[0].Blocked {
+		t.Errorf("expected the VTI purchase to be blocked, got %v and %+v", blocked.Orders, blocked.WashSales)
+	}
+	if !util.AlmostEqual(blocked.Cash, plan.Cash+plan.Orders["VTI"]*10, 1e-9) {
+		t.Errorf("expected the cash of the VTI purchase to stay uninvested, got %v", blocked.Cash)
+	}
+}
+
+// one account selling at a loss while another buys in the same run
+func TestWashSalesAcrossPlans(t *testing.T) {
+	a, fb := newTestApp(t)
+	now := time.Now()
+	// 2 DFIC bought in account 567 ten days ago
+	fb.Now = func() time.Time { return now.AddDate(0, 0, -10) }
+	if _, err := fb.PlaceOrder("hash567", BuildBuyOrder(map[string]float64{"DFIC": 2}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	fb.Now = func() time.Time { return now }
+	if err := a.RefreshAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	seller, err := FindAccount(a.accounts, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buyer, err := FindAccount(a.accounts, "567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets := Assets{"DFAC": {AssetType: "EQUITY", Price: 30, Increment: 1}, "DFIC": {AssetType: "EQUITY", Price: 20, Increment: 1}}
+	sales := Plan{
+		Account: seller,
+		Orders:  map[string]float64{"DFAC": -10, "DFIC": -5},
+		Assets:  assets,
+		Lots: &LotSales{Method: taxlot.FIFO, Sales: map[string][]taxlot.Sale{
+			"DFAC": {{Lot: taxlot.Lot{Ticker: "DFAC", Quantity: 10, Cost: 35}, Quantity: 10, Proceeds: 300, Basis: 350}},
+			"DFIC": {{Lot: taxlot.Lot{Ticker: "DFIC", Quantity: 5, Cost: 22}, Quantity: 5, Proceeds: 100, Basis: 110}},
+		}},
+	}
+	purchases := Plan{Account: buyer, Orders: map[string]float64{"DFAC": 5}, Assets: assets}
+
+	plans, err := GuardWashSales(a, []Plan{sales, purchases}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws := plans[1].WashSales; len(ws) != 1 || ws[0].Ticker != "DFAC" || ws[0].Sale.Account != "123" || ws[0].Sale.Loss != 50 || ws[0].Blocked {
+		t.Errorf("expected the DFAC purchase to wash the planned loss of 50 in account 123, got %+v", ws)
+	}
+	// the DFIC bought in 567 washes the planned DFIC loss
+	ws := plans[0].WashSales
+	if len(ws) != 1 || ws[0].Ticker != "DFIC" || ws[0].Purchase == nil || ws[0].Purchase.Account != "567" || ws[0].Sale.Loss != 10 {
+		t.Errorf("expected the DFIC sale to be washed by the purchase in account 567, got %+v", ws)
+	}
+
+	a.blockWashSales = true
+	plans, err = GuardWashSales(a, []Plan{sales, purchases}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plans[1].Orders["DFAC"]; ok || !plans[1].WashSales[0].Blocked {
+		t.Errorf("expected the DFAC purchase to be blocked, got %v and %+v", plans[1].Orders, plans[1].WashSales)
+	}
+	if plans[0].Orders["DFIC"] != -5 || plans[0].WashSales[0].Blocked {
+		t.Errorf("expected the DFIC sale to only be flagged, got %v and %+v", plans[0].Orders, plans[0].WashSales)
+	}
+}
@@ -5,32 +5,53 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/josephwest2/schwab-portfolio-manager/encryption"
+	"github.com/josephwest2/schwab-portfolio-manager/transport"
 	"golang.org/x/oauth2"
 )
 
 var port = os.Getenv("SCHWAB_OAUTH_SERVER_PORT")
 
-var schwabEndpoint oauth2.Endpoint = oauth2.Endpoint{
-	AuthURL:   "https://api.schwabapi.com/v1/oauth/authorize",
-	TokenURL:  "https://api.schwabapi.com/v1/oauth/token",
-	AuthStyle: oauth2.AuthStyleInHeader,
+const DefaultApiBaseUrl = "https://api.schwabapi.com"
+
+// scheme and host of the schwab api, overridden with SCHWAB_API_BASE_URL to point at a stand-in server
+var ApiBaseUrl = apiBaseUrlFromEnv()
+
+func apiBaseUrlFromEnv() string {
+	if baseUrl := os.Getenv("SCHWAB_API_BASE_URL"); baseUrl != "" {
+		return strings.TrimSuffix(baseUrl, "/")
+	}
+	return DefaultApiBaseUrl
+}
+
+func SchwabEndpoint(baseUrl string) oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:   baseUrl + "/v1/oauth/authorize",
+		TokenURL:  baseUrl + "/v1/oauth/token",
+		AuthStyle: oauth2.AuthStyleInHeader,
+	}
 }
 
 var OauthConfig *oauth2.Config = &oauth2.Config{
 	RedirectURL:  fmt.Sprintf("https://127.0.0.1:%s/oauth2/callback", port),
 	ClientID:     os.Getenv("SCHWAB_OAUTH_CLIENT_ID"),
 	ClientSecret: os.Getenv("SCHWAB_OAUTH_CLIENT_SECRET"),
-	Endpoint:     schwabEndpoint,
+	Endpoint:     SchwabEndpoint(ApiBaseUrl),
+}
+
+// token the callback server received, or why the exchange or the server failed
+type CallbackResult struct {
+	Token *oauth2.Token
+	Err   error
 }
 
 // server to handle the callback after authentication
-func InitAuthCallbackServer(tokenChan chan *oauth2.Token) {
+func InitAuthCallbackServer(tokenChan chan CallbackResult) {
 	mux := http.NewServeMux()
 	server := &http.Server{
 		Addr:    ":" + port,
@@ -46,17 +67,18 @@ func InitAuthCallbackServer(tokenChan chan *oauth2.Token) {
 		fmt.Println("Auth code received: " + code)
 		token, err := OauthConfig.Exchange(context.Background(), code)
 		if err != nil {
-			log.Fatal("Failed to get token: " + err.Error())
+			http.Error(w, "Failed to get token, return to the application", http.StatusBadGateway)
+			tokenChan <- CallbackResult{Err: errors.New("failed to get token: " + err.Error())}
+			return
 		}
-		tokenChan <- token
+		tokenChan <- CallbackResult{Token: token}
 
 		w.Write([]byte("Authentication successful, you can close this window and return to the application."))
 	})
 
+	// reported to the login waiting for a token, if any
 	err := server.ListenAndServeTLS("127.0.0.1.pem", "127.0.0.1-key.pem")
-	if err != nil {
-		log.Fatal(err)
-	}
+	tokenChan <- CallbackResult{Err: errors.New("auth callback server stopped: " + err.Error())}
 }
 
 var ErrUnauthorized = errors.New("unauthorized")
@@ -84,7 +106,10 @@ func (ts *HookedTokenSource) Token() (*oauth2.Token, error) {
 
 	if ts.old == nil || ts.old != token {
 		fmt.Println("Token changed")
-		WriteTokenToFile(token)
+		if err := WriteTokenToFile(token); err != nil {
+			// the token is still usable for this session
+			fmt.Println("Failed to save token:", err)
+		}
 		ts.old = token
 	}
 
@@ -92,14 +117,14 @@ func (ts *HookedTokenSource) Token() (*oauth2.Token, error) {
 }
 
 // serialize, encrypt, and write token to file
-func WriteTokenToFile(token *oauth2.Token) {
+func WriteTokenToFile(token *oauth2.Token) error {
 	var tokenData []byte
 
 	tokenData, err := json.Marshal(token)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	encryption.EncryptToFile(tokenData, encryption.EncryptedTokenFilename)
+	return encryption.EncryptToFile(tokenData, encryption.EncryptedTokenFilename)
 }
 
 func ReadTokenFromFile() (*oauth2.Token, error) {
@@ -116,14 +141,22 @@ func ReadTokenFromFile() (*oauth2.Token, error) {
 	return token, nil
 }
 
-func Authenticate(tokenChan chan *oauth2.Token) *http.Client {
+func Authenticate(tokenChan chan CallbackResult) (*http.Client, error) {
 	authCodeUrl := OauthConfig.AuthCodeURL("", oauth2.AccessTypeOnline)
 	fmt.Fprintf(os.Stdout, "\nAuthenticate here:\n\n%v\n\n", authCodeUrl)
 
-	token := <-tokenChan
+	result := <-tokenChan
+	if result.Err != nil {
+		return nil, result.Err
+	}
 
-	hookedTokenSource := NewHookedTokenSource(token)
-	return oauth2.NewClient(context.Background(), hookedTokenSource)
+	hookedTokenSource := NewHookedTokenSource(result.Token)
+	return oauth2.NewClient(clientContext(), hookedTokenSource), nil
+}
+
+// api requests are rate limited and retried by the transport under the oauth2 client
+func clientContext() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport.New(http.DefaultTransport)})
 }
 
 func CreateClientFromTokenFile() (*http.Client, error) {
@@ -133,14 +166,14 @@ func CreateClientFromTokenFile() (*http.Client, error) {
 	}
 
 	hookedTokenSource := NewHookedTokenSource(token)
-	return oauth2.NewClient(context.Background(), hookedTokenSource), nil
+	return oauth2.NewClient(clientContext(), hookedTokenSource), nil
 }
 
-func InitClient(tokenChan chan *oauth2.Token, stateChan chan string) *http.Client {
+func InitClient(tokenChan chan CallbackResult, stateChan chan string) (*http.Client, error) {
 	client, err := CreateClientFromTokenFile()
 	if err != nil {
 		fmt.Println(err)
-		client = Authenticate(tokenChan)
+		return Authenticate(tokenChan)
 	}
-	return client
+	return client, nil
 }
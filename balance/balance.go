@@ -2,7 +2,6 @@ package balance
 
 import (
 	"cmp"
-	"log"
 	"maps"
 	"math"
 	"slices"
@@ -13,6 +12,46 @@ import (
 type Ticker = targetAllocation.Ticker
 type ShareQuantity = float64
 
+// tickers that trade in dollar amounts, e.g. mutual funds
+type DollarTickers = map[Ticker]bool
+
+// dollar amount of the shares at the price, rounded down to whole cents
+func DollarAmount(shares float64, price float64) float64 {
+	return math.Floor(shares*price*100+1e-6) / 100
+}
+
+// shares each ticker trades in multiples of, whole shares without an increment
+type Increments map[Ticker]float64
+
+// increments of the allocation's tickers at their prices
+func AllocationIncrements(allocation targetAllocation.TargetAllocation, prices map[Ticker]float64) Increments {
+	increments := make(Increments)
+	for ticker, alloc := range allocation {
+		if increment := alloc.Increment(prices[ticker]); increment != 1 {
+			increments[ticker] = increment
+		}
+	}
+	return increments
+}
+
+func (is Increments) Of(ticker Ticker) float64 {
+	if increment, ok := is[ticker]; ok && increment > 0 {
+		return increment
+	}
+	return 1
+}
+
+// shares rounded toward zero to a multiple of the increment of the ticker
+func (is Increments) Truncate(ticker Ticker, shares float64) float64 {
+	return TruncateShares(shares, is.Of(ticker))
+}
+
+// shares rounded toward zero to a multiple of increment
+func TruncateShares(shares float64, increment float64) float64 {
+	units := math.Floor(math.Abs(shares)/increment + 1e-6)
+	return math.Copysign(math.Round(units*increment*1e9)/1e9, shares)
+}
+
 type Holding struct {
 	Ticker Ticker
 	Amount ShareQuantity
@@ -30,71 +69,114 @@ func PurchasePriorityFunc(totalHoldingsValue float64, prices map[Ticker]float64,
 	}
 }
 
-// panics on error
-func AssertValidHoldingPrices(holdings map[Ticker]float64, prices map[Ticker]float64) {
-	for k := range holdings {
+type MissingPriceError struct {
+	Ticker Ticker
+}
+
+func (e *MissingPriceError) Error() string {
+	return "price for " + e.Ticker + " not found"
+}
+
+// returns a *MissingPriceError for the first holding without a price
+func ValidateHoldingPrices(holdings map[Ticker]float64, prices map[Ticker]float64) error {
+	for _, k := range slices.Sorted(maps.Keys(holdings)) {
 		if _, ok := prices[k]; !ok {
-			log.Fatal("price for " + k + " in holdings not found")
+			return &MissingPriceError{k}
 		}
 	}
+	return nil
 }
 
-// panics on error
-func AssertValidDesiredAllocationPrices(tickers []Ticker, prices map[Ticker]float64) {
+// returns a *MissingPriceError for the first ticker without a price
+func ValidateDesiredAllocationPrices(tickers []Ticker, prices map[Ticker]float64) error {
 	for _, ticker := range tickers {
 		if _, ok := prices[ticker]; !ok {
-			log.Fatal("price for " + ticker + " not found")
+			return &MissingPriceError{ticker}
 		}
 	}
+	return nil
 }
 
-// returns purchases to be made and remaining cash
+// panics with a *MissingPriceError, callers validate prices first
+func AssertValidHoldingPrices(holdings map[Ticker]float64, prices map[Ticker]float64) {
+	if err := ValidateHoldingPrices(holdings, prices); err != nil {
+		panic(err)
+	}
+}
+
+// panics with a *MissingPriceError, callers validate prices first
+func AssertValidDesiredAllocationPrices(tickers []Ticker, prices map[Ticker]float64) {
+	if err := ValidateDesiredAllocationPrices(tickers, prices); err != nil {
+		panic(err)
+	}
+}
+
+// returns whole share purchases closest to the proportion targets and remaining cash
 func FillProportions(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, proportionTargets map[Ticker]float64) (map[Ticker]float64, float64) {
-	holdingsSlice := make([]Holding, 0)
-	for ticker := range proportionTargets {
-		holdingsSlice = append(holdingsSlice, Holding{ticker, holdings[ticker]})
+	return FillProportionsIncrements(cash, holdings, prices, proportionTargets, nil)
+}
+
+// like FillProportions, buying multiples of the increments of the tickers
+func FillProportionsIncrements(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, proportionTargets map[Ticker]float64, increments Increments) (map[Ticker]float64, float64) {
+	return fillProportions(cash, holdings, prices, proportionTargets, increments, constraints{})
+}
+
+// Like FillProportionsIncrements, the targets kept within the bounds of the constraints, which cap the purchases.
+func fillProportions(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, proportionTargets map[Ticker]float64, increments Increments, c constraints) (map[Ticker]float64, float64) {
+	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(proportionTargets)), prices)
+	tickers := slices.Sorted(maps.Keys(proportionTargets))
+	totalValue := cash
+	for _, ticker := range tickers {
+		totalValue += holdings[ticker] * prices[ticker]
 	}
-	minPrice := math.MaxFloat64
-	availablePrices := make(map[Ticker]float64)
-	for ticker := range proportionTargets {
-		availablePrices[ticker] = prices[ticker]
+	bounds := func(ticker Ticker) (float64, float64) {
+		return c.bounds(ticker, totalValue)
 	}
-	for _, v := range availablePrices {
-		if v < minPrice {
-			minPrice = v
+	targets := boundedTargets(proportionTargets, totalValue, bounds)
+	items := make([]solverItem, len(tickers))
+	for i, ticker := range tickers {
+		items[i] = solverItem{
+			ticker:    ticker,
+			price:     increments.Of(ticker) * prices[ticker],
+			offset:    holdings[ticker]*prices[ticker] - targets[ticker],
+			maxShares: math.Inf(1),
+		}
+		if _, most := bounds(ticker); !math.IsInf(most, 1) {
+			items[i].maxShares = math.Max(0, math.Floor((most-holdings[ticker]*prices[ticker])/items[i].price+1e-9))
 		}
 	}
+
 	purchases := make(map[Ticker]float64, 0)
-	for cash >= minPrice {
-		totalHoldingsValue := 0.0
-		for _, v := range holdingsSlice {
-			totalHoldingsValue += v.Amount * prices[v.Ticker]
-		}
-		slices.SortFunc(holdingsSlice, PurchasePriorityFunc(totalHoldingsValue, prices, proportionTargets))
-		// buy the asset with the most negative deviation that can be afforded
-		for i, holding := range holdingsSlice {
-			if prices[holding.Ticker] > cash {
-				continue
-			}
-			purchases[holding.Ticker] += 1
-			holdingsSlice[i].Amount += 1
-			cash -= prices[holding.Ticker]
-			break
+	for i, units := range solvePurchases(items, cash) {
+		if units > 0 {
+			ticker := items[i].ticker
+			purchases[ticker] = increments.Truncate(ticker, units*increments.Of(ticker))
+			cash -= purchases[ticker] * prices[ticker]
 		}
 	}
 	return purchases, cash
 }
 
-// Returns purchases to be made and remaining cash.
-// Note that partial shares can not be bought
+// returns purchases to be made and remaining cash, partial shares only in the increments of the allocation
 func BalancePurchase(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation) (map[Ticker]float64, float64) {
+	return BalancePurchaseDollars(cash, holdings, prices, targetAllocation, nil)
+}
+
+// like BalancePurchase, with dollar tickers bought to the cent
+func BalancePurchaseDollars(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers) (map[Ticker]float64, float64) {
+	return balancePurchase(cash, holdings, prices, targetAllocation, dollarTickers, allocationConstraints(targetAllocation, holdings, prices))
+}
 
+func balancePurchase(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers, c constraints) (map[Ticker]float64, float64) {
 	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(targetAllocation)), prices)
 	AssertValidHoldingPrices(holdings, prices)
 
 	fixedTargets := make(map[Ticker]float64, 0)
+	fixedDollarTargets := make(map[Ticker]float64, 0)
 	for ticker, alloc := range targetAllocation {
-		if alloc.FixedCashValue != 0 {
+		if alloc.FixedCashValue != 0 && dollarTickers[ticker] {
+			fixedDollarTargets[ticker] = alloc.FixedCashValue
+		} else if alloc.FixedCashValue != 0 {
 			fixedTargets[ticker] = alloc.FixedCashValue
 		}
 	}
@@ -105,33 +187,141 @@ func BalancePurchase(cash float64, holdings map[Ticker]float64, prices map[Ticke
 		}
 	}
 
-	fixedPurchases, cash := FillFixed(cash, holdings, prices, fixedTargets)
-	proportionPurchases, cash := FillProportions(cash, holdings, prices, proportionTargets)
+	increments := AllocationIncrements(targetAllocation, prices)
+	fixedPurchases, cash := fillFixed(cash, holdings, prices, fixedTargets, increments, c)
+	fixedDollarPurchases, cash := fillFixedDollars(cash, holdings, prices, fixedDollarTargets, c)
+	proportionPurchases, cash := fillProportions(cash, holdings, prices, proportionTargets, increments, c)
 
 	purchases := make(map[Ticker]float64, 0)
 	for k, v := range fixedPurchases {
 		purchases[k] += v
 	}
+	for k, v := range fixedDollarPurchases {
+		purchases[k] += v
+	}
 	for k, v := range proportionPurchases {
 		purchases[k] += v
 	}
+
+	if len(dollarTickers) > 0 {
+		newHoldings := make(map[Ticker]float64, 0)
+		maps.Copy(newHoldings, holdings)
+		for k, v := range purchases {
+			newHoldings[k] += v
+		}
+		var dollarPurchases map[Ticker]float64
+		dollarPurchases, cash = fillProportionsDollars(cash, newHoldings, prices, proportionTargets, dollarTickers, c)
+		for k, v := range dollarPurchases {
+			purchases[k] += v
+		}
+	}
 	return purchases, cash
 }
 
+// returns fractional purchases worth whole cents that bring the tickers up to their fixed cash values, and remaining cash
+func FillFixedDollars(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, fixedTargets map[Ticker]float64) (map[Ticker]float64, float64) {
+	return fillFixedDollars(cash, holdings, prices, fixedTargets, constraints{})
+}
+
+// like FillFixedDollars, the fixed cash values capped by the constraints
+func fillFixedDollars(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, fixedTargets map[Ticker]float64, c constraints) (map[Ticker]float64, float64) {
+	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(fixedTargets)), prices)
+	result := make(map[Ticker]float64, 0)
+	for _, ticker := range slices.Sorted(maps.Keys(fixedTargets)) {
+		_, most := c.bounds(ticker, 0)
+		diff := math.Min(fixedTargets[ticker], most) - holdings[ticker]*prices[ticker]
+		spend := math.Floor(math.Min(diff, cash)*100) / 100
+		if spend > 0 {
+			result[ticker] = spend / prices[ticker]
+			cash -= spend
+		}
+	}
+	return result, cash
+}
+
+// returns fractional purchases of the dollar tickers and remaining cash
+func FillProportionsDollars(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, proportionTargets map[Ticker]float64, dollarTickers DollarTickers) (map[Ticker]float64, float64) {
+	return fillProportionsDollars(cash, holdings, prices, proportionTargets, dollarTickers, constraints{})
+}
+
+// like FillProportionsDollars, the targets kept within the bounds of the constraints, which cap the purchases
+func fillProportionsDollars(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, proportionTargets map[Ticker]float64, dollarTickers DollarTickers, c constraints) (map[Ticker]float64, float64) {
+	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(proportionTargets)), prices)
+	result := make(map[Ticker]float64, 0)
+
+	totalValue := cash
+	for ticker := range proportionTargets {
+		totalValue += holdings[ticker] * prices[ticker]
+	}
+	bounds := func(ticker Ticker) (float64, float64) {
+		return c.bounds(ticker, totalValue)
+	}
+	targets := boundedTargets(proportionTargets, totalValue, bounds)
+	room := func(ticker Ticker) float64 {
+		_, most := bounds(ticker)
+		return most - holdings[ticker]*prices[ticker]
+	}
+	weights := make(map[Ticker]float64)
+	totalWeight := 0.0
+	for ticker := range proportionTargets {
+		if !dollarTickers[ticker] || room(ticker) <= 0 {
+			continue
+		}
+		if deficit := targets[ticker] - holdings[ticker]*prices[ticker]; deficit > 0 {
+			weights[ticker] = deficit
+			totalWeight += deficit
+		}
+	}
+	if totalWeight == 0 {
+		for ticker, proportion := range proportionTargets {
+			if dollarTickers[ticker] && room(ticker) > 0 {
+				weights[ticker] = proportion
+				totalWeight += proportion
+			}
+		}
+	}
+	if totalWeight == 0 {
+		return result, cash
+	}
+
+	available := cash
+	for _, ticker := range slices.Sorted(maps.Keys(weights)) {
+		spend := math.Floor(math.Min(available*weights[ticker]/totalWeight, room(ticker))*100) / 100
+		if spend > 0 {
+			result[ticker] = spend / prices[ticker]
+			cash -= spend
+		}
+	}
+	return result, cash
+}
+
 // returns purchases to be made and remaining cash
 func FillFixed(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, fixedTargets map[Ticker]float64) (map[Ticker]float64, float64) {
+	return FillFixedIncrements(cash, holdings, prices, fixedTargets, nil)
+}
+
+// like FillFixed, buying multiples of the increments of the tickers
+func FillFixedIncrements(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, fixedTargets map[Ticker]float64, increments Increments) (map[Ticker]float64, float64) {
+	return fillFixed(cash, holdings, prices, fixedTargets, increments, constraints{})
+}
+
+// like FillFixedIncrements, the purchases capped by the constraints
+func fillFixed(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, fixedTargets map[Ticker]float64, increments Increments, c constraints) (map[Ticker]float64, float64) {
 	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(fixedTargets)), prices)
 	AssertValidHoldingPrices(holdings, prices)
 	result := make(map[Ticker]float64, 0)
-	for ticker, alloc := range fixedTargets {
-		diff := alloc - holdings[ticker]*prices[ticker]
+	for _, ticker := range slices.Sorted(maps.Keys(fixedTargets)) {
+		diff := fixedTargets[ticker] - holdings[ticker]*prices[ticker]
 		if diff > 0 {
 			spendAmount := math.Min(diff*prices[ticker], cash)
-			r := math.Floor(spendAmount / prices[ticker])
+			if _, most := c.bounds(ticker, 0); !math.IsInf(most, 1) {
+				spendAmount = math.Min(spendAmount, most-holdings[ticker]*prices[ticker])
+			}
+			r := increments.Truncate(ticker, math.Max(spendAmount, 0)/prices[ticker])
 			if r > 0 {
 				result[ticker] = r
 			}
-			cash -= float64(r) * prices[ticker]
+			cash -= r * prices[ticker]
 		}
 	}
 	return result, cash
@@ -139,19 +329,43 @@ func FillFixed(cash float64, holdings map[Ticker]float64, prices map[Ticker]floa
 
 // returns purchases and sales to be made and remaining cash
 func RebalanceWithSelling(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation) (map[Ticker]float64, float64) {
+	return RebalanceWithSellingDollars(cash, holdings, prices, targetAllocation, nil)
+}
+
+// like RebalanceWithSelling, with dollar tickers traded in fractional shares
+func RebalanceWithSellingDollars(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers) (map[Ticker]float64, float64) {
 	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(targetAllocation)), prices)
 	AssertValidHoldingPrices(holdings, prices)
 
 	// simulate selling all stocks and buying at proper proportions
-	// exclude fractional shares from selling logic
+	// exclude shares short of an increment from selling logic, unless they trade in dollars
+	// tickers that are never sold are kept, tickers that are never bought are bought back up to what is held
+	increments := AllocationIncrements(targetAllocation, prices)
+	c := allocationConstraints(targetAllocation, holdings, prices)
 	newHoldings := make(map[Ticker]float64, 0)
 	for ticker, quantity := range holdings {
-		cash += math.Floor(quantity) * prices[ticker]
+		if c.neverSell(ticker) {
+			newHoldings[ticker] = quantity
+		} else if dollarTickers[ticker] {
+			cash += quantity * prices[ticker]
+		} else {
+			cash += increments.Truncate(ticker, quantity) * prices[ticker]
+		}
+	}
+	purchases, cash := balancePurchase(cash, newHoldings, prices, targetAllocation, dollarTickers, c)
+	for ticker, quantity := range purchases {
+		newHoldings[ticker] += quantity
 	}
-	newHoldings, cash = BalancePurchase(cash, newHoldings, prices, targetAllocation)
 	purchasesAndSales := make(map[Ticker]float64, 0)
 	for ticker := range newHoldings {
-		difference := float64(int64(newHoldings[ticker] - holdings[ticker]))
+		if dollarTickers[ticker] {
+			difference := newHoldings[ticker] - holdings[ticker]
+			if DollarAmount(math.Abs(difference), prices[ticker]) > 0 {
+				purchasesAndSales[ticker] = difference
+			}
+			continue
+		}
+		difference := increments.Truncate(ticker, newHoldings[ticker]-holdings[ticker])
 		if difference != 0 {
 			purchasesAndSales[ticker] = difference
 		}
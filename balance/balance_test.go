@@ -1,7 +1,10 @@
 package balance
 
 import (
+	"math"
+	"math/rand/v2"
 	"reflect"
+	"slices"
 	"testing"
 
 	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
@@ -41,10 +44,11 @@ func TestBalancePurchase(t *testing.T) {
 				"DFEM":  10,
 				"SWVXX": 1,
 			},
+			// one share at a time bought DFAC 10, DFIC 6 and DFEM 8, further from the targets
 			expectedPurchases: map[string]float64{
-				"DFAC":  10,
-				"DFIC":  6,
-				"DFEM":  8,
+				"DFAC":  11,
+				"DFIC":  5,
+				"DFEM":  7,
 				"SWVXX": 2,
 			},
 			expectedCashRemaining: 1.1,
@@ -126,6 +130,70 @@ func TestBalancePurchase(t *testing.T) {
 	}
 }
 
+func TestBalancePurchaseDollars(t *testing.T) {
+	tests := []struct {
+		cash                  float64
+		targetAllocation      targetAllocation.TargetAllocation
+		holdings              map[string]float64
+		prices                map[string]float64
+		dollarTickers         DollarTickers
+		expectedPurchases     map[string]float64
+		expectedCashRemaining float64
+	}{
+		{
+			cash: 850,
+			targetAllocation: targetAllocation.TargetAllocation{
+				"VTI":   {Proportion: 0.5},
+				"FXAIX": {Proportion: 0.5},
+				"SWVXX": {FixedCashValue: 100},
+			},
+			holdings:              map[string]float64{"VTI": 1, "FXAIX": 1, "SWVXX": 100},
+			prices:                map[string]float64{"VTI": 300, "FXAIX": 200, "SWVXX": 1},
+			dollarTickers:         DollarTickers{"FXAIX": true, "SWVXX": true},
+			expectedPurchases:     map[string]float64{"VTI": 1, "FXAIX": 2.75},
+			expectedCashRemaining: 0,
+		},
+		{
+			cash: 850,
+			targetAllocation: targetAllocation.TargetAllocation{
+				"VTI":   {Proportion: 0.5},
+				"FXAIX": {Proportion: 0.5},
+				"SWVXX": {FixedCashValue: 100},
+			},
+			holdings:              map[string]float64{"VTI": 1, "FXAIX": 1, "SWVXX": 100},
+			prices:                map[string]float64{"VTI": 300, "FXAIX": 200, "SWVXX": 1},
+			expectedPurchases:     map[string]float64{"VTI": 1, "FXAIX": 2},
+			expectedCashRemaining: 150,
+		},
+		{
+			cash: 500,
+			targetAllocation: targetAllocation.TargetAllocation{
+				"VTI":  {Proportion: 1},
+				"FUND": {FixedCashValue: 1000},
+			},
+			holdings:              map[string]float64{"VTI": 1},
+			prices:                map[string]float64{"VTI": 300, "FUND": 12.34},
+			dollarTickers:         DollarTickers{"FUND": true},
+			expectedPurchases:     map[string]float64{"FUND": 500 / 12.34},
+			expectedCashRemaining: 0,
+		},
+	}
+	for i, test := range tests {
+		purchases, cash := BalancePurchaseDollars(test.cash, test.holdings, test.prices, test.targetAllocation, test.dollarTickers)
+		if !reflect.DeepEqual(purchases, test.expectedPurchases) {
+			t.Errorf("expected purchases: %v, got %v, test index: %v", test.expectedPurchases, purchases, i)
+		}
+		if !util.AlmostEqual(cash, test.expectedCashRemaining, 1e-7) {
+			t.Errorf("expected cash remaining: %v, got %v, test index: %v", test.expectedCashRemaining, cash, i)
+		}
+		for ticker, quantity := range purchases {
+			if test.dollarTickers[ticker] && !util.AlmostEqual(DollarAmount(quantity, test.prices[ticker]), quantity*test.prices[ticker], 1e-7) {
+				t.Errorf("expected whole cents of %v, got $%v, test index: %v", ticker, quantity*test.prices[ticker], i)
+			}
+		}
+	}
+}
+
 func TestRebalanceWithSelling(t *testing.T) {
 	alloc1, err := targetAllocation.LoadTargetAllocations("testing/targetAllocation_balanceTest1.yaml")
 	if err != nil {
@@ -288,3 +356,590 @@ func TestRebalanceWithSelling(t *testing.T) {
 		}
 	}
 }
+
+func TestRebalanceHousehold(t *testing.T) {
+	alloc1, err := targetAllocation.LoadTargetAllocations("testing/targetAllocation_balanceTest1.yaml")
+	if err != nil {
+		t.Fatal("cannot continue testing TestRebalanceHousehold: " + err.Error())
+	}
+
+	tests := []struct {
+		accounts                  []AccountHoldings
+		targetAllocation          targetAllocation.TargetAllocation
+		prices                    map[string]float64
+		expectedPurchasesAndSales []map[string]float64
+		expectedCashRemaining     []float64
+	}{
+		{
+			accounts: []AccountHoldings{
+				{
+					Cash: 0,
+					Holdings: map[string]float64{
+						"DFAC": 5000,
+					},
+				},
+				{
+					Cash: 1000,
+					Holdings: map[string]float64{
+						"SWVXX": 4000,
+					},
+				},
+			},
+			targetAllocation: alloc1["global"],
+			prices: map[string]float64{
+				"DFAC":  1,
+				"DFIC":  1,
+				"DFEM":  1,
+				"SWVXX": 1,
+			},
+			expectedPurchasesAndSales: []map[string]float64{
+				{
+					"DFAC": -1160,
+					"DFEM": 540,
+					"DFIC": 620,
+				},
+				{
+					"DFIC": 1000,
+				},
+			},
+			expectedCashRemaining: []float64{0, 0},
+		},
+		{
+			accounts: []AccountHoldings{
+				{
+					Cash: 0,
+					Holdings: map[string]float64{
+						"DFAC":  640,
+						"DFIC":  270,
+						"DFEM":  90,
+						"SWVXX": 2000,
+					},
+				},
+				{
+					Cash: 1000,
+					Holdings: map[string]float64{
+						"SWVXX": 2000,
+					},
+				},
+			},
+			targetAllocation: alloc1["global"],
+			prices: map[string]float64{
+				"DFAC":  1,
+				"DFIC":  1,
+				"DFEM":  1,
+				"SWVXX": 1,
+			},
+			expectedPurchasesAndSales: []map[string]float64{
+				{},
+				{
+					"DFAC": 640,
+					"DFIC": 270,
+					"DFEM": 90,
+				},
+			},
+			expectedCashRemaining: []float64{0, 0},
+		},
+		// DFAC is capped at 30% of the household, DFIC takes the rest
+		{
+			accounts: []AccountHoldings{
+				{
+					Cash: 0,
+					Holdings: map[string]float64{
+						"DFAC": 5000,
+					},
+				},
+				{
+					Cash:     1000,
+					Holdings: map[string]float64{},
+				},
+			},
+			targetAllocation: targetAllocation.TargetAllocation{
+				"DFAC": {Proportion: 0.5, MaxProportion: ptr(0.3)},
+				"DFIC": {Proportion: 0.5},
+			},
+			prices: map[string]float64{
+				"DFAC": 1,
+				"DFIC": 1,
+			},
+			expectedPurchasesAndSales: []map[string]float64{
+				{
+					"DFAC": -3200,
+					"DFIC": 3200,
+				},
+				{
+					"DFIC": 1000,
+				},
+			},
+			expectedCashRemaining: []float64{0, 0},
+		},
+	}
+
+	for i, test := range tests {
+		purchasesAndSales, cash := RebalanceHousehold(test.accounts, test.prices, test.targetAllocation)
+		if !reflect.DeepEqual(purchasesAndSales, test.expectedPurchasesAndSales) {
+			t.Errorf("expected purchases and sales: %v, got %v, on test index %v", test.expectedPurchasesAndSales, purchasesAndSales, i)
+		}
+		for j := range cash {
+			if !util.AlmostEqual(cash[j], test.expectedCashRemaining[j], 1e-7) {
+				t.Errorf("expected cash remaining: %v, got %v, on test index %v", test.expectedCashRemaining, cash, i)
+			}
+		}
+	}
+}
+
+func TestValidatePrices(t *testing.T) {
+	prices := map[Ticker]float64{"VTI": 100}
+	if err := ValidateHoldingPrices(map[Ticker]float64{"VTI": 1}, prices); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	err := ValidateHoldingPrices(map[Ticker]float64{"VTI": 1, "VXUS": 1}, prices)
+	if e, ok := err.(*MissingPriceError); !ok || e.Ticker != "VXUS" {
+		t.Errorf("expected missing price for VXUS, got %v", err)
+	}
+	err = ValidateDesiredAllocationPrices([]Ticker{"VTI", "VWO"}, prices)
+	if e, ok := err.(*MissingPriceError); !ok || e.Ticker != "VWO" {
+		t.Errorf("expected missing price for VWO, got %v", err)
+	}
+}
+
+func TestRebalanceWithBands(t *testing.T) {
+	allocation := targetAllocation.TargetAllocation{
+		"VTI":  {Proportion: 0.5, AbsoluteBand: 0.05, RelativeBand: 0.25},
+		"VXUS": {Proportion: 0.3, AbsoluteBand: 0.05, RelativeBand: 0.25},
+		"BND":  {Proportion: 0.2, AbsoluteBand: 0.05, RelativeBand: 0.25},
+	}
+	prices := map[string]float64{"VTI": 10, "VXUS": 10, "BND": 10, "OLD": 10}
+
+	tests := []struct {
+		cash           float64
+		holdings       map[string]float64
+		toBandEdge     bool
+		expectedOrders map[string]float64
+		expectedCash   float64
+		expectedInBand []string
+	}{
+		{
+			holdings:       map[string]float64{"VTI": 60, "VXUS": 30, "BND": 10},
+			expectedOrders: map[string]float64{"VTI": -10, "BND": 10},
+			expectedCash:   0,
+			expectedInBand: []string{"VXUS"},
+		},
+		{
+			holdings:       map[string]float64{"VTI": 60, "VXUS": 30, "BND": 10},
+			toBandEdge:     true,
+			expectedOrders: map[string]float64{"VTI": -5, "BND": 5},
+			expectedCash:   0,
+			expectedInBand: []string{"VXUS"},
+		},
+		{
+			holdings:       map[string]float64{"VTI": 60, "VXUS": 30, "BND": 10, "OLD": 5},
+			expectedOrders: map[string]float64{"VTI": -7, "OLD": -5, "BND": 11},
+			expectedCash:   10,
+			expectedInBand: []string{"VXUS"},
+		},
+		{
+			holdings:       map[string]float64{"VTI": 52, "VXUS": 29, "BND": 19},
+			expectedOrders: map[string]float64{},
+			expectedCash:   0,
+			expectedInBand: []string{"BND", "VTI", "VXUS"},
+		},
+	}
+	for i, test := range tests {
+		orders, cash, drifts := RebalanceWithBands(test.cash, test.holdings, prices, allocation, nil, test.toBandEdge)
+		if !reflect.DeepEqual(orders, test.expectedOrders) {
+			t.Errorf("expected orders: %v, got %v, test index: %v", test.expectedOrders, orders, i)
+		}
+		if !util.AlmostEqual(cash, test.expectedCash, 1e-7) {
+			t.Errorf("expected cash remaining: %v, got %v, test index: %v", test.expectedCash, cash, i)
+		}
+		inBand := make([]string, 0)
+		for _, drift := range drifts {
+			if drift.InBand {
+				inBand = append(inBand, drift.Ticker)
+			}
+		}
+		if !reflect.DeepEqual(inBand, test.expectedInBand) {
+			t.Errorf("expected in band: %v, got %v, test index: %v", test.expectedInBand, inBand, i)
+		}
+	}
+}
+
+func TestRebalanceWithGainBudget(t *testing.T) {
+	allocation := targetAllocation.TargetAllocation{
+		"VTI":  {Proportion: 0.4},
+		"VXUS": {Proportion: 0.4},
+		"BND":  {Proportion: 0.2},
+	}
+	prices := map[string]float64{"VTI": 10, "VXUS": 10, "BND": 10}
+	holdings := map[string]float64{"VTI": 50, "VXUS": 50, "BND": 0}
+	unconstrained, _ := RebalanceWithSelling(0, holdings, prices, allocation)
+	// VTI gains 5 a share long term, VXUS loses 1 a share short term and gains 2 a share once those are sold
+	gains := func(ticker string, shares float64) (float64, float64) {
+		switch ticker {
+		case "VTI":
+			return 0, 5 * shares
+		case "VXUS":
+			if shares <= 4 {
+				return -shares, 0
+			}
+			return -4 + 2*(shares-4), 0
+		}
+		return 0, 0
+	}
+
+	tests := []struct {
+		budget         GainBudget
+		expectedOrders map[string]float64
+	}{
+		{GainBudget{MaxGain: math.Inf(1)}, unconstrained},
+		// the losses of the first 4 VXUS offset the gains of the next 2
+		{GainBudget{MaxGain: 0}, map[string]float64{"VXUS": -6, "BND": 6}},
+		{GainBudget{MaxGain: 30}, map[string]float64{"VTI": -4, "VXUS": -10, "BND": 14}},
+		{GainBudget{MaxGain: math.Inf(1), NoShortTermGains: true}, map[string]float64{"VTI": -10, "VXUS": -6, "BND": 16}},
+	}
+	for i, test := range tests {
+		orders, cash := RebalanceWithGainBudget(0, holdings, prices, allocation, nil, unconstrained, gains, test.budget)
+		if !reflect.DeepEqual(orders, test.expectedOrders) {
+			t.Errorf("expected orders %v, got %v, test index: %v", test.expectedOrders, orders, i)
+		}
+		if cash != 0 {
+			t.Errorf("expected no cash left, got %v, test index: %v", cash, i)
+		}
+	}
+}
+
+func TestLocateAssets(t *testing.T) {
+	accounts := []LocationAccount{
+		{Type: targetAllocation.Taxable, Value: 10000, MaxCash: 0},
+		{Type: targetAllocation.Traditional, Value: 5000, MaxCash: math.Inf(1)},
+		{Type: targetAllocation.Roth, Value: 5000, MaxCash: math.Inf(1)},
+	}
+	global := targetAllocation.TargetAllocation{
+		"SWVXX": {FixedCashValue: 1000},
+		"BND":   {Proportion: 0.25, Location: []targetAllocation.AccountType{targetAllocation.Traditional}},
+		"VXUS":  {Proportion: 0.25, Location: []targetAllocation.AccountType{targetAllocation.Taxable}},
+		"VTI":   {Proportion: 0.5, Location: []targetAllocation.AccountType{targetAllocation.Roth, targetAllocation.Taxable}},
+	}
+	// cash only fits the traditional account, which has room for 4000 of the 4750 of BND,
+	// the roth account takes 5000 of VTI and the rest of VTI and BND end up in the taxable account
+	expected := []targetAllocation.TargetAllocation{
+		{"VXUS": {Proportion: 0.475}, "VTI": {Proportion: 0.45}, "BND": {Proportion: 0.075}},
+		{"SWVXX": {FixedCashValue: 1000}, "BND": {Proportion: 1}},
+		{"VTI": {Proportion: 1}},
+	}
+	located, err := LocateAssets(accounts, global)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if len(located[i]) != len(expected[i]) {
+			t.Errorf("expected %v, got %v on test index %v", expected[i], located[i], i)
+			continue
+		}
+		for ticker, allocation := range expected[i] {
+			got := located[i][ticker]
+			if !util.AlmostEqual(got.Proportion, allocation.Proportion, 1e-9) || !util.AlmostEqual(got.FixedCashValue, allocation.FixedCashValue, 1e-9) {
+				t.Errorf("expected %v of %+v, got %+v on test index %v", ticker, allocation, got, i)
+			}
+		}
+	}
+
+	accounts[1].MaxCash = 500
+	accounts[2].MaxCash = 0
+	if _, err := LocateAssets(accounts, global); err == nil {
+		t.Error("expected an error when the fixed cash value exceeds the cash limits")
+	}
+}
+
+// the one share at a time fill FillProportions replaced, kept to compare against
+func greedyFillProportions(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, proportionTargets map[Ticker]float64) (map[Ticker]float64, float64) {
+	holdingsSlice := make([]Holding, 0)
+	minPrice := math.MaxFloat64
+	for ticker := range proportionTargets {
+		holdingsSlice = append(holdingsSlice, Holding{ticker, holdings[ticker]})
+		minPrice = math.Min(minPrice, prices[ticker])
+	}
+	purchases := make(map[Ticker]float64, 0)
+	for cash >= minPrice {
+		totalHoldingsValue := 0.0
+		for _, v := range holdingsSlice {
+			totalHoldingsValue += v.Amount * prices[v.Ticker]
+		}
+		slices.SortFunc(holdingsSlice, PurchasePriorityFunc(totalHoldingsValue, prices, proportionTargets))
+		for i, holding := range holdingsSlice {
+			if prices[holding.Ticker] > cash {
+				continue
+			}
+			purchases[holding.Ticker] += 1
+			holdingsSlice[i].Amount += 1
+			cash -= prices[holding.Ticker]
+			break
+		}
+	}
+	return purchases, cash
+}
+
+// summed squared differences from the targets of the holdings and the cash, which FillProportions minimizes
+func squaredDeviation(cash float64, holdings map[Ticker]float64, purchases map[Ticker]float64, prices map[Ticker]float64, proportionTargets map[Ticker]float64) float64 {
+	total := cash
+	for ticker := range proportionTargets {
+		total += holdings[ticker] * prices[ticker]
+	}
+	left := cash
+	sum := 0.0
+	for ticker, proportion := range proportionTargets {
+		left -= purchases[ticker] * prices[ticker]
+		deviation := (holdings[ticker]+purchases[ticker])*prices[ticker] - proportion*total
+		sum += deviation * deviation
+	}
+	return sum + left*left
+}
+
+func TestFillProportionsOptimal(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	tickers := []Ticker{"A", "B", "C"}
+	for i := range 200 {
+		cash := float64(rng.IntN(300))
+		holdings := make(map[Ticker]float64)
+		prices := make(map[Ticker]float64)
+		targets := make(map[Ticker]float64)
+		weights := 0.0
+		for _, ticker := range tickers {
+			holdings[ticker] = float64(rng.IntN(10))
+			prices[ticker] = float64(5 + rng.IntN(60))
+			targets[ticker] = rng.Float64()
+			weights += targets[ticker]
+		}
+		for _, ticker := range tickers {
+			targets[ticker] /= weights
+		}
+
+		// every affordable combination of purchases
+		best := math.Inf(1)
+		for a := 0.0; a*prices["A"] <= cash; a++ {
+			for b := 0.0; a*prices["A"]+b*prices["B"] <= cash; b++ {
+				for c := 0.0; a*prices["A"]+b*prices["B"]+c*prices["C"] <= cash; c++ {
+					best = math.Min(best, squaredDeviation(cash, holdings, map[Ticker]float64{"A": a, "B": b, "C": c}, prices, targets))
+				}
+			}
+		}
+		purchases, left := FillProportions(cash, holdings, prices, targets)
+		if left < -1e-9 {
+			t.Errorf("spent more than %v, %v left on test index %v", cash, left, i)
+		}
+		if got := squaredDeviation(cash, holdings, purchases, prices, targets); got > best+1e-6 {
+			t.Errorf("expected a squared deviation of %v, got %v with %v on test index %v", best, got, purchases, i)
+		}
+		greedy, _ := greedyFillProportions(cash, holdings, prices, targets)
+		if got, greedyDeviation := squaredDeviation(cash, holdings, purchases, prices, targets), squaredDeviation(cash, holdings, greedy, prices, targets); got > greedyDeviation+1e-6 {
+			t.Errorf("expected no more deviation than the greedy fill %v, got %v on test index %v", greedyDeviation, got, i)
+		}
+	}
+}
+
+func benchmarkFill(b *testing.B, fill func(float64, map[Ticker]float64, map[Ticker]float64, map[Ticker]float64) (map[Ticker]float64, float64)) {
+	holdings := map[Ticker]float64{"VTI": 100, "VXUS": 40, "BND": 20, "VNQ": 0, "VWO": 10}
+	prices := map[Ticker]float64{"VTI": 10.37, "VXUS": 9.81, "BND": 10.02, "VNQ": 9.55, "VWO": 10.4}
+	targets := map[Ticker]float64{"VTI": 0.45, "VXUS": 0.25, "BND": 0.15, "VNQ": 0.05, "VWO": 0.1}
+	for b.Loop() {
+		fill(50000, holdings, prices, targets)
+	}
+}
+
+func BenchmarkFillProportions(b *testing.B) {
+	benchmarkFill(b, FillProportions)
+}
+
+func BenchmarkGreedyFillProportions(b *testing.B) {
+	benchmarkFill(b, greedyFillProportions)
+}
+
+func TestBalancePurchaseIncrements(t *testing.T) {
+	allocation := targetAllocation.TargetAllocation{
+		"VTI":  {Proportion: 0.6, ShareIncrement: 0.001},
+		"VXUS": {Proportion: 0.4, DollarIncrement: 1},
+	}
+	prices := map[string]float64{"VTI": 287.53, "VXUS": 61.17}
+	tests := []struct {
+		cash     float64
+		holdings map[string]float64
+	}{
+		{1000, map[string]float64{}},
+		{250.5, map[string]float64{"VTI": 1.25, "VXUS": 3}},
+		{12.34, map[string]float64{}},
+	}
+	for i, test := range tests {
+		purchases, cash := BalancePurchase(test.cash, test.holdings, prices, allocation)
+		increments := AllocationIncrements(allocation, prices)
+		for ticker, quantity := range purchases {
+			if increments.Truncate(ticker, quantity) != quantity {
+				t.Errorf("expected a multiple of %v %v, got %v, on test index %v", increments.Of(ticker), ticker, quantity, i)
+			}
+		}
+		// less than the smallest increment is left, whole shares would leave up to a share of VTI
+		if cash < 0 || cash >= 1+1e-9 {
+			t.Errorf("expected less than $1 left of $%v, got %v with purchases %v, on test index %v", test.cash, cash, purchases, i)
+		}
+	}
+}
+
+func TestRebalanceWithSellingIncrements(t *testing.T) {
+	allocation := targetAllocation.TargetAllocation{
+		"VTI":  {Proportion: 0.5, ShareIncrement: 0.01},
+		"VXUS": {Proportion: 0.5},
+	}
+	prices := map[string]float64{"VTI": 200, "VXUS": 50}
+	// 2.005 VTI are held, the 0.005 short of an increment are kept out of the rebalance
+	orders, cash := RebalanceWithSelling(0, map[string]float64{"VTI": 2.005, "VXUS": 0}, prices, allocation)
+	expected := map[string]float64{"VTI": -1, "VXUS": 4}
+	if !reflect.DeepEqual(orders, expected) {
+		t.Errorf("expected orders %v, got %v", expected, orders)
+	}
+	if math.Abs(cash) > 1e-9 {
+		t.Errorf("expected no cash left, got %v", cash)
+	}
+}
+
+func ptr(value float64) *float64 {
+	return &value
+}
+
+func TestConstraints(t *testing.T) {
+	prices := map[string]float64{"DFAC": 1, "DFIC": 1, "DFEM": 1}
+	tests := []struct {
+		allocation     targetAllocation.TargetAllocation
+		holdings       map[string]float64
+		expectedOrders map[string]float64
+	}{
+		{
+			// DFAC is kept, the rest is split 0.3 to 0.2
+			allocation: targetAllocation.TargetAllocation{
+				"DFAC": {Proportion: 0.5, NeverSell: true},
+				"DFIC": {Proportion: 0.3},
+				"DFEM": {Proportion: 0.2},
+			},
+			holdings:       map[string]float64{"DFAC": 80, "DFIC": 10, "DFEM": 10},
+			expectedOrders: map[string]float64{"DFIC": 2, "DFEM": -2},
+		},
+		{
+			allocation: targetAllocation.TargetAllocation{
+				"DFAC": {Proportion: 0.5, NeverBuy: true},
+				"DFIC": {Proportion: 0.3},
+				"DFEM": {Proportion: 0.2},
+			},
+			holdings:       map[string]float64{"DFAC": 10, "DFIC": 45, "DFEM": 45},
+			expectedOrders: map[string]float64{"DFIC": 9, "DFEM": -9},
+		},
+		{
+			allocation: targetAllocation.TargetAllocation{
+				"DFAC": {Proportion: 0.5, MaxCashValue: ptr(20)},
+				"DFIC": {Proportion: 0.3},
+				"DFEM": {Proportion: 0.2},
+			},
+			holdings:       map[string]float64{"DFAC": 50, "DFIC": 25, "DFEM": 25},
+			expectedOrders: map[string]float64{"DFAC": -30, "DFIC": 23, "DFEM": 7},
+		},
+	}
+	for i, test := range tests {
+		orders, cash := RebalanceWithSelling(0, test.holdings, prices, test.allocation)
+		if !reflect.DeepEqual(orders, test.expectedOrders) {
+			t.Errorf("expected orders %v, got %v, on test index %v", test.expectedOrders, orders, i)
+		}
+		if cash != 0 {
+			t.Errorf("expected no cash left, got %v, on test index %v", cash, i)
+		}
+	}
+}
+
+func TestMaxProportion(t *testing.T) {
+	prices := map[string]float64{"DFAC": 30, "DFIC": 1}
+	allocation := targetAllocation.TargetAllocation{
+		"DFAC": {Proportion: 0.5},
+		"DFIC": {Proportion: 0.5},
+	}
+	// two shares of DFAC, $60, are closest to the $50 target
+	purchases, _ := BalancePurchase(100, map[string]float64{}, prices, allocation)
+	if purchases["DFAC"] != 2 {
+		t.Errorf("expected 2 DFAC without a max proportion, got %v", purchases)
+	}
+	allocation["DFAC"] = targetAllocation.Allocation{Proportion: 0.5, MaxProportion: ptr(0.5)}
+	// DFIC is $10 over its target as the cash is $10 under its target of 0
+	purchases, cash := BalancePurchase(100, map[string]float64{}, prices, allocation)
+	expected := map[string]float64{"DFAC": 1, "DFIC": 60}
+	if !reflect.DeepEqual(purchases, expected) || cash != 10 {
+		t.Errorf("expected purchases %v and $10 left, got %v and %v", expected, purchases, cash)
+	}
+}
+
+func TestBandsMaxCashValue(t *testing.T) {
+	prices := map[string]float64{"DFAC": 1, "DFIC": 1}
+	allocation := targetAllocation.TargetAllocation{
+		"DFAC": {Proportion: 0.5, AbsoluteBand: 0.5, MaxCashValue: ptr(40)},
+		"DFIC": {Proportion: 0.5, AbsoluteBand: 0.5},
+	}
+	// both are in band, DFAC is above its cap
+	orders, cash, _ := RebalanceWithBands(0, map[string]float64{"DFAC": 50, "DFIC": 50}, prices, allocation, nil, false)
+	expected := map[string]float64{"DFAC": -10}
+	if !reflect.DeepEqual(orders, expected) || cash != 10 {
+		t.Errorf("expected orders %v and $10 left, got %v and %v", expected, orders, cash)
+	}
+}
+
+func TestRebalanceHouseholdClasses(t *testing.T) {
+	prices := map[string]float64{"DFAC": 10, "VTI": 20, "DFIC": 5}
+	global := targetAllocation.TargetAllocation{
+		"usEquity":      {Proportion: 0.5, Tickers: []string{"DFAC", "VTI"}},
+		"international": {Proportion: 0.5, Tickers: []string{"DFIC"}},
+	}
+	accounts := []AccountHoldings{
+		{Cash: 100, Holdings: map[string]float64{"DFAC": 10, "DFIC": 0}},
+		{Cash: 0, Holdings: map[string]float64{"VTI": 10, "DFIC": 20}},
+	}
+	choices := []map[string]string{
+		{"usEquity": "DFAC", "international": "DFIC"},
+		{"usEquity": "VTI", "international": "DFIC"},
+	}
+	// $300 of US equity is held across both accounts against a $250 target, the first account keeps its DFAC
+	// and puts its cash in DFIC, the second sells VTI down to about $150 for DFIC
+	orders, cash := RebalanceHouseholdClasses(accounts, prices, global, choices, nil)
+	expected := []map[string]float64{{"DFIC": 20}, {"VTI": -3, "DFIC": 11}}
+	if !reflect.DeepEqual(orders, expected) {
+		t.Errorf("expected orders %v, got %v", expected, orders)
+	}
+	if cash[0] != 0 || cash[1] != 5 {
+		t.Errorf("expected $5 left in the second account, got %v", cash)
+	}
+}
+
+func TestRebalanceWithBandsGainBudget(t *testing.T) {
+	allocation := targetAllocation.TargetAllocation{
+		"VTI":  {Proportion: 0.5, AbsoluteBand: 0.05},
+		"VXUS": {Proportion: 0.3, AbsoluteBand: 0.05},
+		"BND":  {Proportion: 0.2, AbsoluteBand: 0.05},
+	}
+	prices := map[string]float64{"VTI": 10, "VXUS": 10, "BND": 10}
+	holdings := map[string]float64{"VTI": 70, "VXUS": 28, "BND": 2}
+	unconstrained, _, _ := RebalanceWithBands(0, holdings, prices, allocation, nil, false)
+	// VTI gains 1 a share
+	gains := func(ticker string, shares float64) (float64, float64) {
+		if ticker == "VTI" {
+			return 0, shares
+		}
+		return 0, 0
+	}
+
+	tests := []struct {
+		budget         GainBudget
+		expectedOrders map[string]float64
+	}{
+		{GainBudget{MaxGain: math.Inf(1)}, unconstrained},
+		// VXUS is in its band and is not bought
+		{GainBudget{MaxGain: 10}, map[string]float64{"VTI": -10, "BND": 10}},
+	}
+	for i, test := range tests {
+		orders, _ := RebalanceWithBandsGainBudget(0, holdings, prices, allocation, nil, false, unconstrained, gains, test.budget)
+		if !reflect.DeepEqual(orders, test.expectedOrders) {
+			t.Errorf("expected orders %v, got %v, test index: %v", test.expectedOrders, orders, i)
+		}
+	}
+}
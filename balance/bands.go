@@ -0,0 +1,164 @@
+package balance
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+// drift of an asset from its target, BandValue is the band in dollars
+type Drift struct {
+	Ticker           Ticker
+	Value            float64
+	TargetValue      float64
+	Proportion       float64
+	TargetProportion float64
+	Band             float64
+	BandValue        float64
+	InBand           bool
+}
+
+// drift of every target and held ticker, sorted by ticker
+func MeasureDrift(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation) []Drift {
+	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(targetAllocation)), prices)
+	AssertValidHoldingPrices(holdings, prices)
+
+	totalValue := cash
+	for ticker, quantity := range holdings {
+		totalValue += quantity * prices[ticker]
+	}
+
+	tickers := slices.Collect(maps.Keys(targetAllocation))
+	for ticker := range holdings {
+		if _, ok := targetAllocation[ticker]; !ok {
+			tickers = append(tickers, ticker)
+		}
+	}
+	slices.Sort(tickers)
+
+	// fixed cash values come off the top, proportions share the rest
+	targetValues := make(map[Ticker]float64)
+	proportionValue := totalValue
+	for _, ticker := range tickers {
+		if fixed := targetAllocation[ticker].FixedCashValue; fixed != 0 {
+			targetValues[ticker] = math.Min(fixed, math.Max(proportionValue, 0))
+			proportionValue -= targetValues[ticker]
+		}
+	}
+
+	drifts := make([]Drift, 0, len(tickers))
+	for _, ticker := range tickers {
+		alloc, tracked := targetAllocation[ticker]
+		base := totalValue
+		if alloc.FixedCashValue == 0 {
+			base = proportionValue
+			targetValues[ticker] = alloc.Proportion * proportionValue
+		}
+		drift := Drift{
+			Ticker:      ticker,
+			Value:       holdings[ticker] * prices[ticker],
+			TargetValue: targetValues[ticker],
+		}
+		if base > 0 {
+			drift.Proportion = drift.Value / base
+			drift.TargetProportion = drift.TargetValue / base
+		}
+		drift.Band = alloc.Band(drift.TargetProportion)
+		drift.BandValue = drift.Band * base
+		drift.InBand = tracked && math.Abs(drift.Value-drift.TargetValue) <= drift.BandValue+1e-9
+		drifts = append(drifts, drift)
+	}
+	return drifts
+}
+
+// shares worth at most value, multiples of increment unless the ticker trades in dollars
+func sharesWorth(value float64, price float64, dollars bool, increment float64) float64 {
+	if value <= 0 {
+		return 0
+	}
+	if dollars {
+		return DollarAmount(value, 1) / price
+	}
+	return TruncateShares(value/price, increment)
+}
+
+// returns purchases and sales of the assets outside of their band, remaining cash and the drifts
+func RebalanceWithBands(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers, toBandEdge bool) (map[Ticker]float64, float64, []Drift) {
+	return rebalanceWithBands(cash, holdings, prices, targetAllocation, dollarTickers, toBandEdge, true)
+}
+
+// like RebalanceWithBands, only buying with the cash
+func bandPurchases(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers, toBandEdge bool) (map[Ticker]float64, float64) {
+	orders, cash, _ := rebalanceWithBands(cash, holdings, prices, targetAllocation, dollarTickers, toBandEdge, false)
+	return orders, cash
+}
+
+func rebalanceWithBands(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers, toBandEdge bool, sell bool) (map[Ticker]float64, float64, []Drift) {
+	drifts := MeasureDrift(cash, holdings, prices, targetAllocation)
+	c := allocationConstraints(targetAllocation, holdings, prices)
+	proportionValue := cash
+	for ticker, quantity := range holdings {
+		proportionValue += quantity * prices[ticker]
+	}
+	for _, d := range drifts {
+		if targetAllocation[d.Ticker].FixedCashValue != 0 {
+			proportionValue -= d.TargetValue
+		}
+	}
+	bounded := func(d Drift, value float64) float64 {
+		least, most := c.bounds(d.Ticker, proportionValue)
+		return math.Min(math.Max(value, least), most)
+	}
+	desired := func(d Drift) float64 {
+		if !toBandEdge {
+			return bounded(d, d.TargetValue)
+		}
+		if d.Value > d.TargetValue {
+			return bounded(d, d.TargetValue+d.BandValue)
+		}
+		return bounded(d, d.TargetValue-d.BandValue)
+	}
+
+	increments := AllocationIncrements(targetAllocation, prices)
+	orders := make(map[Ticker]float64)
+	purchases := make([]Drift, 0)
+	for _, d := range drifts {
+		if d.InBand && bounded(d, d.Value) == d.Value {
+			continue
+		}
+		if d.Value < desired(d) {
+			purchases = append(purchases, d)
+			continue
+		}
+		if !sell {
+			continue
+		}
+		held := holdings[d.Ticker]
+		if !dollarTickers[d.Ticker] {
+			held = increments.Truncate(d.Ticker, held)
+		}
+		shares := math.Min(held, sharesWorth(d.Value-desired(d), prices[d.Ticker], dollarTickers[d.Ticker], increments.Of(d.Ticker)))
+		if _, tracked := targetAllocation[d.Ticker]; !tracked {
+			shares = held
+		}
+		if shares > 0 {
+			orders[d.Ticker] = -shares
+			cash += shares * prices[d.Ticker]
+		}
+	}
+
+	slices.SortFunc(purchases, func(a, b Drift) int {
+		return cmp.Compare(a.Proportion-a.TargetProportion, b.Proportion-b.TargetProportion)
+	})
+	for _, d := range purchases {
+		shares := sharesWorth(math.Min(desired(d)-d.Value, cash), prices[d.Ticker], dollarTickers[d.Ticker], increments.Of(d.Ticker))
+		if shares > 0 {
+			orders[d.Ticker] = shares
+			cash -= shares * prices[d.Ticker]
+		}
+	}
+	return orders, cash, drifts
+}
@@ -0,0 +1,90 @@
+package balance
+
+import (
+	"math"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+// constraints of a target allocation with the shares held before trading, the zero value constrains nothing
+type constraints struct {
+	allocation targetAllocation.TargetAllocation
+	held       map[Ticker]float64
+	prices     map[Ticker]float64
+}
+
+func allocationConstraints(allocation targetAllocation.TargetAllocation, held map[Ticker]float64, prices map[Ticker]float64) constraints {
+	return constraints{allocation: allocation, held: held, prices: prices}
+}
+
+// least and most value the ticker may end up with, base is the value proportions are of
+func (c constraints) bounds(ticker Ticker, base float64) (float64, float64) {
+	alloc, ok := c.allocation[ticker]
+	if !ok {
+		return 0, math.Inf(1)
+	}
+	least, most := alloc.MinProportion*base, math.Inf(1)
+	if alloc.MaxProportion != nil {
+		most = *alloc.MaxProportion * base
+	}
+	if alloc.MaxCashValue != nil {
+		most = math.Min(most, *alloc.MaxCashValue)
+	}
+	held := c.held[ticker] * c.prices[ticker]
+	if alloc.NeverBuy {
+		most = math.Min(most, held)
+	}
+	if alloc.NeverSell {
+		least, most = math.Max(least, held), math.Max(most, held)
+	}
+	return math.Min(least, most), most
+}
+
+func (c constraints) neverSell(ticker Ticker) bool {
+	return c.allocation[ticker].NeverSell
+}
+
+// target values of the proportion targets scaled to add up to the total within their bounds
+func boundedTargets(proportionTargets map[Ticker]float64, total float64, bounds func(Ticker) (float64, float64)) map[Ticker]float64 {
+	targets := func(scale float64) (map[Ticker]float64, float64) {
+		result := make(map[Ticker]float64, len(proportionTargets))
+		sum := 0.0
+		for ticker, proportion := range proportionTargets {
+			least, most := bounds(ticker)
+			result[ticker] = math.Min(math.Max(proportion*scale, least), most)
+			sum += result[ticker]
+		}
+		return result, sum
+	}
+
+	result, _ := targets(total)
+	unbounded := true
+	for ticker, proportion := range proportionTargets {
+		if result[ticker] != proportion*total {
+			unbounded = false
+		}
+	}
+	if unbounded {
+		return result
+	}
+	// the least values alone reach the total
+	if least, sum := targets(0); sum >= total {
+		return least
+	}
+
+	// the sum grows with the scale, bisect for the scale at which it reaches the total
+	low, high := 0.0, math.Max(total, 1)
+	for _, sum := targets(high); sum < total && high < 1e15; _, sum = targets(high) {
+		low, high = high, high*2
+	}
+	for range 100 {
+		middle := (low + high) / 2
+		if _, sum := targets(middle); sum < total {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	result, _ = targets(high)
+	return result
+}
@@ -0,0 +1,139 @@
+package balance
+
+import (
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+// realized short and long term gains of selling the given number of shares of the ticker, negative for losses
+type GainFunc func(ticker Ticker, shares float64) (float64, float64)
+
+// limit on the net gains a rebalance may realize, MaxGain is math.Inf(1) for no limit
+type GainBudget struct {
+	MaxGain          float64
+	NoShortTermGains bool
+}
+
+func (b GainBudget) allows(shortTerm float64, longTerm float64) bool {
+	if b.NoShortTermGains && shortTerm > 1e-9 {
+		return false
+	}
+	return shortTerm+longTerm <= b.MaxGain+1e-9
+}
+
+// root of the summed squared differences between the proportions and their targets
+func TrackingError(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation) float64 {
+	sum := 0.0
+	for _, d := range MeasureDrift(cash, holdings, prices, targetAllocation) {
+		sum += (d.Proportion - d.TargetProportion) * (d.Proportion - d.TargetProportion)
+	}
+	return math.Sqrt(sum)
+}
+
+// sales of the unconstrained plan are split into this many steps
+const gainBudgetSteps = 20
+
+// returns the parts of the unconstrained sales within the gain budget, the purchases and remaining cash
+func RebalanceWithGainBudget(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers, unconstrained map[Ticker]float64, gains GainFunc, budget GainBudget) (map[Ticker]float64, float64) {
+	purchase := func(cash float64, holdings map[Ticker]float64) (map[Ticker]float64, float64) {
+		return BalancePurchaseDollars(cash, holdings, prices, targetAllocation, dollarTickers)
+	}
+	return rebalanceWithGainBudget(cash, holdings, prices, targetAllocation, dollarTickers, unconstrained, gains, budget, purchase)
+}
+
+// like RebalanceWithGainBudget for the unconstrained orders of RebalanceWithBands, investing the proceeds as it does
+func RebalanceWithBandsGainBudget(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers, toBandEdge bool, unconstrained map[Ticker]float64, gains GainFunc, budget GainBudget) (map[Ticker]float64, float64) {
+	purchase := func(cash float64, holdings map[Ticker]float64) (map[Ticker]float64, float64) {
+		return bandPurchases(cash, holdings, prices, targetAllocation, dollarTickers, toBandEdge)
+	}
+	return rebalanceWithGainBudget(cash, holdings, prices, targetAllocation, dollarTickers, unconstrained, gains, budget, purchase)
+}
+
+func rebalanceWithGainBudget(cash float64, holdings map[Ticker]float64, prices map[Ticker]float64, targetAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers, unconstrained map[Ticker]float64, gains GainFunc, budget GainBudget, purchase func(float64, map[Ticker]float64) (map[Ticker]float64, float64)) (map[Ticker]float64, float64) {
+	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(targetAllocation)), prices)
+	AssertValidHoldingPrices(holdings, prices)
+
+	maxSales := make(map[Ticker]float64)
+	for ticker, quantity := range unconstrained {
+		if quantity < 0 {
+			maxSales[ticker] = -quantity
+		}
+	}
+	tickers := slices.Sorted(maps.Keys(maxSales))
+
+	sold := make(map[Ticker]float64)
+	outcome := func() (map[Ticker]float64, float64, float64) {
+		remaining := maps.Clone(holdings)
+		proceeds := cash
+		for ticker, quantity := range sold {
+			remaining[ticker] -= quantity
+			proceeds += quantity * prices[ticker]
+		}
+		purchases, cashLeft := purchase(proceeds, remaining)
+		orders := make(map[Ticker]float64)
+		for ticker, quantity := range purchases {
+			if quantity > 0 {
+				orders[ticker] = quantity
+				remaining[ticker] += quantity
+			}
+		}
+		for ticker, quantity := range sold {
+			if quantity > 0 {
+				orders[ticker] -= quantity
+			}
+		}
+		return orders, cashLeft, TrackingError(cashLeft, remaining, prices, targetAllocation)
+	}
+	increments := AllocationIncrements(targetAllocation, prices)
+	step := func(ticker Ticker) float64 {
+		size := maxSales[ticker] / gainBudgetSteps
+		if !dollarTickers[ticker] {
+			increment := increments.Of(ticker)
+			size = math.Round(math.Max(1, math.Ceil(size/increment-1e-9))*increment*1e9) / 1e9
+		}
+		return math.Min(size, maxSales[ticker]-sold[ticker])
+	}
+
+	_, _, trackingError := outcome()
+	shortTerm, longTerm := 0.0, 0.0
+	for {
+		best := Ticker("")
+		bestScore, bestError, bestShortTerm, bestLongTerm := 0.0, 0.0, 0.0, 0.0
+		for _, ticker := range tickers {
+			quantity := step(ticker)
+			if quantity <= 1e-9 {
+				continue
+			}
+			shortBefore, longBefore := gains(ticker, sold[ticker])
+			shortAfter, longAfter := gains(ticker, sold[ticker]+quantity)
+			stepShort, stepLong := shortAfter-shortBefore, longAfter-longBefore
+			if !budget.allows(shortTerm+stepShort, longTerm+stepLong) {
+				continue
+			}
+			sold[ticker] += quantity
+			_, _, stepError := outcome()
+			sold[ticker] -= quantity
+			improvement := trackingError - stepError
+			if improvement <= 1e-12 {
+				continue
+			}
+			// losses count as a cent of gain so they are not preferred over larger improvements
+			score := improvement / math.Max(stepShort+stepLong, 0.01)
+			if score > bestScore {
+				best, bestScore, bestError, bestShortTerm, bestLongTerm = ticker, score, stepError, stepShort, stepLong
+			}
+		}
+		if best == "" {
+			break
+		}
+		sold[best] += step(best)
+		trackingError = bestError
+		shortTerm += bestShortTerm
+		longTerm += bestLongTerm
+	}
+	orders, cashLeft, _ := outcome()
+	return orders, cashLeft
+}
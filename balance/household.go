@@ -0,0 +1,168 @@
+package balance
+
+import (
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+// holdings and cash of a single account that takes part in a household rebalance
+type AccountHoldings struct {
+	Cash     float64
+	Holdings map[Ticker]float64
+}
+
+// value of the cash and the holdings that can be sold, shares short of an increment left out
+func (ah AccountHoldings) Value(prices map[Ticker]float64, increments Increments) float64 {
+	value := ah.Cash
+	for ticker, quantity := range ah.Holdings {
+		value += increments.Truncate(ticker, quantity) * prices[ticker]
+	}
+	return value
+}
+
+// splits a cross account target allocation into one target allocation per account
+func HouseholdTargets(accounts []AccountHoldings, prices map[Ticker]float64, globalAllocation targetAllocation.TargetAllocation) []targetAllocation.TargetAllocation {
+	AssertValidDesiredAllocationPrices(slices.Collect(maps.Keys(globalAllocation)), prices)
+	for _, acc := range accounts {
+		AssertValidHoldingPrices(acc.Holdings, prices)
+	}
+
+	tickers := slices.Sorted(maps.Keys(globalAllocation))
+	increments := AllocationIncrements(globalAllocation, prices)
+
+	accountValues := make([]float64, len(accounts))
+	totalValue := 0.0
+	for i, acc := range accounts {
+		accountValues[i] = acc.Value(prices, increments)
+		totalValue += accountValues[i]
+	}
+
+	held := make(map[Ticker]float64)
+	for _, acc := range accounts {
+		for ticker, quantity := range acc.Holdings {
+			held[ticker] += quantity
+		}
+	}
+	c := allocationConstraints(globalAllocation, held, prices)
+
+	// fixed cash values come off the top, proportions share the rest within their bounds
+	remaining := make(map[Ticker]float64)
+	proportionValue := totalValue
+	for _, ticker := range tickers {
+		if fixed := globalAllocation[ticker].FixedCashValue; fixed != 0 {
+			_, most := c.bounds(ticker, totalValue)
+			remaining[ticker] = math.Min(math.Min(fixed, most), proportionValue)
+			proportionValue -= remaining[ticker]
+		}
+	}
+	proportionTargets := make(map[Ticker]float64)
+	for _, ticker := range tickers {
+		if proportion := globalAllocation[ticker].Proportion; proportion != 0 {
+			proportionTargets[ticker] = proportion
+		}
+	}
+	bounds := func(ticker Ticker) (float64, float64) {
+		return c.bounds(ticker, proportionValue)
+	}
+	for ticker, target := range boundedTargets(proportionTargets, proportionValue, bounds) {
+		remaining[ticker] += target
+	}
+
+	free := slices.Clone(accountValues)
+	targetValues := make([]map[Ticker]float64, len(accounts))
+	for i, acc := range accounts {
+		targetValues[i] = make(map[Ticker]float64)
+		for _, ticker := range tickers {
+			keep := math.Min(increments.Truncate(ticker, acc.Holdings[ticker])*prices[ticker], remaining[ticker])
+			targetValues[i][ticker] = keep
+			remaining[ticker] -= keep
+			free[i] -= keep
+		}
+	}
+
+	// distribute what is still needed over the value each account has left
+	for i := range accounts {
+		for _, ticker := range tickers {
+			if free[i] <= 0 {
+				break
+			}
+			add := math.Min(free[i], remaining[ticker])
+			if add <= 0 {
+				continue
+			}
+			targetValues[i][ticker] += add
+			remaining[ticker] -= add
+			free[i] -= add
+		}
+	}
+
+	result := make([]targetAllocation.TargetAllocation, len(accounts))
+	for i := range accounts {
+		result[i] = make(targetAllocation.TargetAllocation)
+		for _, ticker := range tickers {
+			proportion := 0.0
+			if accountValues[i] > 0 {
+				proportion = targetValues[i][ticker] / accountValues[i]
+			}
+			result[i][ticker] = targetAllocation.Allocation{
+				Proportion:      proportion,
+				ShareIncrement:  globalAllocation[ticker].ShareIncrement,
+				DollarIncrement: globalAllocation[ticker].DollarIncrement,
+				NeverSell:       globalAllocation[ticker].NeverSell,
+				NeverBuy:        globalAllocation[ticker].NeverBuy,
+			}
+		}
+	}
+	return result
+}
+
+// returns purchases and sales to be made and remaining cash for every account
+func RebalanceHousehold(accounts []AccountHoldings, prices map[Ticker]float64, globalAllocation targetAllocation.TargetAllocation) ([]map[Ticker]float64, []float64) {
+	return RebalanceHouseholdDollars(accounts, prices, globalAllocation, nil)
+}
+
+// like RebalanceHousehold, with dollar tickers traded in fractional shares
+func RebalanceHouseholdDollars(accounts []AccountHoldings, prices map[Ticker]float64, globalAllocation targetAllocation.TargetAllocation, dollarTickers DollarTickers) ([]map[Ticker]float64, []float64) {
+	accountTargets := HouseholdTargets(accounts, prices, globalAllocation)
+	orders := make([]map[Ticker]float64, len(accounts))
+	cash := make([]float64, len(accounts))
+	for i, acc := range accounts {
+		orders[i], cash[i] = RebalanceWithSellingDollars(acc.Cash, acc.Holdings, prices, accountTargets[i], dollarTickers)
+	}
+	return orders, cash
+}
+
+// like RebalanceHouseholdDollars for a global allocation with asset classes
+func RebalanceHouseholdClasses(accounts []AccountHoldings, prices map[Ticker]float64, globalAllocation targetAllocation.TargetAllocation, choices []map[string]Ticker, dollarTickers DollarTickers) ([]map[Ticker]float64, []float64) {
+	flat := targetAllocation.Flatten(globalAllocation)
+	// classes are held in dollars, at a price of 1
+	classPrices := maps.Clone(prices)
+	classAccounts := make([]AccountHoldings, len(accounts))
+	for i, acc := range accounts {
+		holdings := maps.Clone(acc.Holdings)
+		for class, ticker := range choices[i] {
+			classPrices[class] = 1
+			holdings[class] = holdings[ticker] * prices[ticker]
+			delete(holdings, ticker)
+		}
+		classAccounts[i] = AccountHoldings{Cash: acc.Cash, Holdings: holdings}
+	}
+	classTargets := HouseholdTargets(classAccounts, classPrices, flat)
+
+	orders := make([]map[Ticker]float64, len(accounts))
+	cash := make([]float64, len(accounts))
+	for i, acc := range accounts {
+		accountTarget := make(targetAllocation.TargetAllocation)
+		for key, alloc := range classTargets[i] {
+			if ticker, ok := choices[i][key]; ok {
+				key = ticker
+			}
+			accountTarget[key] = alloc
+		}
+		orders[i], cash[i] = RebalanceWithSellingDollars(acc.Cash, acc.Holdings, prices, accountTarget, dollarTickers)
+	}
+	return orders, cash
+}
@@ -0,0 +1,135 @@
+package balance
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
+)
+
+// account taking part in asset location, MaxCash is math.Inf(1) without a limit
+type LocationAccount struct {
+	Type    targetAllocation.AccountType
+	Value   float64
+	MaxCash float64
+}
+
+// splits the global allocation into per account targets following the location preferences
+func LocateAssets(accounts []LocationAccount, globalAllocation targetAllocation.TargetAllocation) ([]targetAllocation.TargetAllocation, error) {
+	total, fixed := 0.0, 0.0
+	for _, account := range accounts {
+		total += account.Value
+	}
+	for _, allocation := range globalAllocation {
+		fixed += allocation.FixedCashValue
+	}
+	if fixed > total+1e-9 {
+		return nil, fmt.Errorf("fixed cash values of $%.2f exceed the $%.2f the accounts hold", fixed, total)
+	}
+
+	room := make([]float64, len(accounts))
+	cashRoom := make([]float64, len(accounts))
+	for i, account := range accounts {
+		room[i] = account.Value
+		cashRoom[i] = account.MaxCash
+	}
+	placed := make([]map[targetAllocation.Ticker]float64, len(accounts))
+	for i := range placed {
+		placed[i] = make(map[targetAllocation.Ticker]float64)
+	}
+	// places up to remaining dollars of the ticker in the accounts of the given types, any type when nil
+	place := func(ticker targetAllocation.Ticker, remaining float64, types []targetAllocation.AccountType, cash bool) float64 {
+		for i, account := range accounts {
+			if remaining <= 1e-9 {
+				break
+			}
+			if types != nil && !slices.Contains(types, account.Type) {
+				continue
+			}
+			amount := math.Min(remaining, room[i])
+			if cash {
+				amount = math.Min(amount, cashRoom[i])
+				cashRoom[i] -= amount
+			}
+			if amount <= 1e-9 {
+				continue
+			}
+			placed[i][ticker] += amount
+			room[i] -= amount
+			remaining -= amount
+		}
+		return remaining
+	}
+
+	tickers := slices.Sorted(maps.Keys(globalAllocation))
+	for _, ticker := range tickers {
+		allocation := globalAllocation[ticker]
+		if allocation.FixedCashValue <= 0 {
+			continue
+		}
+		remaining := allocation.FixedCashValue
+		for _, accountType := range allocation.Location {
+			remaining = place(ticker, remaining, []targetAllocation.AccountType{accountType}, true)
+		}
+		if remaining = place(ticker, remaining, nil, true); remaining > 1e-6 {
+			return nil, fmt.Errorf("fixed cash value of %v does not fit within the cash limits of the accounts, $%.2f left", ticker, remaining)
+		}
+	}
+
+	remaining := make(map[targetAllocation.Ticker]float64)
+	for _, ticker := range tickers {
+		if proportion := globalAllocation[ticker].Proportion; proportion > 0 {
+			remaining[ticker] = proportion * (total - fixed)
+		}
+	}
+	slices.SortStableFunc(tickers, func(a, b targetAllocation.Ticker) int {
+		return cmp.Compare(len(globalAllocation[a].Location), len(globalAllocation[b].Location))
+	})
+	for rank := 0; ; rank++ {
+		ranked := false
+		for _, ticker := range tickers {
+			location := globalAllocation[ticker].Location
+			if remaining[ticker] <= 1e-9 || rank >= len(location) {
+				continue
+			}
+			ranked = true
+			remaining[ticker] = place(ticker, remaining[ticker], location[rank:rank+1], false)
+		}
+		if !ranked {
+			break
+		}
+	}
+	for _, ticker := range tickers {
+		if remaining[ticker] > 1e-9 {
+			remaining[ticker] = place(ticker, remaining[ticker], nil, false)
+		}
+	}
+
+	located := make([]targetAllocation.TargetAllocation, len(accounts))
+	for i, account := range accounts {
+		located[i] = make(targetAllocation.TargetAllocation)
+		cash := 0.0
+		for ticker, amount := range placed[i] {
+			if globalAllocation[ticker].FixedCashValue > 0 {
+				cash += amount
+			}
+		}
+		invested := account.Value - cash
+		for ticker, amount := range placed[i] {
+			allocation := globalAllocation[ticker]
+			allocation.Location = nil
+			if allocation.FixedCashValue > 0 {
+				allocation.FixedCashValue = amount
+			} else if invested > 0 {
+				allocation.Proportion = amount / invested
+			} else {
+				allocation.Proportion = 0
+			}
+			located[i][ticker] = allocation
+		}
+	}
+	return located, nil
+}
@@ -0,0 +1,92 @@
+package balance
+
+import (
+	"math"
+	"slices"
+)
+
+// ticker bought in whole shares by the solver, offset is its value less its target value
+type solverItem struct {
+	ticker    Ticker
+	price     float64
+	offset    float64
+	maxShares float64
+}
+
+// whole share purchases minimizing the summed squared differences from the targets, by branch and bound
+func solvePurchases(items []solverItem, cash float64) []float64 {
+	n := len(items)
+	shares := make([]float64, n)
+	best := slices.Clone(shares)
+	bestCost := math.Inf(1)
+
+	var search func(k int, cash float64, cost float64)
+	search = func(k int, cash float64, cost float64) {
+		if k == n {
+			cost += cash * cash
+			if cost < bestCost-1e-9 {
+				bestCost = cost
+				copy(best, shares)
+			}
+			return
+		}
+		item := items[k]
+		maxShares := math.Min(math.Floor(cash/item.price+1e-9), item.maxShares)
+		// cost of buying x shares of the ticker and the relaxed optimum of the rest
+		bound := func(x float64) float64 {
+			deviation := item.offset + x*item.price
+			rest, _ := relaxedCost(items[k+1:], cash-x*item.price)
+			return cost + deviation*deviation + rest
+		}
+		try := func(x float64) bool {
+			if x < 0 || x > maxShares || bound(x) >= bestCost-1e-9 {
+				return false
+			}
+			shares[k] = x
+			deviation := item.offset + x*item.price
+			search(k+1, cash-x*item.price, cost+deviation*deviation)
+			shares[k] = 0
+			return true
+		}
+
+		_, dollars := relaxedCost(items[k:], cash)
+		center := math.Min(math.Floor(dollars[0]/item.price), maxShares)
+		// the bound is convex in x, so each direction stops at the first share count it prunes
+		for x := center; try(x); x-- {
+		}
+		for x := center + 1; try(x); x++ {
+		}
+	}
+	search(0, cash, 0)
+	return best
+}
+
+// minimum summed squared deviation when any dollar amount can be bought, and the dollars spent per item
+func relaxedCost(items []solverItem, cash float64) (float64, []float64) {
+	offsets := make([]float64, 0, len(items)+1)
+	for _, item := range items {
+		offsets = append(offsets, item.offset)
+	}
+	offsets = append(offsets, 0)
+	slices.Sort(offsets)
+
+	level := 0.0
+	sum := 0.0
+	for j, offset := range offsets {
+		sum += offset
+		level = (cash + sum) / float64(j+1)
+		if j+1 == len(offsets) || level <= offsets[j+1] {
+			break
+		}
+	}
+
+	dollars := make([]float64, len(items))
+	left := math.Max(0, level)
+	cost := left * left
+	for i, item := range items {
+		dollars[i] = math.Max(0, level-item.offset)
+		deviation := math.Max(item.offset, level)
+		cost += deviation * deviation
+	}
+	return cost, dollars
+}
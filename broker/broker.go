@@ -0,0 +1,31 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+type Account struct {
+	SecuritiesAccount trader.SecuritiesAccount
+	AccountHashValue  string
+}
+
+// access to accounts, quotes and orders of a brokerage, accounts are addressed by hash value
+type Broker interface {
+	ListAccounts() ([]Account, error)
+	GetAccount(accountHash string) (Account, error)
+	GetQuotes(tickers []string) (marketData.QuoteResponse, error)
+	// commission, fees, balance impact and validation result of the order without placing it
+	PreviewOrder(accountHash string, order trader.Order) (trader.PreviewOrder, error)
+	// returns the id of the placed order
+	PlaceOrder(accountHash string, order trader.Order) (int64, error)
+	GetOrder(accountHash string, orderID int64) (trader.Order, error)
+	ListOrders(accountHash string, from time.Time, to time.Time) ([]trader.Order, error)
+	// TRADE transactions of the account between from and to, the api allows at most a year per request
+	ListTransactions(accountHash string, from time.Time, to time.Time) ([]trader.Transaction, error)
+	CancelOrder(accountHash string, orderID int64) error
+	// cancels the order and places its replacement, returns the id of the replacement
+	ReplaceOrder(accountHash string, orderID int64, order trader.Order) (int64, error)
+}
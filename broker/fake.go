@@ -0,0 +1,650 @@
+package broker
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+// state of a FakeBroker, kept separate so it can be serialized
+type Ledger struct {
+	Accounts    []Account                 `json:"accounts"`
+	Orders      map[string][]trader.Order `json:"orders"`
+	NextOrderID int64                     `json:"nextOrderId"`
+	// trades of each account, one per filled leg
+	Transactions map[string][]trader.Transaction `json:"transactions,omitempty"`
+}
+
+// in memory broker filling orders at the prices of its quotes
+type FakeBroker struct {
+	mu     sync.Mutex
+	Ledger Ledger
+	Quotes marketData.QuoteResponse
+	Now    func() time.Time
+}
+
+func NewFakeBroker() *FakeBroker {
+	return &FakeBroker{
+		Ledger: Ledger{
+			Orders:       make(map[string][]trader.Order),
+			NextOrderID:  1,
+			Transactions: make(map[string][]trader.Transaction),
+		},
+		Quotes: make(marketData.QuoteResponse),
+		Now:    time.Now,
+	}
+}
+
+func (fb *FakeBroker) AddAccount(accountNumber string, accountHash string, cash float64, holdings map[string]float64) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	account := Account{
+		SecuritiesAccount: trader.SecuritiesAccount{AccountNumber: accountNumber},
+		AccountHashValue:  accountHash,
+	}
+	account.SecuritiesAccount.InitialBalances.CashBalance = cash
+	for _, ticker := range slices.Sorted(maps.Keys(holdings)) {
+		account.SecuritiesAccount.Positions = append(account.SecuritiesAccount.Positions, trader.Position{
+			LongQuantity: holdings[ticker],
+			Instrument:   trader.Instrument{Symbol: ticker, AssetType: cmp.Or(fb.Quotes[ticker].AssetMainType, "EQUITY")},
+		})
+	}
+	fb.Ledger.Accounts = append(fb.Ledger.Accounts, account)
+	fb.updateBalances(&fb.Ledger.Accounts[len(fb.Ledger.Accounts)-1])
+}
+
+// sets bid, ask, mark and last price of the ticker to price
+func (fb *FakeBroker) SetQuote(ticker string, price float64) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	fb.Quotes[ticker] = marketData.Instrument{
+		AssetMainType: "EQUITY",
+		Symbol:        ticker,
+		Quote: marketData.Quote{
+			AskPrice:  price,
+			BidPrice:  price,
+			LastPrice: price,
+			Mark:      price,
+		},
+	}
+	for i := range fb.Ledger.Accounts {
+		fb.updateBalances(&fb.Ledger.Accounts[i])
+	}
+	fb.fillWorkingOrders()
+}
+
+// quotes the ticker as a mutual fund trading at its nav, without bid and ask
+func (fb *FakeBroker) SetFundQuote(ticker string, nav float64) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	fb.Quotes[ticker] = marketData.Instrument{
+		AssetMainType: "MUTUAL_FUND",
+		Symbol:        ticker,
+		Quote: marketData.Quote{
+			LastPrice: nav,
+			Mark:      nav,
+			NAV:       nav,
+		},
+	}
+	for i := range fb.Ledger.Accounts {
+		fb.updateBalances(&fb.Ledger.Accounts[i])
+	}
+	fb.fillWorkingOrders()
+}
+
+// mutual funds trade at their nav, everything else at the last price
+func marketPrice(instrument marketData.Instrument) float64 {
+	if instrument.AssetMainType == "MUTUAL_FUND" && instrument.Quote.NAV > 0 {
+		return instrument.Quote.NAV
+	}
+	return instrument.Quote.LastPrice
+}
+
+// shares of the leg at the price, DOLLARS legs are quantified in dollars
+func legShares(leg trader.OrderLeg, price float64) float64 {
+	if leg.QuantityType == "DOLLARS" {
+		return leg.Quantity / price
+	}
+	return leg.Quantity
+}
+
+// replaces the quotes of the given tickers, e.g. with recorded api responses
+func (fb *FakeBroker) SetQuotes(quotes marketData.QuoteResponse) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	for ticker, quote := range quotes {
+		fb.Quotes[ticker] = quote
+	}
+	for i := range fb.Ledger.Accounts {
+		fb.updateBalances(&fb.Ledger.Accounts[i])
+	}
+	fb.fillWorkingOrders()
+}
+
+func (fb *FakeBroker) account(accountHash string) (*Account, error) {
+	for i := range fb.Ledger.Accounts {
+		if fb.Ledger.Accounts[i].AccountHashValue == accountHash {
+			return &fb.Ledger.Accounts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("account %v not found", accountHash)
+}
+
+func (fb *FakeBroker) updateBalances(account *Account) {
+	sa := &account.SecuritiesAccount
+	positions := make([]trader.Position, 0, len(sa.Positions))
+	value := sa.InitialBalances.CashBalance
+	for _, pos := range sa.Positions {
+		if pos.LongQuantity == 0 {
+			continue
+		}
+		pos.MarketValue = pos.LongQuantity * marketPrice(fb.Quotes[pos.Instrument.Symbol])
+		if pos.AveragePrice > 0 {
+			pos.LongOpenProfitLoss = pos.MarketValue - pos.LongQuantity*pos.AveragePrice
+		}
+		value += pos.MarketValue
+		positions = append(positions, pos)
+	}
+	sa.Positions = positions
+	sa.InitialBalances.CashAvailableForTrading = sa.InitialBalances.CashBalance
+	sa.InitialBalances.TotalCash = sa.InitialBalances.CashBalance
+	sa.InitialBalances.AccountValue = value
+	sa.InitialBalances.LiquidationValue = value
+}
+
+func copyAccount(account Account) Account {
+	account.SecuritiesAccount.Positions = slices.Clone(account.SecuritiesAccount.Positions)
+	return account
+}
+
+func (fb *FakeBroker) ListAccounts() ([]Account, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	accounts := make([]Account, 0, len(fb.Ledger.Accounts))
+	for _, account := range fb.Ledger.Accounts {
+		accounts = append(accounts, copyAccount(account))
+	}
+	return accounts, nil
+}
+
+func (fb *FakeBroker) GetAccount(accountHash string) (Account, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	account, err := fb.account(accountHash)
+	if err != nil {
+		return Account{}, err
+	}
+	return copyAccount(*account), nil
+}
+
+// unknown tickers are left out of the response, as the schwab api does
+func (fb *FakeBroker) GetQuotes(tickers []string) (marketData.QuoteResponse, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	quotes := make(marketData.QuoteResponse)
+	for _, ticker := range tickers {
+		if quote, ok := fb.Quotes[ticker]; ok {
+			quotes[ticker] = quote
+		}
+	}
+	return quotes, nil
+}
+
+func (fb *FakeBroker) PlaceOrder(accountHash string, order trader.Order) (int64, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	account, err := fb.account(accountHash)
+	if err != nil {
+		return 0, err
+	}
+	fb.execute(account, &order)
+	fb.Ledger.Orders[accountHash] = append(fb.Ledger.Orders[accountHash], order)
+	return order.OrderID, nil
+}
+
+func heldQuantity(account *Account, ticker string) float64 {
+	for _, pos := range account.SecuritiesAccount.Positions {
+		if pos.Instrument.Symbol == ticker {
+			return pos.LongQuantity
+		}
+	}
+	return 0
+}
+
+// creates the position if the account does not hold the instrument yet
+func position(account *Account, instrument trader.Instrument) *trader.Position {
+	for i := range account.SecuritiesAccount.Positions {
+		if account.SecuritiesAccount.Positions[i].Instrument.Symbol == instrument.Symbol {
+			return &account.SecuritiesAccount.Positions[i]
+		}
+	}
+	account.SecuritiesAccount.Positions = append(account.SecuritiesAccount.Positions, trader.Position{
+		Instrument: trader.Instrument{Symbol: instrument.Symbol, AssetType: cmp.Or(instrument.AssetType, "EQUITY")},
+	})
+	return &account.SecuritiesAccount.Positions[len(account.SecuritiesAccount.Positions)-1]
+}
+
+// reason the order can not be filled against the account, empty if it can
+func (fb *FakeBroker) rejectReason(account *Account, order *trader.Order) string {
+	if len(order.OrderLegCollection) == 0 {
+		return "orders need at least one leg"
+	}
+	switch order.OrderType {
+	case "MARKET":
+	case "LIMIT":
+		if len(order.OrderLegCollection) != 1 {
+			return "limit orders need exactly one leg"
+		}
+		if order.Price <= 0 {
+			return "limit orders need a price"
+		}
+	default:
+		return "unsupported order type " + order.OrderType
+	}
+	cash := account.SecuritiesAccount.InitialBalances.CashAvailableForTrading
+	for _, leg := range order.OrderLegCollection {
+		quote, ok := fb.Quotes[leg.Instrument.Symbol]
+		if !ok {
+			return "no quote for " + leg.Instrument.Symbol
+		}
+		price := marketPrice(quote)
+		if order.OrderType == "LIMIT" {
+			if leg.QuantityType == "DOLLARS" {
+				return "dollar quantities need a market order"
+			}
+			price = order.Price
+		}
+		shares := legShares(leg, price)
+		switch leg.Instruction {
+		case "SELL":
+			if heldQuantity(account, leg.Instrument.Symbol) < shares-1e-9 {
+				return "insufficient shares of " + leg.Instrument.Symbol
+			}
+			cash += shares * price
+		case "BUY":
+			if account.SecuritiesAccount.IsClosingOnlyRestricted {
+				return "account is restricted to closing transactions"
+			}
+			cash -= shares * price
+		default:
+			return "unsupported instruction " + leg.Instruction
+		}
+	}
+	if cash < -1e-9 {
+		return fmt.Sprintf("insufficient cash, short $%.2f", -cash)
+	}
+	return ""
+}
+
+// price the leg fills at, false if a limit order is not marketable yet
+func (fb *FakeBroker) fillPrice(order *trader.Order, leg trader.OrderLeg) (float64, bool) {
+	quote := fb.Quotes[leg.Instrument.Symbol].Quote
+	if order.OrderType != "LIMIT" {
+		return marketPrice(fb.Quotes[leg.Instrument.Symbol]), true
+	}
+	if leg.Instruction == "SELL" {
+		bid := cmp.Or(quote.BidPrice, quote.LastPrice)
+		return bid, bid >= order.Price
+	}
+	ask := cmp.Or(quote.AskPrice, quote.LastPrice)
+	return ask, ask <= order.Price
+}
+
+func (fb *FakeBroker) marketable(order *trader.Order) bool {
+	for _, leg := range order.OrderLegCollection {
+		if _, ok := fb.fillPrice(order, leg); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (fb *FakeBroker) assignID(order *trader.Order) {
+	if order.OrderID != 0 {
+		return
+	}
+	order.OrderID = fb.Ledger.NextOrderID
+	fb.Ledger.NextOrderID++
+	order.EnteredTime = fb.Now().Format(trader.TimeFormat)
+}
+
+// closes the order and cancels the child orders it would have triggered
+func (fb *FakeBroker) close(order *trader.Order, status string) {
+	order.Status = status
+	order.Cancelable = false
+	order.CloseTime = fb.Now().Format(trader.TimeFormat)
+	for i := range order.ChildOrderStrategies {
+		child := &order.ChildOrderStrategies[i]
+		fb.assignID(child)
+		if !slices.Contains(closedStatuses, child.Status) {
+			fb.close(child, "CANCELED")
+		}
+	}
+}
+
+var closedStatuses = []string{"FILLED", "CANCELED", "REJECTED", "EXPIRED", "REPLACED"}
+
+// child orders wait for their parent to fill
+func (fb *FakeBroker) await(order *trader.Order) {
+	for i := range order.ChildOrderStrategies {
+		child := &order.ChildOrderStrategies[i]
+		fb.assignID(child)
+		child.Status = "AWAITING_PARENT_ORDER"
+		child.Cancelable = true
+		fb.await(child)
+	}
+}
+
+func (fb *FakeBroker) execute(account *Account, order *trader.Order) {
+	fb.assignID(order)
+	order.OrderActivityCollection = nil
+
+	quantity := 0.0
+	for _, leg := range order.OrderLegCollection {
+		quantity += leg.Quantity
+	}
+	order.Quantity = quantity
+	order.RemainingQuantity = quantity
+
+	if reason := fb.rejectReason(account, order); reason != "" {
+		order.StatusDescription = reason
+		fb.close(order, "REJECTED")
+		return
+	}
+	if !fb.marketable(order) {
+		order.Status = "WORKING"
+		order.Cancelable = true
+		fb.await(order)
+		return
+	}
+	fb.fill(account, order)
+}
+
+func (fb *FakeBroker) fill(account *Account, order *trader.Order) {
+	now := fb.Now().Format(trader.TimeFormat)
+	activity := trader.OrderActivity{ActivityType: "EXECUTION", ExecutionType: "FILL", Quantity: order.Quantity}
+	for i := range order.OrderLegCollection {
+		leg := &order.OrderLegCollection[i]
+		leg.LegID = int64(i + 1)
+		price, _ := fb.fillPrice(order, *leg)
+		shares := legShares(*leg, price)
+		pos := position(account, leg.Instrument)
+		amount, effect := shares, "OPENING"
+		if leg.Instruction == "SELL" {
+			pos.LongQuantity -= shares
+			account.SecuritiesAccount.InitialBalances.CashBalance += shares * price
+			amount, effect = -shares, "CLOSING"
+		} else {
+			pos.AveragePrice = (pos.AveragePrice*pos.LongQuantity + shares*price) / (pos.LongQuantity + shares)
+			pos.LongQuantity += shares
+			account.SecuritiesAccount.InitialBalances.CashBalance -= shares * price
+		}
+		fb.Ledger.Transactions[account.AccountHashValue] = append(fb.Ledger.Transactions[account.AccountHashValue], trader.Transaction{
+			ActivityID:    int64(len(fb.Ledger.Transactions[account.AccountHashValue]) + 1),
+			Time:          now,
+			AccountNumber: account.SecuritiesAccount.AccountNumber,
+			Type:          "TRADE",
+			Status:        "VALID",
+			OrderID:       order.OrderID,
+			NetAmount:     -amount * price,
+			TransferItems: []trader.TransferItem{{
+				Instrument:     leg.Instrument,
+				Amount:         amount,
+				Cost:           -amount * price,
+				Price:          price,
+				PositionEffect: effect,
+			}},
+		})
+		// avoid float noise leaving tiny positions behind
+		pos.LongQuantity = math.Round(pos.LongQuantity*1e9) / 1e9
+		activity.ExecutionLegs = append(activity.ExecutionLegs, trader.ExecutionLeg{
+			LegID:    leg.LegID,
+			Price:    price,
+			Quantity: shares,
+			Time:     now,
+		})
+	}
+	order.Status = "FILLED"
+	order.Cancelable = false
+	order.FilledQuantity = order.Quantity
+	order.RemainingQuantity = 0
+	order.CloseTime = now
+	order.OrderActivityCollection = []trader.OrderActivity{activity}
+	fb.updateBalances(account)
+
+	for i := range order.ChildOrderStrategies {
+		child := &order.ChildOrderStrategies[i]
+		if child.Status != "CANCELED" {
+			fb.execute(account, child)
+		}
+	}
+}
+
+// fills working orders that became marketable after a quote change
+func (fb *FakeBroker) fillWorkingOrders() {
+	for i := range fb.Ledger.Accounts {
+		account := &fb.Ledger.Accounts[i]
+		orders := fb.Ledger.Orders[account.AccountHashValue]
+		for j := range orders {
+			fb.fillWorking(account, &orders[j])
+		}
+	}
+}
+
+func (fb *FakeBroker) fillWorking(account *Account, order *trader.Order) {
+	if order.Status == "WORKING" {
+		if reason := fb.rejectReason(account, order); reason != "" {
+			order.StatusDescription = reason
+			fb.close(order, "REJECTED")
+		} else if fb.marketable(order) {
+			fb.fill(account, order)
+		}
+		return
+	}
+	for i := range order.ChildOrderStrategies {
+		fb.fillWorking(account, &order.ChildOrderStrategies[i])
+	}
+}
+
+// SEC fee charged on the proceeds of sales
+var SecFeeRate = 0.0000278
+
+// runs the checks of PlaceOrder against a copy of the account
+func (fb *FakeBroker) PreviewOrder(accountHash string, order trader.Order) (trader.PreviewOrder, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	account, err := fb.account(accountHash)
+	if err != nil {
+		return trader.PreviewOrder{}, err
+	}
+	sim := copyAccount(*account)
+	preview := trader.PreviewOrder{
+		OrderStrategy: trader.OrderStrategy{
+			AccountNumber:     account.SecuritiesAccount.AccountNumber,
+			OrderType:         order.OrderType,
+			OrderStrategyType: order.OrderStrategyType,
+			Price:             order.Price,
+		},
+	}
+	fb.preview(&sim, order, &preview)
+
+	balance := &preview.OrderStrategy.OrderBalance
+	balance.ProjectedAvailableFund = sim.SecuritiesAccount.InitialBalances.CashAvailableForTrading
+	balance.ProjectedBuyingPower = sim.SecuritiesAccount.InitialBalances.CashAvailableForTrading
+	preview.OrderStrategy.Status = "ACCEPTED"
+	if len(preview.OrderValidationResult.Rejects) > 0 {
+		preview.OrderStrategy.Status = "REJECTED"
+	}
+	return preview, nil
+}
+
+func (fb *FakeBroker) preview(sim *Account, order trader.Order, preview *trader.PreviewOrder) {
+	if reason := fb.rejectReason(sim, &order); reason != "" {
+		preview.OrderValidationResult.Rejects = append(preview.OrderValidationResult.Rejects, trader.OrderValidationDetail{
+			ValidationRuleName: "fakeBroker",
+			Message:            reason,
+			OriginalSeverity:   "REJECT",
+		})
+		return
+	}
+
+	for i, leg := range order.OrderLegCollection {
+		quote := fb.Quotes[leg.Instrument.Symbol].Quote
+		price, ok := fb.fillPrice(&order, leg)
+		if !ok {
+			price = order.Price
+		}
+		shares := legShares(leg, price)
+		value := shares * price
+		pos := position(sim, leg.Instrument)
+		fees := trader.FeeLeg{}
+		if leg.Instruction == "SELL" {
+			pos.LongQuantity -= shares
+			sim.SecuritiesAccount.InitialBalances.CashBalance += value
+			if leg.Instrument.AssetType != "MUTUAL_FUND" {
+				fees.FeeValues = append(fees.FeeValues, trader.FeeValue{Value: math.Ceil(value*SecFeeRate*100) / 100, Type: "SEC_FEE"})
+			}
+			value = -value
+		} else {
+			pos.LongQuantity += shares
+			sim.SecuritiesAccount.InitialBalances.CashBalance -= value
+		}
+		preview.OrderStrategy.OrderBalance.OrderValue += value
+		preview.OrderStrategy.Quantity += leg.Quantity
+		preview.OrderStrategy.OrderLegs = append(preview.OrderStrategy.OrderLegs, trader.PreviewLeg{
+			AskPrice:    quote.AskPrice,
+			BidPrice:    quote.BidPrice,
+			LastPrice:   quote.LastPrice,
+			MarkPrice:   quote.Mark,
+			Quantity:    leg.Quantity,
+			FinalSymbol: leg.Instrument.Symbol,
+			LegID:       int64(i + 1),
+			AssetType:   leg.Instrument.AssetType,
+			Instruction: leg.Instruction,
+		})
+		preview.CommissionAndFee.Commission.CommissionLegs = append(preview.CommissionAndFee.Commission.CommissionLegs, trader.CommissionLeg{
+			CommissionValues: []trader.FeeValue{{Value: 0, Type: "COMMISSION"}},
+		})
+		preview.CommissionAndFee.Fee.FeeLegs = append(preview.CommissionAndFee.Fee.FeeLegs, fees)
+	}
+	fb.updateBalances(sim)
+
+	for _, child := range order.ChildOrderStrategies {
+		fb.preview(sim, child, preview)
+	}
+}
+
+// searches child orders as well
+func findOrder(orders []trader.Order, orderID int64) *trader.Order {
+	for i := range orders {
+		if orders[i].OrderID == orderID {
+			return &orders[i]
+		}
+		if child := findOrder(orders[i].ChildOrderStrategies, orderID); child != nil {
+			return child
+		}
+	}
+	return nil
+}
+
+func (fb *FakeBroker) GetOrder(accountHash string, orderID int64) (trader.Order, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	order := findOrder(fb.Ledger.Orders[accountHash], orderID)
+	if order == nil {
+		return trader.Order{}, fmt.Errorf("order %v not found", orderID)
+	}
+	return *order, nil
+}
+
+func (fb *FakeBroker) ListOrders(accountHash string, from time.Time, to time.Time) ([]trader.Order, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if _, err := fb.account(accountHash); err != nil {
+		return nil, err
+	}
+	orders := make([]trader.Order, 0)
+	for _, order := range fb.Ledger.Orders[accountHash] {
+		entered, err := time.Parse(trader.TimeFormat, order.EnteredTime)
+		if err != nil || entered.Before(from) || entered.After(to) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func (fb *FakeBroker) ListTransactions(accountHash string, from time.Time, to time.Time) ([]trader.Transaction, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if _, err := fb.account(accountHash); err != nil {
+		return nil, err
+	}
+	transactions := make([]trader.Transaction, 0)
+	for _, transaction := range fb.Ledger.Transactions[accountHash] {
+		traded, err := time.Parse(trader.TimeFormat, transaction.Time)
+		if err != nil || traded.Before(from) || traded.After(to) {
+			continue
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
+
+func (fb *FakeBroker) CancelOrder(accountHash string, orderID int64) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	order := findOrder(fb.Ledger.Orders[accountHash], orderID)
+	if order == nil {
+		return fmt.Errorf("order %v not found", orderID)
+	}
+	if !slices.Contains(openStatuses, order.Status) {
+		return fmt.Errorf("order %v is %v and can not be canceled", orderID, order.Status)
+	}
+	fb.close(order, "CANCELED")
+	return nil
+}
+
+var openStatuses = []string{"WORKING", "QUEUED", "PENDING_ACTIVATION", "AWAITING_PARENT_ORDER"}
+
+// the replaced order is closed as REPLACED and the replacement placed as a new order
+func (fb *FakeBroker) ReplaceOrder(accountHash string, orderID int64, order trader.Order) (int64, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	account, err := fb.account(accountHash)
+	if err != nil {
+		return 0, err
+	}
+	replaced := findOrder(fb.Ledger.Orders[accountHash], orderID)
+	if replaced == nil {
+		return 0, fmt.Errorf("order %v not found", orderID)
+	}
+	if !slices.Contains(openStatuses, replaced.Status) {
+		return 0, fmt.Errorf("order %v is %v and can not be replaced", orderID, replaced.Status)
+	}
+	fb.close(replaced, "REPLACED")
+	fb.execute(account, &order)
+	fb.Ledger.Orders[accountHash] = append(fb.Ledger.Orders[accountHash], order)
+	return order.OrderID, nil
+}
@@ -0,0 +1,261 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func leg(instruction string, ticker string, quantity float64) trader.OrderLeg {
+	return trader.OrderLeg{
+		Instruction: instruction,
+		Quantity:    quantity,
+		Instrument:  trader.Instrument{Symbol: ticker, AssetType: "EQUITY"},
+	}
+}
+
+func TestFakeBrokerPlaceOrder(t *testing.T) {
+	tests := []struct {
+		order          trader.Order
+		expectedStatus string
+		expectedCash   float64
+		expectedVTI    float64
+	}{
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("BUY", "VTI", 5)},
+			},
+			expectedStatus: "FILLED",
+			expectedCash:   500,
+			expectedVTI:    15,
+		},
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("BUY", "VTI", 11)},
+			},
+			expectedStatus: "REJECTED",
+			expectedCash:   1000,
+			expectedVTI:    10,
+		},
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("SELL", "VTI", 11)},
+			},
+			expectedStatus: "REJECTED",
+			expectedCash:   1000,
+			expectedVTI:    10,
+		},
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "TRIGGER",
+				OrderLegCollection: []trader.OrderLeg{leg("SELL", "VTI", 10)},
+				ChildOrderStrategies: []trader.Order{
+					{
+						OrderType:          "MARKET",
+						OrderStrategyType:  "SINGLE",
+						OrderLegCollection: []trader.OrderLeg{leg("BUY", "VXUS", 40)},
+					},
+				},
+			},
+			expectedStatus: "FILLED",
+			expectedCash:   0,
+			expectedVTI:    0,
+		},
+		// schwab rejects orders without legs
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{},
+			},
+			expectedStatus: "REJECTED",
+			expectedCash:   1000,
+			expectedVTI:    10,
+		},
+	}
+
+	for i, test := range tests {
+		fb := NewFakeBroker()
+		fb.SetQuote("VTI", 100)
+		fb.SetQuote("VXUS", 50)
+		fb.AddAccount("00000123", "hash", 1000, map[string]float64{"VTI": 10})
+
+		id, err := fb.PlaceOrder("hash", test.order)
+		if err != nil {
+			t.Fatalf("failed to place order on test index %v: %v", i, err)
+		}
+		order, err := fb.GetOrder("hash", id)
+		if err != nil {
+			t.Fatalf("failed to get order on test index %v: %v", i, err)
+		}
+		if order.Status != test.expectedStatus {
+			t.Errorf("expected status %v, got %v, on test index %v", test.expectedStatus, order.Status, i)
+		}
+		for _, child := range order.ChildOrderStrategies {
+			if child.Status != test.expectedStatus {
+				t.Errorf("expected child status %v, got %v, on test index %v", test.expectedStatus, child.Status, i)
+			}
+		}
+		account, _ := fb.GetAccount("hash")
+		if account.SecuritiesAccount.InitialBalances.CashBalance != test.expectedCash {
+			t.Errorf("expected cash %v, got %v, on test index %v", test.expectedCash, account.SecuritiesAccount.InitialBalances.CashBalance, i)
+		}
+		if vti := heldQuantity(&account, "VTI"); vti != test.expectedVTI {
+			t.Errorf("expected %v VTI, got %v, on test index %v", test.expectedVTI, vti, i)
+		}
+		if err := fb.CancelOrder("hash", id); err == nil {
+			t.Errorf("expected error canceling a closed order on test index %v", i)
+		}
+	}
+}
+
+func TestFakeBrokerLimitOrder(t *testing.T) {
+	fb := NewFakeBroker()
+	fb.SetQuote("VTI", 100)
+	fb.SetQuote("VXUS", 50)
+	fb.AddAccount("00000123", "hash", 1000, map[string]float64{"VTI": 10})
+
+	order := trader.Order{
+		OrderType:          "LIMIT",
+		Price:              110,
+		OrderStrategyType:  "TRIGGER",
+		OrderLegCollection: []trader.OrderLeg{leg("SELL", "VTI", 10)},
+		ChildOrderStrategies: []trader.Order{
+			{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("BUY", "VXUS", 20)},
+			},
+		},
+	}
+	id, err := fb.PlaceOrder("hash", order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed, _ := fb.GetOrder("hash", id)
+	if placed.Status != "WORKING" || placed.ChildOrderStrategies[0].Status != "AWAITING_PARENT_ORDER" {
+		t.Fatalf("expected a working order awaiting its child, got %v and %v", placed.Status, placed.ChildOrderStrategies[0].Status)
+	}
+
+	// replacing closes the working order and its child
+	replacementID, err := fb.ReplaceOrder("hash", id, trader.Order{
+		OrderType:          "LIMIT",
+		Price:              105,
+		OrderStrategyType:  "SINGLE",
+		OrderLegCollection: []trader.OrderLeg{leg("SELL", "VTI", 5)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced, _ := fb.GetOrder("hash", id)
+	if replaced.Status != "REPLACED" || replaced.ChildOrderStrategies[0].Status != "CANCELED" {
+		t.Errorf("expected replaced order with canceled child, got %v and %v", replaced.Status, replaced.ChildOrderStrategies[0].Status)
+	}
+
+	fb.SetQuote("VTI", 106)
+	filled, _ := fb.GetOrder("hash", replacementID)
+	if filled.Status != "FILLED" || filled.OrderActivityCollection[0].ExecutionLegs[0].Price != 106 {
+		t.Errorf("expected replacement filled at the bid of 106, got %+v", filled)
+	}
+	account, _ := fb.GetAccount("hash")
+	if account.SecuritiesAccount.InitialBalances.CashBalance != 1530 || heldQuantity(&account, "VTI") != 5 {
+		t.Errorf("expected cash 1530 and 5 VTI, got %v and %v", account.SecuritiesAccount.InitialBalances.CashBalance, heldQuantity(&account, "VTI"))
+	}
+	if _, err := fb.ReplaceOrder("hash", replacementID, order); err == nil {
+		t.Error("expected error replacing a filled order")
+	}
+}
+
+func TestFakeBrokerPreviewOrder(t *testing.T) {
+	tests := []struct {
+		order             trader.Order
+		closingOnly       bool
+		expectedRejected  bool
+		expectedValue     float64
+		expectedFees      float64
+		expectedAvailable float64
+	}{
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("BUY", "VTI", 5)},
+			},
+			expectedValue:     500,
+			expectedAvailable: 500,
+		},
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("BUY", "VTI", 11)},
+			},
+			expectedRejected:  true,
+			expectedAvailable: 1000,
+		},
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("BUY", "VTI", 1)},
+			},
+			closingOnly:       true,
+			expectedRejected:  true,
+			expectedAvailable: 1000,
+		},
+		{
+			order: trader.Order{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "TRIGGER",
+				OrderLegCollection: []trader.OrderLeg{leg("SELL", "VTI", 10)},
+				ChildOrderStrategies: []trader.Order{
+					{
+						OrderType:          "MARKET",
+						OrderStrategyType:  "SINGLE",
+						OrderLegCollection: []trader.OrderLeg{leg("BUY", "VXUS", 40)},
+					},
+				},
+			},
+			expectedValue:     1000,
+			expectedFees:      0.03,
+			expectedAvailable: 0,
+		},
+	}
+
+	for i, test := range tests {
+		fb := NewFakeBroker()
+		fb.SetQuote("VTI", 100)
+		fb.SetQuote("VXUS", 50)
+		fb.AddAccount("00000123", "hash", 1000, map[string]float64{"VTI": 10})
+		fb.Ledger.Accounts[0].SecuritiesAccount.IsClosingOnlyRestricted = test.closingOnly
+
+		preview, err := fb.PreviewOrder("hash", test.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rejected := len(preview.OrderValidationResult.Rejects) > 0
+		if rejected != test.expectedRejected {
+			t.Errorf("expected rejected %v, got %+v on test index %v", test.expectedRejected, preview.OrderValidationResult, i)
+		}
+		balance := preview.OrderStrategy.OrderBalance
+		if balance.OrderValue != test.expectedValue || balance.ProjectedAvailableFund != test.expectedAvailable {
+			t.Errorf("expected value %v and available funds %v, got %v and %v on test index %v", test.expectedValue, test.expectedAvailable, balance.OrderValue, balance.ProjectedAvailableFund, i)
+		}
+		if fees := preview.CommissionAndFee.Fee.Total(); fees != test.expectedFees {
+			t.Errorf("expected fees %v, got %v on test index %v", test.expectedFees, fees, i)
+		}
+
+		// previews leave the account untouched
+		account, _ := fb.GetAccount("hash")
+		if account.SecuritiesAccount.InitialBalances.CashBalance != 1000 || heldQuantity(&account, "VTI") != 10 {
+			t.Errorf("expected the account to be unchanged on test index %v", i)
+		}
+	}
+}
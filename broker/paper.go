@@ -0,0 +1,237 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+var PaperLedgerFile = "paperLedger.json"
+
+// broker trading virtual accounts against the quotes of the source broker, persisted to the ledger file
+type PaperBroker struct {
+	source     Broker
+	ledgerFile string
+	fake       *FakeBroker
+}
+
+func NewPaperBroker(source Broker, ledgerFile string) (*PaperBroker, error) {
+	pb := &PaperBroker{
+		source:     source,
+		ledgerFile: ledgerFile,
+		fake:       NewFakeBroker(),
+	}
+	data, err := os.ReadFile(ledgerFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return pb, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read paper ledger: " + err.Error())
+	}
+	if err := json.Unmarshal(data, &pb.fake.Ledger); err != nil {
+		return nil, errors.New("failed to parse paper ledger: " + err.Error())
+	}
+	if pb.fake.Ledger.Orders == nil {
+		pb.fake.Ledger.Orders = make(map[string][]trader.Order)
+	}
+	if pb.fake.Ledger.Transactions == nil {
+		pb.fake.Ledger.Transactions = make(map[string][]trader.Transaction)
+	}
+	return pb, nil
+}
+
+func (pb *PaperBroker) save() error {
+	data, err := json.MarshalIndent(pb.fake.Ledger, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pb.ledgerFile, data, 0600)
+}
+
+// copies the source accounts into the ledger the first time the ledger is used
+func (pb *PaperBroker) seed() error {
+	if len(pb.fake.Ledger.Accounts) > 0 {
+		return nil
+	}
+	accounts, err := pb.source.ListAccounts()
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		holdings := make(map[string]float64)
+		for _, pos := range account.SecuritiesAccount.Positions {
+			holdings[pos.Instrument.Symbol] += pos.LongQuantity
+		}
+		pb.fake.AddAccount(account.SecuritiesAccount.AccountNumber, account.AccountHashValue, account.SecuritiesAccount.InitialBalances.CashBalance, holdings)
+		seeded := &pb.fake.Ledger.Accounts[len(pb.fake.Ledger.Accounts)-1].SecuritiesAccount
+		seeded.Type = account.SecuritiesAccount.Type
+		// cost basis of the copied holdings, their lots are not in the ledger's history
+		for i := range seeded.Positions {
+			for _, pos := range account.SecuritiesAccount.Positions {
+				if pos.Instrument.Symbol == seeded.Positions[i].Instrument.Symbol {
+					seeded.Positions[i].AveragePrice = pos.AveragePrice
+				}
+			}
+		}
+	}
+	return pb.save()
+}
+
+// fetches current quotes for the tickers from the source broker
+func (pb *PaperBroker) refreshQuotes(tickers []string) error {
+	if len(tickers) == 0 {
+		return nil
+	}
+	quotes, err := pb.source.GetQuotes(tickers)
+	if err != nil {
+		return err
+	}
+	pb.fake.SetQuotes(quotes)
+	return nil
+}
+
+func heldTickers(accounts []Account) []string {
+	tickers := make([]string, 0)
+	for _, account := range accounts {
+		for _, pos := range account.SecuritiesAccount.Positions {
+			tickers = append(tickers, pos.Instrument.Symbol)
+		}
+	}
+	return tickers
+}
+
+func orderTickers(order trader.Order) []string {
+	tickers := make([]string, 0)
+	for _, leg := range order.OrderLegCollection {
+		tickers = append(tickers, leg.Instrument.Symbol)
+	}
+	for _, child := range order.ChildOrderStrategies {
+		tickers = append(tickers, orderTickers(child)...)
+	}
+	return tickers
+}
+
+// virtual accounts valued at current quotes
+func (pb *PaperBroker) ListAccounts() ([]Account, error) {
+	if err := pb.seed(); err != nil {
+		return nil, err
+	}
+	accounts, _ := pb.fake.ListAccounts()
+	if err := pb.refreshQuotes(heldTickers(accounts)); err != nil {
+		return nil, err
+	}
+	return pb.fake.ListAccounts()
+}
+
+func (pb *PaperBroker) GetAccount(accountHash string) (Account, error) {
+	if err := pb.seed(); err != nil {
+		return Account{}, err
+	}
+	account, err := pb.fake.GetAccount(accountHash)
+	if err != nil {
+		return Account{}, err
+	}
+	if err := pb.refreshQuotes(heldTickers([]Account{account})); err != nil {
+		return Account{}, err
+	}
+	return pb.fake.GetAccount(accountHash)
+}
+
+func (pb *PaperBroker) GetQuotes(tickers []string) (marketData.QuoteResponse, error) {
+	return pb.source.GetQuotes(tickers)
+}
+
+// working orders fill when a refreshed quote makes them marketable
+func (pb *PaperBroker) refreshWorkingOrders() error {
+	tickers := make([]string, 0)
+	var add func(orders []trader.Order)
+	add = func(orders []trader.Order) {
+		for _, order := range orders {
+			if order.Status == "WORKING" {
+				tickers = append(tickers, orderTickers(order)...)
+			}
+			add(order.ChildOrderStrategies)
+		}
+	}
+	pb.fake.mu.Lock()
+	for _, orders := range pb.fake.Ledger.Orders {
+		add(orders)
+	}
+	pb.fake.mu.Unlock()
+	if len(tickers) == 0 {
+		return nil
+	}
+	if err := pb.refreshQuotes(tickers); err != nil {
+		return err
+	}
+	return pb.save()
+}
+
+func (pb *PaperBroker) PreviewOrder(accountHash string, order trader.Order) (trader.PreviewOrder, error) {
+	if err := pb.seed(); err != nil {
+		return trader.PreviewOrder{}, err
+	}
+	if err := pb.refreshQuotes(orderTickers(order)); err != nil {
+		return trader.PreviewOrder{}, err
+	}
+	return pb.fake.PreviewOrder(accountHash, order)
+}
+
+// fills at the current quote of the source broker
+func (pb *PaperBroker) PlaceOrder(accountHash string, order trader.Order) (int64, error) {
+	if err := pb.seed(); err != nil {
+		return 0, err
+	}
+	if err := pb.refreshQuotes(orderTickers(order)); err != nil {
+		return 0, err
+	}
+	orderID, err := pb.fake.PlaceOrder(accountHash, order)
+	if err != nil {
+		return 0, err
+	}
+	return orderID, pb.save()
+}
+
+func (pb *PaperBroker) GetOrder(accountHash string, orderID int64) (trader.Order, error) {
+	if err := pb.refreshWorkingOrders(); err != nil {
+		return trader.Order{}, err
+	}
+	return pb.fake.GetOrder(accountHash, orderID)
+}
+
+func (pb *PaperBroker) ListOrders(accountHash string, from time.Time, to time.Time) ([]trader.Order, error) {
+	if err := pb.refreshWorkingOrders(); err != nil {
+		return nil, err
+	}
+	return pb.fake.ListOrders(accountHash, from, to)
+}
+
+// only trades of the paper ledger, holdings copied from the source have no history
+func (pb *PaperBroker) ListTransactions(accountHash string, from time.Time, to time.Time) ([]trader.Transaction, error) {
+	if err := pb.seed(); err != nil {
+		return nil, err
+	}
+	return pb.fake.ListTransactions(accountHash, from, to)
+}
+
+func (pb *PaperBroker) ReplaceOrder(accountHash string, orderID int64, order trader.Order) (int64, error) {
+	if err := pb.refreshQuotes(orderTickers(order)); err != nil {
+		return 0, err
+	}
+	newID, err := pb.fake.ReplaceOrder(accountHash, orderID, order)
+	if err != nil {
+		return 0, err
+	}
+	return newID, pb.save()
+}
+
+func (pb *PaperBroker) CancelOrder(accountHash string, orderID int64) error {
+	if err := pb.fake.CancelOrder(accountHash, orderID); err != nil {
+		return err
+	}
+	return pb.save()
+}
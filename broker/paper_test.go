@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func TestPaperBroker(t *testing.T) {
+	source := NewFakeBroker()
+	source.SetQuote("VTI", 100)
+	source.SetQuote("VXUS", 50)
+	source.AddAccount("00000123", "hash", 1000, map[string]float64{"VTI": 10})
+
+	ledgerFile := filepath.Join(t.TempDir(), "paperLedger.json")
+	pb, err := NewPaperBroker(source, ledgerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := pb.ListAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].SecuritiesAccount.InitialBalances.AccountValue != 2000 {
+		t.Fatalf("expected the source account valued at 2000, got %+v", accounts)
+	}
+
+	source.SetQuote("VXUS", 40)
+	order := trader.Order{
+		OrderType:          "MARKET",
+		OrderStrategyType:  "TRIGGER",
+		OrderLegCollection: []trader.OrderLeg{leg("SELL", "VTI", 2)},
+		ChildOrderStrategies: []trader.Order{
+			{
+				OrderType:          "MARKET",
+				OrderStrategyType:  "SINGLE",
+				OrderLegCollection: []trader.OrderLeg{leg("BUY", "VXUS", 30)},
+			},
+		},
+	}
+	id, err := pb.PlaceOrder("hash", order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceAccount, _ := source.GetAccount("hash")
+	if sourceAccount.SecuritiesAccount.InitialBalances.CashBalance != 1000 || heldQuantity(&sourceAccount, "VTI") != 10 {
+		t.Errorf("expected the source account to be untouched, got %+v", sourceAccount.SecuritiesAccount)
+	}
+
+	// a new paper broker reads the same ledger back
+	reloaded, err := NewPaperBroker(source, ledgerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := reloaded.GetAccount("hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cash := account.SecuritiesAccount.InitialBalances.CashBalance; cash != 0 {
+		t.Errorf("expected cash 0 after filling at the current quotes, got %v", cash)
+	}
+	if vti := heldQuantity(&account, "VTI"); vti != 8 {
+		t.Errorf("expected 8 VTI, got %v", vti)
+	}
+	if vxus := heldQuantity(&account, "VXUS"); vxus != 30 {
+		t.Errorf("expected 30 VXUS, got %v", vxus)
+	}
+	placed, err := reloaded.GetOrder("hash", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.Status != "FILLED" {
+		t.Errorf("expected status FILLED, got %v", placed.Status)
+	}
+}
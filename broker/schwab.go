@@ -0,0 +1,221 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/auth"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+	"golang.org/x/oauth2"
+)
+
+const SchwabTraderApiPath = "/trader/v1/"
+const SchwabMarketDataApiPath = "/marketdata/v1/"
+
+// ISO-8601 format expected by the trader api for entered time filters
+const SchwabTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// non-success response of the schwab api, Body holds the error message returned by the api
+type APIError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%v %v: %v %v", e.Method, e.Url, e.Status, e.Body)
+}
+
+type SchwabBroker struct {
+	client               *http.Client
+	traderApiAddress     string
+	marketDataApiAddress string
+}
+
+// baseUrl is the scheme and host of the api, e.g. https://api.schwabapi.com
+func NewSchwabBroker(client *http.Client, baseUrl string) *SchwabBroker {
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	return &SchwabBroker{
+		client:               client,
+		traderApiAddress:     baseUrl + SchwabTraderApiPath,
+		marketDataApiAddress: baseUrl + SchwabMarketDataApiPath,
+	}
+}
+
+func (sb *SchwabBroker) do(method string, addr string, body any, expectedStatus int) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, addr, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			if _, ok := ue.Err.(*oauth2.RetrieveError); ok {
+				return nil, auth.ErrUnauthorized
+			}
+		}
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, auth.ErrUnauthorized
+	}
+	if resp.StatusCode != expectedStatus {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{method, addr, resp.StatusCode, resp.Status, string(respBody)}
+	}
+	return resp, nil
+}
+
+func (sb *SchwabBroker) getJSON(addr string, v any) error {
+	resp, err := sb.do(http.MethodGet, addr, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (sb *SchwabBroker) ListAccounts() ([]Account, error) {
+	var accountNumbers trader.AccountNumbersResponse
+	err := sb.getJSON(sb.traderApiAddress+"accounts/accountNumbers", &accountNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Account
+	for _, acc := range accountNumbers {
+		account, err := sb.GetAccount(acc.HashValue)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, account)
+	}
+	return res, nil
+}
+
+func (sb *SchwabBroker) GetAccount(accountHash string) (Account, error) {
+	var securitiesAccount trader.AccountResponse
+	err := sb.getJSON(sb.traderApiAddress+"accounts/"+accountHash+"?fields=positions", &securitiesAccount)
+	if err != nil {
+		return Account{}, err
+	}
+	return Account{securitiesAccount.SecuritiesAccount, accountHash}, nil
+}
+
+func (sb *SchwabBroker) GetQuotes(tickers []string) (marketData.QuoteResponse, error) {
+	addr := fmt.Sprintf(sb.marketDataApiAddress+"quotes?symbols=%s", strings.Join(tickers, "%2C")) + "&fields=quote&indicative=false"
+	quoteResponse := make(marketData.QuoteResponse)
+	err := sb.getJSON(addr, &quoteResponse)
+	if err != nil {
+		return nil, err
+	}
+	return quoteResponse, nil
+}
+
+func (sb *SchwabBroker) PreviewOrder(accountHash string, order trader.Order) (trader.PreviewOrder, error) {
+	resp, err := sb.do(http.MethodPost, sb.traderApiAddress+fmt.Sprintf("accounts/%v/previewOrder", accountHash), order, http.StatusOK)
+	if err != nil {
+		return trader.PreviewOrder{}, err
+	}
+	defer resp.Body.Close()
+	var preview trader.PreviewOrder
+	err = json.NewDecoder(resp.Body).Decode(&preview)
+	return preview, err
+}
+
+// the order id is taken from the Location header of the response
+func (sb *SchwabBroker) PlaceOrder(accountHash string, order trader.Order) (int64, error) {
+	resp, err := sb.do(http.MethodPost, sb.traderApiAddress+fmt.Sprintf("accounts/%v/orders", accountHash), order, http.StatusCreated)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return OrderIDFromLocation(resp.Header.Get("Location"))
+}
+
+// Location is of the form .../accounts/{accountHash}/orders/{orderId}
+func OrderIDFromLocation(location string) (int64, error) {
+	if location == "" {
+		return 0, fmt.Errorf("order placed but no Location header returned")
+	}
+	id, err := strconv.ParseInt(path.Base(location), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("order placed but Location header %q has no order id", location)
+	}
+	return id, nil
+}
+
+func (sb *SchwabBroker) GetOrder(accountHash string, orderID int64) (trader.Order, error) {
+	var order trader.Order
+	err := sb.getJSON(sb.traderApiAddress+fmt.Sprintf("accounts/%v/orders/%v", accountHash, orderID), &order)
+	return order, err
+}
+
+func (sb *SchwabBroker) ListOrders(accountHash string, from time.Time, to time.Time) ([]trader.Order, error) {
+	query := url.Values{}
+	query.Set("fromEnteredTime", from.UTC().Format(SchwabTimeFormat))
+	query.Set("toEnteredTime", to.UTC().Format(SchwabTimeFormat))
+	var orders []trader.Order
+	err := sb.getJSON(sb.traderApiAddress+"accounts/"+accountHash+"/orders?"+query.Encode(), &orders)
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (sb *SchwabBroker) ListTransactions(accountHash string, from time.Time, to time.Time) ([]trader.Transaction, error) {
+	query := url.Values{}
+	query.Set("startDate", from.UTC().Format(SchwabTimeFormat))
+	query.Set("endDate", to.UTC().Format(SchwabTimeFormat))
+	query.Set("types", "TRADE")
+	var transactions []trader.Transaction
+	err := sb.getJSON(sb.traderApiAddress+"accounts/"+accountHash+"/transactions?"+query.Encode(), &transactions)
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func (sb *SchwabBroker) ReplaceOrder(accountHash string, orderID int64, order trader.Order) (int64, error) {
+	resp, err := sb.do(http.MethodPut, sb.traderApiAddress+fmt.Sprintf("accounts/%v/orders/%v", accountHash, orderID), order, http.StatusCreated)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return OrderIDFromLocation(resp.Header.Get("Location"))
+}
+
+func (sb *SchwabBroker) CancelOrder(accountHash string, orderID int64) error {
+	resp, err := sb.do(http.MethodDelete, sb.traderApiAddress+fmt.Sprintf("accounts/%v/orders/%v", accountHash, orderID), nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -3,26 +3,47 @@ package encryption
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"log"
+	"errors"
+	"fmt"
 	"os"
 )
 
 var EncryptedTokenFilename = "token.enc"
 
-func EncryptToFile(data []byte, filepath string) error {
+var ErrKeyNotSet = errors.New("SCHWAB_APP_AES_GCM_KEY not set")
+
+// file could not be decrypted, e.g. it was written with another key or is corrupt
+type DecryptionError struct {
+	Filepath string
+	Err      error
+}
+
+func (e *DecryptionError) Error() string {
+	return fmt.Sprintf("failed to decrypt %v: %v", e.Filepath, e.Err)
+}
+
+func (e *DecryptionError) Unwrap() error {
+	return e.Err
+}
+
+func newAESGCM() (cipher.AEAD, error) {
 	aesKey := os.Getenv("SCHWAB_APP_AES_GCM_KEY")
 	if aesKey == "" {
-		log.Fatal("SCHWAB_APP_AES_GCM_KEY not set")
+		return nil, ErrKeyNotSet
 	}
 
 	block, err := aes.NewCipher([]byte(aesKey))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	aesGCM, err := cipher.NewGCMWithRandomNonce(block)
+	return cipher.NewGCMWithRandomNonce(block)
+}
+
+func EncryptToFile(data []byte, filepath string) error {
+	aesGCM, err := newAESGCM()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	cipherText := aesGCM.Seal(nil, nil, data, nil)
@@ -30,19 +51,9 @@ func EncryptToFile(data []byte, filepath string) error {
 }
 
 func DecryptFromFile(filepath string) ([]byte, error) {
-	aesKey := os.Getenv("SCHWAB_APP_AES_GCM_KEY")
-	if aesKey == "" {
-		log.Fatal("SCHWAB_APP_AES_GCM_KEY not set")
-	}
-
-	block, err := aes.NewCipher([]byte(aesKey))
+	aesGCM, err := newAESGCM()
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	aesGCM, err := cipher.NewGCMWithRandomNonce(block)
-	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	cipherText, err := os.ReadFile(filepath)
@@ -52,7 +63,7 @@ func DecryptFromFile(filepath string) ([]byte, error) {
 
 	plainText, err := aesGCM.Open(nil, nil, cipherText, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, &DecryptionError{filepath, err}
 	}
 
 	return plainText, nil
@@ -1,6 +1,7 @@
 package encryption
 
 import (
+	"errors"
 	"os"
 	"testing"
 )
@@ -34,3 +35,26 @@ func TestEncryption(t *testing.T) {
 		}
 	}
 }
+
+func TestDecryptionFailure(t *testing.T) {
+	os.Setenv("SCHWAB_APP_AES_GCM_KEY", "12345678901234567890123456789012")
+	t.Cleanup(func() {
+		os.Unsetenv("SCHWAB_APP_AES_GCM_KEY")
+	})
+	filename := "testCorruptToken.enc"
+	if err := os.WriteFile(filename, []byte("not encrypted with the key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	_, err := DecryptFromFile(filename)
+	var decryptionErr *DecryptionError
+	if !errors.As(err, &decryptionErr) {
+		t.Fatalf("expected a DecryptionError, got %v", err)
+	}
+
+	os.Unsetenv("SCHWAB_APP_AES_GCM_KEY")
+	if _, err := DecryptFromFile(filename); !errors.Is(err, ErrKeyNotSet) {
+		t.Fatalf("expected ErrKeyNotSet, got %v", err)
+	}
+}
@@ -1,9 +1,19 @@
 package main
 
-import "github.com/josephwest2/schwab-portfolio-manager/app"
+import (
+	"os"
 
+	"github.com/josephwest2/schwab-portfolio-manager/app"
+)
 
 func main() {
 	app := app.NewApp()
+	args, err := app.ParseGlobalFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	if len(args) > 0 {
+		os.Exit(app.RunCommand(args))
+	}
 	app.Run()
 }
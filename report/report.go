@@ -0,0 +1,629 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type Format string
+
+const (
+	Table Format = "table"
+	JSON  Format = "json"
+	CSV   Format = "csv"
+)
+
+var Formats = []Format{Table, JSON, CSV}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q, expected one of %v", s, Formats)
+}
+
+// anything that can be written as a human readable table, a csv file or json
+type Report interface {
+	WriteTable(w io.Writer)
+	CSVHeader() []string
+	CSVRows() [][]string
+}
+
+func Write(w io.Writer, format Format, r Report) error {
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(r.CSVHeader()); err != nil {
+			return err
+		}
+		return cw.WriteAll(r.CSVRows())
+	case Table, "":
+		r.WriteTable(w)
+		return nil
+	}
+	return errors.New("unknown output format " + string(format))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type Position struct {
+	Ticker      string  `json:"ticker"`
+	Quantity    float64 `json:"quantity"`
+	MarketValue float64 `json:"marketValue"`
+	Proportion  float64 `json:"proportion"`
+	Tracked     bool    `json:"tracked"`
+}
+
+type Positions []Position
+
+func (ps Positions) WriteTable(w io.Writer) {
+	fmt.Fprintf(w, "Curent positions:\n")
+	for _, p := range ps {
+		fmt.Fprintf(w, "%v: %v shares, $%.2f, %.2f%%\n", p.Ticker, p.Quantity, p.MarketValue, p.Proportion*100)
+		if !p.Tracked {
+			fmt.Fprintf(w, "No desired allocation for %v, skipping inclusion in further calculations\n", p.Ticker)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func (ps Positions) CSVHeader() []string {
+	return []string{"ticker", "quantity", "marketValue", "proportion", "tracked"}
+}
+
+func (ps Positions) CSVRows() [][]string {
+	rows := make([][]string, 0, len(ps))
+	for _, p := range ps {
+		rows = append(rows, []string{p.Ticker, formatFloat(p.Quantity), formatFloat(p.MarketValue), formatFloat(p.Proportion), strconv.FormatBool(p.Tracked)})
+	}
+	return rows
+}
+
+type Account struct {
+	Index        int       `json:"index"`
+	Account      string    `json:"account"`
+	AccountValue float64   `json:"accountValue"`
+	Cash         float64   `json:"cash"`
+	Positions    Positions `json:"positions"`
+}
+
+type Accounts []Account
+
+func (as Accounts) WriteTable(w io.Writer) {
+	for _, a := range as {
+		fmt.Fprintf(w, "\n#%v ********%v\n", a.Index, a.Account)
+		fmt.Fprintf(w, "Account value: $%v\n", a.AccountValue)
+		fmt.Fprintf(w, "Cash: $%.2f\n\n", a.Cash)
+	}
+}
+
+// one row per position, accounts without positions get a single row with an empty ticker
+func (as Accounts) CSVHeader() []string {
+	return []string{"index", "account", "accountValue", "cash", "ticker", "quantity", "marketValue", "proportion", "tracked"}
+}
+
+func (as Accounts) CSVRows() [][]string {
+	rows := make([][]string, 0)
+	for _, a := range as {
+		prefix := []string{strconv.Itoa(a.Index), a.Account, formatFloat(a.AccountValue), formatFloat(a.Cash)}
+		if len(a.Positions) == 0 {
+			rows = append(rows, append(prefix, "", "", "", "", ""))
+		}
+		for _, row := range a.Positions.CSVRows() {
+			rows = append(rows, append(append([]string{}, prefix...), row...))
+		}
+	}
+	return rows
+}
+
+// target of an asset in an account placed by asset location, Value is the dollar amount of the target
+type LocatedTarget struct {
+	Ticker         string  `json:"ticker"`
+	Proportion     float64 `json:"proportion,omitempty"`
+	FixedCashValue float64 `json:"fixedCashValue,omitempty"`
+	Value          float64 `json:"value"`
+}
+
+type LocatedAccount struct {
+	Account      string          `json:"account"`
+	Type         string          `json:"type"`
+	AccountValue float64         `json:"accountValue"`
+	Targets      []LocatedTarget `json:"targets"`
+}
+
+type Locations []LocatedAccount
+
+func (ls Locations) WriteTable(w io.Writer) {
+	for _, l := range ls {
+		fmt.Fprintf(w, "********%v %v, $%.2f\n", l.Account, l.Type, l.AccountValue)
+		if len(l.Targets) == 0 {
+			fmt.Fprintln(w, "No assets placed")
+		}
+		for _, t := range l.Targets {
+			if t.FixedCashValue > 0 {
+				fmt.Fprintf(w, "%v: fixed $%.2f\n", t.Ticker, t.FixedCashValue)
+			} else {
+				fmt.Fprintf(w, "%v: %.2f%%, $%.2f\n", t.Ticker, t.Proportion*100, t.Value)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func (ls Locations) CSVHeader() []string {
+	return []string{"account", "type", "accountValue", "ticker", "proportion", "fixedCashValue", "value"}
+}
+
+func (ls Locations) CSVRows() [][]string {
+	rows := make([][]string, 0)
+	for _, l := range ls {
+		for _, t := range l.Targets {
+			rows = append(rows, []string{l.Account, l.Type, formatFloat(l.AccountValue), t.Ticker, formatFloat(t.Proportion), formatFloat(t.FixedCashValue), formatFloat(t.Value)})
+		}
+	}
+	return rows
+}
+
+// problem in the allocation file, Account and Ticker are the keys it is under
+type AllocationProblem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Account string `json:"account,omitempty"`
+	Ticker  string `json:"ticker,omitempty"`
+	Message string `json:"message"`
+}
+
+type AllocationProblems []AllocationProblem
+
+func (ps AllocationProblems) WriteTable(w io.Writer) {
+	if len(ps) == 0 {
+		fmt.Fprintln(w, "No problems found")
+	}
+	for _, p := range ps {
+		location := fmt.Sprintf("%v:%v:%v", p.File, p.Line, p.Column)
+		if keys := strings.TrimSpace(p.Account + " " + p.Ticker); keys != "" {
+			location += ": " + keys
+		}
+		fmt.Fprintf(w, "%v: %v\n", location, p.Message)
+	}
+}
+
+func (ps AllocationProblems) CSVHeader() []string {
+	return []string{"file", "line", "column", "account", "ticker", "message"}
+}
+
+func (ps AllocationProblems) CSVRows() [][]string {
+	rows := make([][]string, 0, len(ps))
+	for _, p := range ps {
+		rows = append(rows, []string{p.File, strconv.Itoa(p.Line), strconv.Itoa(p.Column), p.Account, p.Ticker, p.Message})
+	}
+	return rows
+}
+
+const (
+	Buy  = "BUY"
+	Sell = "SELL"
+)
+
+// Dollars is set for tickers that trade in dollar amounts, Quantity is then a fractional number of shares.
+// Lots are the estimated lots a sale relieves.
+type PlanOrder struct {
+	Ticker      string    `json:"ticker"`
+	Instruction string    `json:"instruction"`
+	Quantity    float64   `json:"quantity"`
+	Dollars     float64   `json:"dollars,omitempty"`
+	Lots        []SaleLot `json:"lots,omitempty"`
+}
+
+// Acquired is empty for lots bought before the transaction history, these are long term
+type SaleLot struct {
+	Acquired string  `json:"acquired,omitempty"`
+	Quantity float64 `json:"quantity"`
+	Cost     float64 `json:"cost"`
+	Gain     float64 `json:"gain"`
+	LongTerm bool    `json:"longTerm"`
+}
+
+func (l SaleLot) term() string {
+	if l.LongTerm {
+		return "long term"
+	}
+	return "short term"
+}
+
+// Purchase of Ticker within 30 days of selling SoldTicker at a loss, or with BoughtOn set, a sale of Ticker at a
+// loss within 30 days of buying BoughtTicker. Loss is 0 when the basis of the sale is unknown.
+type WashSale struct {
+	Ticker        string  `json:"ticker"`
+	SoldTicker    string  `json:"soldTicker"`
+	SoldAccount   string  `json:"soldAccount"`
+	SoldOn        string  `json:"soldOn"`
+	Loss          float64 `json:"loss,omitempty"`
+	BoughtTicker  string  `json:"boughtTicker,omitempty"`
+	BoughtAccount string  `json:"boughtAccount,omitempty"`
+	BoughtOn      string  `json:"boughtOn,omitempty"`
+	Blocked       bool    `json:"blocked"`
+}
+
+func (ws WashSale) WriteTable(w io.Writer) {
+	loss := "an unknown loss"
+	if ws.Loss > 0 {
+		loss = fmt.Sprintf("a loss of $%.2f", ws.Loss)
+	}
+	action := "flagged"
+	if ws.Blocked {
+		action = "blocked"
+	}
+	if ws.BoughtOn != "" {
+		fmt.Fprintf(w, "Wash sale %v: selling %v at %v within 30 days of buying %v in ********%v on %v\n", action, ws.Ticker, loss, ws.BoughtTicker, ws.BoughtAccount, ws.BoughtOn)
+		return
+	}
+	fmt.Fprintf(w, "Wash sale %v: buying %v within 30 days of selling %v at %v in ********%v on %v\n", action, ws.Ticker, ws.SoldTicker, loss, ws.SoldAccount, ws.SoldOn)
+}
+
+func (o PlanOrder) amount(sign float64) string {
+	if o.Dollars > 0 {
+		return fmt.Sprintf("$%.2f", o.Dollars)
+	}
+	return fmt.Sprintf("%v shares", sign*o.Quantity)
+}
+
+// drift of an asset from its target before the plan, Band is 0 for assets without a band
+type Drift struct {
+	Ticker           string  `json:"ticker"`
+	Value            float64 `json:"value"`
+	TargetValue      float64 `json:"targetValue"`
+	Proportion       float64 `json:"proportion"`
+	TargetProportion float64 `json:"targetProportion"`
+	Band             float64 `json:"band"`
+	InBand           bool    `json:"inBand"`
+}
+
+// plan within a gain budget compared with the plan without it, MaxGain is nil without a limit on the gains
+type GainBudget struct {
+	MaxGain                    *float64    `json:"maxGain,omitempty"`
+	NoShortTermGains           bool        `json:"noShortTermGains,omitempty"`
+	TrackingError              float64     `json:"trackingError"`
+	UnconstrainedTrackingError float64     `json:"unconstrainedTrackingError"`
+	UnconstrainedOrders        []PlanOrder `json:"unconstrainedOrders"`
+	UnconstrainedShortTermGain float64     `json:"unconstrainedShortTermGain"`
+	UnconstrainedLongTermGain  float64     `json:"unconstrainedLongTermGain"`
+}
+
+func (b *GainBudget) WriteTable(w io.Writer) {
+	limits := make([]string, 0)
+	if b.MaxGain != nil {
+		limits = append(limits, fmt.Sprintf("at most $%.2f of gains", *b.MaxGain))
+	}
+	if b.NoShortTermGains {
+		limits = append(limits, "no short term gains")
+	}
+	fmt.Fprintf(w, "Gain budget: %v\n", strings.Join(limits, ", "))
+	fmt.Fprintf(w, "Tracking error %.2f%% within the budget, %.2f%% without it\n", b.TrackingError*100, b.UnconstrainedTrackingError*100)
+	orders := make([]string, 0, len(b.UnconstrainedOrders))
+	for _, o := range b.UnconstrainedOrders {
+		sign := 1.0
+		if o.Instruction == Sell {
+			sign = -1
+		}
+		orders = append(orders, fmt.Sprintf("%v %v", o.Ticker, o.amount(sign)))
+	}
+	fmt.Fprintf(w, "Without the budget: %v, realizing $%.2f short term, $%.2f long term\n",
+		strings.Join(orders, ", "), b.UnconstrainedShortTermGain, b.UnconstrainedLongTermGain)
+}
+
+// LotMethod and the estimated gains are set when the plan sells
+type Plan struct {
+	Account       string      `json:"account"`
+	Drift         []Drift     `json:"drift,omitempty"`
+	Orders        []PlanOrder `json:"orders"`
+	ResultingCash float64     `json:"resultingCash"`
+	LotMethod     string      `json:"lotMethod,omitempty"`
+	ShortTermGain float64     `json:"shortTermGain,omitempty"`
+	LongTermGain  float64     `json:"longTermGain,omitempty"`
+	GainBudget    *GainBudget `json:"gainBudget,omitempty"`
+	WashSales     []WashSale  `json:"washSales,omitempty"`
+}
+
+type Plans []Plan
+
+func (ps Plans) WriteTable(w io.Writer) {
+	for _, p := range ps {
+		fmt.Fprintf(w, "********%v\n", p.Account)
+		if len(p.Drift) > 0 {
+			fmt.Fprintln(w, "Drift:")
+		}
+		for _, d := range p.Drift {
+			band := "out of band"
+			if d.InBand {
+				band = "in band"
+			}
+			fmt.Fprintf(w, "%v: %.2f%% (target %.2f%% ± %.2f%%), $%.2f from target, %v\n",
+				d.Ticker, d.Proportion*100, d.TargetProportion*100, d.Band*100, d.Value-d.TargetValue, band)
+		}
+		if len(p.Orders) == 0 {
+			fmt.Fprintln(w, "No orders needed")
+		}
+		sales := false
+		for _, o := range p.Orders {
+			if o.Instruction == Sell {
+				if !sales {
+					fmt.Fprintln(w, "Optimal sales:")
+					sales = true
+				}
+				fmt.Fprintf(w, "%v: %v\n", o.Ticker, o.amount(-1))
+				for _, l := range o.Lots {
+					acquired := "before history"
+					if l.Acquired != "" {
+						acquired = "on " + l.Acquired
+					}
+					fmt.Fprintf(w, "  %v shares acquired %v at $%.2f, gain $%.2f %v\n", l.Quantity, acquired, l.Cost, l.Gain, l.term())
+				}
+			}
+		}
+		if p.LotMethod != "" {
+			fmt.Fprintf(w, "Estimated realized gains (%v): $%.2f short term, $%.2f long term\n", p.LotMethod, p.ShortTermGain, p.LongTermGain)
+		}
+		purchases := false
+		for _, o := range p.Orders {
+			if o.Instruction == Buy {
+				if !purchases {
+					fmt.Fprintln(w, "Optimal purchases:")
+					purchases = true
+				}
+				fmt.Fprintf(w, "%v: %v\n", o.Ticker, o.amount(1))
+			}
+		}
+		for _, ws := range p.WashSales {
+			ws.WriteTable(w)
+		}
+		if p.GainBudget != nil {
+			p.GainBudget.WriteTable(w)
+		}
+		fmt.Fprintf(w, "Resulting cash: $%.2f\n\n", p.ResultingCash)
+	}
+}
+
+func (ps Plans) CSVHeader() []string {
+	return []string{"account", "ticker", "instruction", "quantity", "resultingCash"}
+}
+
+func (ps Plans) CSVRows() [][]string {
+	rows := make([][]string, 0)
+	for _, p := range ps {
+		for _, o := range p.Orders {
+			rows = append(rows, []string{p.Account, o.Ticker, o.Instruction, formatFloat(o.Quantity), formatFloat(p.ResultingCash)})
+		}
+	}
+	return rows
+}
+
+type OrderResult struct {
+	Account string `json:"account"`
+	OrderID int64  `json:"orderId"`
+}
+
+type OrderResults []OrderResult
+
+func (rs OrderResults) WriteTable(w io.Writer) {
+	for _, r := range rs {
+		fmt.Fprintf(w, "\n\n Order #%v placed for ********%v\n\n", r.OrderID, r.Account)
+	}
+}
+
+func (rs OrderResults) CSVHeader() []string {
+	return []string{"account", "orderId"}
+}
+
+func (rs OrderResults) CSVRows() [][]string {
+	rows := make([][]string, 0, len(rs))
+	for _, r := range rs {
+		rows = append(rows, []string{r.Account, strconv.FormatInt(r.OrderID, 10)})
+	}
+	return rows
+}
+
+type Quote struct {
+	Ticker string  `json:"ticker"`
+	Price  float64 `json:"price"`
+}
+
+type Quotes []Quote
+
+func (qs Quotes) WriteTable(w io.Writer) {
+	for _, q := range qs {
+		fmt.Fprintf(w, "%v: $%.2f\n", q.Ticker, q.Price)
+	}
+}
+
+func (qs Quotes) CSVHeader() []string {
+	return []string{"ticker", "price"}
+}
+
+func (qs Quotes) CSVRows() [][]string {
+	rows := make([][]string, 0, len(qs))
+	for _, q := range qs {
+		rows = append(rows, []string{q.Ticker, formatFloat(q.Price)})
+	}
+	return rows
+}
+
+// AveragePrice is the quantity weighted price of the executions of the leg.
+// Quantity is in dollars when QuantityType is DOLLARS, FilledQuantity always in shares.
+type OrderLeg struct {
+	Ticker         string  `json:"ticker"`
+	Instruction    string  `json:"instruction"`
+	Quantity       float64 `json:"quantity"`
+	QuantityType   string  `json:"quantityType,omitempty"`
+	FilledQuantity float64 `json:"filledQuantity"`
+	AveragePrice   float64 `json:"averagePrice,omitempty"`
+}
+
+type Order struct {
+	OrderID           int64      `json:"orderId"`
+	ParentID          int64      `json:"parentId,omitempty"`
+	EnteredTime       string     `json:"enteredTime"`
+	Strategy          string     `json:"strategy"`
+	OrderType         string     `json:"orderType"`
+	Status            string     `json:"status"`
+	StatusDescription string     `json:"statusDescription,omitempty"`
+	Legs              []OrderLeg `json:"legs"`
+}
+
+type Orders []Order
+
+func (orders Orders) WriteTable(w io.Writer) {
+	if len(orders) == 0 {
+		fmt.Fprintln(w, "No orders")
+	}
+	for _, o := range orders {
+		indent := ""
+		if o.ParentID != 0 {
+			indent = "  child "
+		}
+		fmt.Fprintf(w, "%v#%v %v %v %v %v\n", indent, o.OrderID, o.EnteredTime, o.Strategy, o.OrderType, o.Status)
+		if o.StatusDescription != "" {
+			fmt.Fprintf(w, "  %v\n", o.StatusDescription)
+		}
+		for _, leg := range o.Legs {
+			quantity := fmt.Sprint(leg.Quantity)
+			if leg.QuantityType == "DOLLARS" {
+				quantity = fmt.Sprintf("$%.2f of", leg.Quantity)
+			}
+			if leg.FilledQuantity > 0 {
+				fmt.Fprintf(w, "  %v %v %v, filled %v @ $%.2f\n", leg.Instruction, quantity, leg.Ticker, leg.FilledQuantity, leg.AveragePrice)
+			} else {
+				fmt.Fprintf(w, "  %v %v %v\n", leg.Instruction, quantity, leg.Ticker)
+			}
+		}
+	}
+}
+
+// one row per leg
+func (orders Orders) CSVHeader() []string {
+	return []string{"orderId", "parentId", "enteredTime", "strategy", "orderType", "status", "ticker", "instruction", "quantity", "filledQuantity", "averagePrice"}
+}
+
+func (orders Orders) CSVRows() [][]string {
+	rows := make([][]string, 0)
+	for _, o := range orders {
+		for _, leg := range o.Legs {
+			rows = append(rows, []string{
+				strconv.FormatInt(o.OrderID, 10), strconv.FormatInt(o.ParentID, 10), o.EnteredTime, o.Strategy, o.OrderType, o.Status,
+				leg.Ticker, leg.Instruction, formatFloat(leg.Quantity), formatFloat(leg.FilledQuantity), formatFloat(leg.AveragePrice),
+			})
+		}
+	}
+	return rows
+}
+
+// Broker preview of the orders of a plan, ProjectedAvailableFunds is the cash available for trading once they fill
+type Preview struct {
+	Account                 string   `json:"account"`
+	OrderValue              float64  `json:"orderValue"`
+	Commission              float64  `json:"commission"`
+	Fees                    float64  `json:"fees"`
+	ProjectedAvailableFunds float64  `json:"projectedAvailableFunds"`
+	ProjectedBuyingPower    float64  `json:"projectedBuyingPower"`
+	Rejections              []string `json:"rejections,omitempty"`
+	Warnings                []string `json:"warnings,omitempty"`
+}
+
+type Previews []Preview
+
+func (ps Previews) Rejected() bool {
+	for _, p := range ps {
+		if len(p.Rejections) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps Previews) WriteTable(w io.Writer) {
+	for _, p := range ps {
+		fmt.Fprintf(w, "Preview for ********%v\n", p.Account)
+		fmt.Fprintf(w, "Order value: $%.2f\n", p.OrderValue)
+		fmt.Fprintf(w, "Commission: $%.2f, fees: $%.2f\n", p.Commission, p.Fees)
+		fmt.Fprintf(w, "Projected available funds: $%.2f, buying power: $%.2f\n", p.ProjectedAvailableFunds, p.ProjectedBuyingPower)
+		for _, warning := range p.Warnings {
+			fmt.Fprintf(w, "Warning: %v\n", warning)
+		}
+		for _, rejection := range p.Rejections {
+			fmt.Fprintf(w, "Rejected: %v\n", rejection)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// rejections and warnings are joined with "; "
+func (ps Previews) CSVHeader() []string {
+	return []string{"account", "orderValue", "commission", "fees", "projectedAvailableFunds", "projectedBuyingPower", "rejections", "warnings"}
+}
+
+func (ps Previews) CSVRows() [][]string {
+	rows := make([][]string, 0, len(ps))
+	for _, p := range ps {
+		rows = append(rows, []string{
+			p.Account, formatFloat(p.OrderValue), formatFloat(p.Commission), formatFloat(p.Fees),
+			formatFloat(p.ProjectedAvailableFunds), formatFloat(p.ProjectedBuyingPower),
+			strings.Join(p.Rejections, "; "), strings.Join(p.Warnings, "; "),
+		})
+	}
+	return rows
+}
+
+// purchases waiting for sales to settle, Due once SettlesAt has passed
+type PendingPlan struct {
+	Account   string      `json:"account"`
+	SettlesAt string      `json:"settlesAt"`
+	Due       bool        `json:"due"`
+	Orders    []PlanOrder `json:"orders"`
+}
+
+type PendingPlans []PendingPlan
+
+func (ps PendingPlans) WriteTable(w io.Writer) {
+	if len(ps) == 0 {
+		fmt.Fprintln(w, "No pending purchases")
+	}
+	for _, p := range ps {
+		status := "waiting for settlement"
+		if p.Due {
+			status = "settled, ready to place"
+		}
+		fmt.Fprintf(w, "********%v settles %v, %v\n", p.Account, p.SettlesAt, status)
+		for _, o := range p.Orders {
+			fmt.Fprintf(w, "%v: %v shares\n", o.Ticker, o.Quantity)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func (ps PendingPlans) CSVHeader() []string {
+	return []string{"account", "settlesAt", "due", "ticker", "quantity"}
+}
+
+func (ps PendingPlans) CSVRows() [][]string {
+	rows := make([][]string, 0)
+	for _, p := range ps {
+		for _, o := range p.Orders {
+			rows = append(rows, []string{p.Account, p.SettlesAt, strconv.FormatBool(p.Due), o.Ticker, formatFloat(o.Quantity)})
+		}
+	}
+	return rows
+}
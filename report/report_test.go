@@ -0,0 +1,68 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	plans := Plans{
+		{
+			Account: "123",
+			Orders: []PlanOrder{
+				{Ticker: "DFEM", Instruction: Sell, Quantity: 3},
+				{Ticker: "DFAC", Instruction: Buy, Quantity: 10},
+			},
+			ResultingCash: 1.1,
+		},
+	}
+	tests := []struct {
+		format   Format
+		expected string
+	}{
+		{
+			format:   CSV,
+			expected: "account,ticker,instruction,quantity,resultingCash\n123,DFEM,SELL,3,1.1\n123,DFAC,BUY,10,1.1\n",
+		},
+		{
+			format: JSON,
+			expected: `[
+  {
+    "account": "123",
+    "orders": [
+      {
+        "ticker": "DFEM",
+        "instruction": "SELL",
+        "quantity": 3
+      },
+      {
+        "ticker": "DFAC",
+        "instruction": "BUY",
+        "quantity": 10
+      }
+    ],
+    "resultingCash": 1.1
+  }
+]
+`,
+		},
+		{
+			format:   Table,
+			expected: "********123\nOptimal sales:\nDFEM: -3 shares\nOptimal purchases:\nDFAC: 10 shares\nResulting cash: $1.10\n\n",
+		},
+	}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := Write(&buf, test.format, plans)
+		if err != nil {
+			t.Fatalf("failed to write %v: %v", test.format, err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("expected %q, got %q, on test index %v", test.expected, buf.String(), i)
+		}
+	}
+
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("expected error parsing unknown format")
+	}
+}
@@ -0,0 +1,377 @@
+package schwabMock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/broker"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/marketData"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+const AccountsFixtureFile = "accounts.json"
+const QuotesFixtureFile = "quotes.json"
+
+// account as written in the accounts fixture file
+type AccountFixture struct {
+	AccountNumber string             `json:"accountNumber"`
+	HashValue     string             `json:"hashValue"`
+	Cash          float64            `json:"cash"`
+	Positions     map[string]float64 `json:"positions"`
+}
+
+// Loads accounts.json and quotes.json from dir into a fake broker.
+// quotes.json has the shape of a marketdata/v1/quotes response so recorded responses can be used as is.
+func LoadFixtures(dir string) (*broker.FakeBroker, error) {
+	fb := broker.NewFakeBroker()
+
+	var quotes marketData.QuoteResponse
+	if err := readJSON(filepath.Join(dir, QuotesFixtureFile), &quotes); err != nil {
+		return nil, err
+	}
+	fb.SetQuotes(quotes)
+
+	var accounts []AccountFixture
+	if err := readJSON(filepath.Join(dir, AccountsFixtureFile), &accounts); err != nil {
+		return nil, err
+	}
+	for _, acc := range accounts {
+		fb.AddAccount(acc.AccountNumber, acc.HashValue, acc.Cash, acc.Positions)
+	}
+	return fb, nil
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.New("failed to read fixture file: " + err.Error())
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse fixture file %v: %v", path, err)
+	}
+	return nil
+}
+
+// Stand-in for the schwab oauth, trader and market data apis, backed by a broker.
+// Any client id and secret are accepted, but api calls need a token issued by the server.
+type Server struct {
+	Broker broker.Broker
+
+	mux           *http.ServeMux
+	mu            sync.Mutex
+	codes         map[string]bool
+	accessTokens  map[string]bool
+	refreshTokens map[string]bool
+	nextToken     int
+}
+
+func NewServer(b broker.Broker) *Server {
+	s := &Server{
+		Broker:        b,
+		mux:           http.NewServeMux(),
+		codes:         make(map[string]bool),
+		accessTokens:  make(map[string]bool),
+		refreshTokens: make(map[string]bool),
+	}
+	mux := s.mux
+	mux.HandleFunc("GET /v1/oauth/authorize", s.authorize)
+	mux.HandleFunc("POST /v1/oauth/token", s.token)
+	mux.HandleFunc("GET /trader/v1/accounts/accountNumbers", s.authorized(s.accountNumbers))
+	mux.HandleFunc("GET /trader/v1/accounts/{hash}", s.authorized(s.account))
+	mux.HandleFunc("GET /trader/v1/accounts/{hash}/orders", s.authorized(s.listOrders))
+	mux.HandleFunc("POST /trader/v1/accounts/{hash}/orders", s.authorized(s.placeOrder))
+	mux.HandleFunc("POST /trader/v1/accounts/{hash}/previewOrder", s.authorized(s.previewOrder))
+	mux.HandleFunc("GET /trader/v1/accounts/{hash}/orders/{id}", s.authorized(s.getOrder))
+	mux.HandleFunc("PUT /trader/v1/accounts/{hash}/orders/{id}", s.authorized(s.replaceOrder))
+	mux.HandleFunc("DELETE /trader/v1/accounts/{hash}/orders/{id}", s.authorized(s.cancelOrder))
+	mux.HandleFunc("GET /trader/v1/accounts/{hash}/transactions", s.authorized(s.listTransactions))
+	mux.HandleFunc("GET /marketdata/v1/quotes", s.authorized(s.quotes))
+	return s
+}
+
+// starts the stand-in on a random local port, its URL is the api base url
+func StartTestServer(b broker.Broker) *httptest.Server {
+	return httptest.NewServer(NewServer(b))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"message": message,
+		"errors":  []string{message},
+	})
+}
+
+func (s *Server) newToken(kind string) string {
+	s.nextToken++
+	return fmt.Sprintf("mock-%v-%v", kind, s.nextToken)
+}
+
+// redirects straight back to the redirect uri with a code, as if the user logged in
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	redirectUri := r.URL.Query().Get("redirect_uri")
+	if redirectUri == "" || r.URL.Query().Get("client_id") == "" {
+		writeError(w, http.StatusBadRequest, "client_id and redirect_uri are required")
+		return
+	}
+	redirect, err := url.Parse(redirectUri)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	s.mu.Lock()
+	code := s.newToken("code")
+	s.codes[code] = true
+	s.mu.Unlock()
+
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("session", "mock-session")
+	if state := r.URL.Query().Get("state"); state != "" {
+		query.Set("state", state)
+	}
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := r.BasicAuth(); !ok {
+		writeError(w, http.StatusUnauthorized, "client credentials must be sent as basic auth")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		if !s.codes[code] {
+			writeError(w, http.StatusBadRequest, "invalid authorization code")
+			return
+		}
+		delete(s.codes, code)
+	case "refresh_token":
+		if !s.refreshTokens[r.PostForm.Get("refresh_token")] {
+			writeError(w, http.StatusBadRequest, "invalid refresh token")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported grant_type")
+		return
+	}
+
+	accessToken := s.newToken("access")
+	refreshToken := s.newToken("refresh")
+	s.accessTokens[accessToken] = true
+	s.refreshTokens[refreshToken] = true
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    1800,
+		"scope":         "api",
+	})
+}
+
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		valid := ok && s.accessTokens[token]
+		s.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "invalid or missing access token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) accountNumbers(w http.ResponseWriter, r *http.Request) {
+	accounts, err := s.Broker.ListAccounts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := make(trader.AccountNumbersResponse, 0, len(accounts))
+	for _, acc := range accounts {
+		response = append(response, trader.AccountNumbers{AccountNumber: acc.SecuritiesAccount.AccountNumber, HashValue: acc.AccountHashValue})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) account(w http.ResponseWriter, r *http.Request) {
+	account, err := s.Broker.GetAccount(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !strings.Contains(r.URL.Query().Get("fields"), "positions") {
+		account.SecuritiesAccount.Positions = nil
+	}
+	writeJSON(w, http.StatusOK, trader.AccountResponse{SecuritiesAccount: account.SecuritiesAccount})
+}
+
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
+	from, errFrom := time.Parse(broker.SchwabTimeFormat, r.URL.Query().Get("fromEnteredTime"))
+	to, errTo := time.Parse(broker.SchwabTimeFormat, r.URL.Query().Get("toEnteredTime"))
+	if errFrom != nil || errTo != nil {
+		writeError(w, http.StatusBadRequest, "fromEnteredTime and toEnteredTime are required in ISO-8601 format")
+		return
+	}
+	orders, err := s.Broker.ListOrders(r.PathValue("hash"), from, to)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// only TRADE transactions are recorded, other types give an empty list
+func (s *Server) listTransactions(w http.ResponseWriter, r *http.Request) {
+	from, errFrom := time.Parse(broker.SchwabTimeFormat, r.URL.Query().Get("startDate"))
+	to, errTo := time.Parse(broker.SchwabTimeFormat, r.URL.Query().Get("endDate"))
+	if errFrom != nil || errTo != nil {
+		writeError(w, http.StatusBadRequest, "startDate and endDate are required in ISO-8601 format")
+		return
+	}
+	if r.URL.Query().Get("types") == "" {
+		writeError(w, http.StatusBadRequest, "types is required")
+		return
+	}
+	transactions, err := s.Broker.ListTransactions(r.PathValue("hash"), from, to)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !slices.Contains(strings.Split(r.URL.Query().Get("types"), ","), "TRADE") {
+		transactions = []trader.Transaction{}
+	}
+	writeJSON(w, http.StatusOK, transactions)
+}
+
+func (s *Server) placeOrder(w http.ResponseWriter, r *http.Request) {
+	var order trader.Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order: "+err.Error())
+		return
+	}
+	orderID, err := s.Broker.PlaceOrder(r.PathValue("hash"), order)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%v/%v", strings.TrimSuffix(requestUrl(r), "/"), orderID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) previewOrder(w http.ResponseWriter, r *http.Request) {
+	var order trader.Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order: "+err.Error())
+		return
+	}
+	preview, err := s.Broker.PreviewOrder(r.PathValue("hash"), order)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
+
+func requestUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+func orderID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(r.PathValue("id"), 10, 64)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := orderID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	order, err := s.Broker.GetOrder(r.PathValue("hash"), id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// like placing an order, Location points at the replacement
+func (s *Server) replaceOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := orderID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	var order trader.Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order: "+err.Error())
+		return
+	}
+	newID, err := s.Broker.ReplaceOrder(r.PathValue("hash"), id, order)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ordersUrl := strings.TrimSuffix(requestUrl(r), "/"+r.PathValue("id"))
+	w.Header().Set("Location", fmt.Sprintf("%v/%v", ordersUrl, newID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := orderID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+	if err := s.Broker.CancelOrder(r.PathValue("hash"), id); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) quotes(w http.ResponseWriter, r *http.Request) {
+	symbols := strings.Split(r.URL.Query().Get("symbols"), ",")
+	quotes, err := s.Broker.GetQuotes(symbols)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, quotes)
+}
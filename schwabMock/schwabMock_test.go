@@ -0,0 +1,138 @@
+package schwabMock
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/auth"
+	"github.com/josephwest2/schwab-portfolio-manager/broker"
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+	"golang.org/x/oauth2"
+)
+
+// runs the oauth flow against the stand-in and returns a broker using the issued token
+func authenticatedBroker(t *testing.T, baseUrl string) *broker.SchwabBroker {
+	config := &oauth2.Config{
+		RedirectURL:  "https://127.0.0.1:8182/oauth2/callback",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint:     auth.SchwabEndpoint(baseUrl),
+	}
+
+	noRedirect := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := noRedirect.Get(config.AuthCodeURL(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect from authorize, got %v", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Host != "127.0.0.1:8182" || location.Path != "/oauth2/callback" {
+		t.Fatalf("expected redirect to the callback, got %v", location)
+	}
+
+	token, err := config.Exchange(context.Background(), location.Query().Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker.NewSchwabBroker(config.Client(context.Background(), token), baseUrl)
+}
+
+func TestServer(t *testing.T) {
+	fb, err := LoadFixtures("fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := StartTestServer(fb)
+	defer server.Close()
+
+	unauthenticated := broker.NewSchwabBroker(http.DefaultClient, server.URL)
+	if _, err := unauthenticated.ListAccounts(); err != auth.ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized without a token, got %v", err)
+	}
+
+	sb := authenticatedBroker(t, server.URL)
+
+	accounts, err := sb.ListAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || accounts[0].AccountHashValue != "MOCKHASH123" || len(accounts[0].SecuritiesAccount.Positions) != 4 {
+		t.Errorf("unexpected accounts %+v", accounts)
+	}
+
+	quotes, err := sb.GetQuotes([]string{"DFAC", "SWVXX", "UNKNOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotes) != 2 || quotes["DFAC"].Quote.LastPrice != 34.01 || quotes["SWVXX"].AssetMainType != "MUTUAL_FUND" {
+		t.Errorf("unexpected quotes %+v", quotes)
+	}
+
+	preview, err := sb.PreviewOrder("MOCKHASH456", trader.Order{
+		OrderType:         "MARKET",
+		OrderStrategyType: "SINGLE",
+		OrderLegCollection: []trader.OrderLeg{
+			{Instruction: "BUY", Quantity: 1000, Instrument: trader.Instrument{Symbol: "VTI", AssetType: "EQUITY"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.OrderValidationResult.Rejects) == 0 {
+		t.Errorf("expected the preview to reject an order exceeding the cash, got %+v", preview)
+	}
+
+	orderID, err := sb.PlaceOrder("MOCKHASH456", trader.Order{
+		OrderType:         "MARKET",
+		Session:           "NORMAL",
+		Duration:          "DAY",
+		OrderStrategyType: "SINGLE",
+		OrderLegCollection: []trader.OrderLeg{
+			{Instruction: "BUY", Quantity: 10, Instrument: trader.Instrument{Symbol: "VTI", AssetType: "EQUITY"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := sb.GetOrder("MOCKHASH456", orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "FILLED" || order.FilledQuantity != 10 {
+		t.Errorf("expected order to be filled, got %+v", order)
+	}
+	account, err := sb.GetAccount("MOCKHASH456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cash := account.SecuritiesAccount.InitialBalances.CashBalance; cash != 10000-2901 {
+		t.Errorf("expected cash %v, got %v", 10000-2901, cash)
+	}
+	transactions, err := sb.ListTransactions("MOCKHASH456", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].OrderID != orderID || transactions[0].TransferItems[0].Amount != 10 {
+		t.Errorf("expected the fill as a trade transaction, got %+v", transactions)
+	}
+
+	_, err = sb.PlaceOrder("UNKNOWNHASH", order)
+	var apiErr *broker.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Body == "" {
+		t.Errorf("expected an APIError with status 400 and a body, got %v", err)
+	}
+}
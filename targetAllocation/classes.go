@@ -0,0 +1,94 @@
+package targetAllocation
+
+import (
+	"slices"
+)
+
+// Eligible tickers of the asset class each chosen ticker stands for, by chosen ticker.
+type ClassTickers map[Ticker][]Ticker
+
+// chosen ticker standing for the class the ticker is eligible for
+func (ct ClassTickers) Chosen(ticker Ticker) (Ticker, bool) {
+	for chosen, eligible := range ct {
+		if slices.Contains(eligible, ticker) {
+			return chosen, true
+		}
+	}
+	return "", false
+}
+
+// An asset class is held by any one of its Tickers, listed most preferred first, or split into its nested
+// Classes by their proportions, which sum to 1, e.g.
+//
+//	international:
+//	  proportion: 0.3
+//	  classes:
+//	    developed:
+//	      proportion: 0.8
+//	      tickers: [DFIC, VEA]
+//	    emerging:
+//	      proportion: 0.2
+//	      tickers: [DFEM, VWO]
+func (a Allocation) AssetClass() bool {
+	return len(a.Tickers) > 0 || len(a.Classes) > 0
+}
+
+// whether any entry of the allocation is an asset class
+func HasClasses(allocation TargetAllocation) bool {
+	for _, alloc := range allocation {
+		if alloc.AssetClass() {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries of the allocation with nested classes replaced by their leaves, which take their share of the
+// proportion or fixed cash value of their parent. Settings of a parent class do not carry over to its leaves.
+func Flatten(allocation TargetAllocation) TargetAllocation {
+	flat := make(TargetAllocation)
+	for name, alloc := range allocation {
+		if len(alloc.Classes) == 0 {
+			flat[name] = alloc
+			continue
+		}
+		for leafName, leaf := range Flatten(alloc.Classes) {
+			if alloc.FixedCashValue != 0 {
+				leaf.FixedCashValue = alloc.FixedCashValue * leaf.Proportion
+				leaf.Proportion = 0
+			} else {
+				leaf.Proportion *= alloc.Proportion
+			}
+			flat[leafName] = leaf
+		}
+	}
+	return flat
+}
+
+// name of the leaf class of the allocation that lists the ticker as eligible
+func ClassOf(allocation TargetAllocation, ticker Ticker) (string, bool) {
+	for name, alloc := range Flatten(allocation) {
+		if slices.Contains(alloc.Tickers, ticker) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Flat allocation with every asset class replaced by the ticker choose picks among its eligible tickers, the
+// ticker taking the settings of the class. Returns the eligible tickers of the class of every chosen ticker.
+func Resolve(allocation TargetAllocation, choose func(eligible []Ticker) Ticker) (TargetAllocation, ClassTickers) {
+	resolved := make(TargetAllocation)
+	classes := make(ClassTickers)
+	for name, alloc := range Flatten(allocation) {
+		if !alloc.AssetClass() {
+			resolved[name] = alloc
+			continue
+		}
+		chosen := choose(alloc.Tickers)
+		classes[chosen] = alloc.Tickers
+		alloc.Tickers = nil
+		resolved[chosen] = alloc
+	}
+	return resolved, classes
+}
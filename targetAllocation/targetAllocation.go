@@ -2,10 +2,14 @@ package targetAllocation
 
 import (
 	"errors"
+	"fmt"
+	"maps"
 	"os"
+	"slices"
 
 	"github.com/goccy/go-yaml"
-	"github.com/josephwest2/schwab-portfolio-manager/util"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
 )
 
 var TargetAllocationFile = "targetAllocation.yaml"
@@ -13,11 +17,104 @@ var TargetAllocationFile = "targetAllocation.yaml"
 // last 3 digits of account or 'global' for cross account allocation
 type AccountIdentifier = string
 
+const GlobalAccountIdentifier AccountIdentifier = "global"
+
 type Ticker = string
 
+// top level keys of sections that are not accounts
+const (
+	SubstitutesKey            = "substitutes"
+	SubstantiallyIdenticalKey = "substantiallyIdentical"
+	AccountsKey               = "accounts"
+)
+
+var sectionKeys = []string{SubstitutesKey, SubstantiallyIdenticalKey, AccountsKey}
+
+// tax treatment of an account
+type AccountType string
+
+const (
+	Taxable     AccountType = "taxable"
+	Traditional AccountType = "traditional"
+	Roth        AccountType = "roth"
+	HSA         AccountType = "hsa"
+)
+
+var AccountTypes = []AccountType{Taxable, Traditional, Roth, HSA}
+
+// Settings of an account from the accounts section. MaxCash limits the fixed cash values the asset location
+// planner places in the account, no limit when it is not set.
+type AccountSettings struct {
+	Type    AccountType `yaml:"type"`
+	MaxCash *float64    `yaml:"maxCash"`
+}
+
+// Substitute ticker per original ticker, e.g. DFAC: AVUS. A held substitute counts toward the target of
+// its original so rebalances do not undo a tax loss harvesting swap.
+type Substitutes map[Ticker]Ticker
+
+// original the ticker substitutes for
+func (s Substitutes) Original(ticker Ticker) (Ticker, bool) {
+	for original, substitute := range s {
+		if substitute == ticker {
+			return original, true
+		}
+	}
+	return "", false
+}
+
+// AbsoluteBand and RelativeBand bound how far the asset may drift from its target before it is traded,
+// in proportion points and as a share of the target proportion, e.g. 0.05 and 0.25 for the 5/25 rule.
+// The narrower band applies when both are set.
+// Location lists the account types the asset location planner places the asset in, most preferred first.
+// ShareIncrement or DollarIncrement is the smallest amount the ticker trades in, e.g. 0.001 shares or $1 for
+// fractional shares, whole shares when neither is set.
+// MinProportion and MaxProportion bound the proportion of a proportion target, MaxCashValue caps the value of
+// the ticker in dollars, without a cap when nil. NeverSell keeps every held share, NeverBuy never adds shares.
+// An entry with Tickers or Classes is an asset class named by its key, see AssetClass.
 type Allocation struct {
-	Proportion     float64 `yaml:"proportion"`
-	FixedCashValue float64 `yaml:"fixedCashValue"`
+	Proportion      float64          `yaml:"proportion"`
+	FixedCashValue  float64          `yaml:"fixedCashValue"`
+	AbsoluteBand    float64          `yaml:"absoluteBand"`
+	RelativeBand    float64          `yaml:"relativeBand"`
+	Location        []AccountType    `yaml:"location"`
+	ShareIncrement  float64          `yaml:"shareIncrement"`
+	DollarIncrement float64          `yaml:"dollarIncrement"`
+	MinProportion   float64          `yaml:"minProportion"`
+	MaxProportion   *float64         `yaml:"maxProportion"`
+	MaxCashValue    *float64         `yaml:"maxCashValue"`
+	NeverSell       bool             `yaml:"neverSell"`
+	NeverBuy        bool             `yaml:"neverBuy"`
+	Tickers         []Ticker         `yaml:"tickers"`
+	Classes         TargetAllocation `yaml:"classes"`
+}
+
+// shares the ticker trades in multiples of at the price
+func (a Allocation) Increment(price float64) float64 {
+	switch {
+	case a.ShareIncrement > 0:
+		return a.ShareIncrement
+	case a.DollarIncrement > 0 && price > 0:
+		return a.DollarIncrement / price
+	}
+	return 1
+}
+
+// allowed drift from the target proportion, 0 without bands
+func (a Allocation) Band(targetProportion float64) float64 {
+	switch {
+	case a.AbsoluteBand > 0 && a.RelativeBand > 0:
+		return min(a.AbsoluteBand, a.RelativeBand*targetProportion)
+	case a.AbsoluteBand > 0:
+		return a.AbsoluteBand
+	case a.RelativeBand > 0:
+		return a.RelativeBand * targetProportion
+	}
+	return 0
+}
+
+func (a Allocation) HasBand() bool {
+	return a.AbsoluteBand > 0 || a.RelativeBand > 0
 }
 
 type TargetAllocation = map[Ticker]Allocation
@@ -32,29 +129,160 @@ func (da *TargetAllocations) Tickers(account AccountIdentifier) []Ticker {
 	return tickers
 }
 
-func LoadTargetAllocations(filepath string) (TargetAllocations, error) {
+// Groups of tickers that are substantially identical, e.g. share classes of one fund. Buying any ticker of a
+// group within 30 days of selling one of the group at a loss is a wash sale.
+type IdenticalTickers [][]Ticker
+
+// the ticker and the tickers sharing a group with it
+func (it IdenticalTickers) Of(ticker Ticker) []Ticker {
+	for _, group := range it {
+		if slices.Contains(group, ticker) {
+			return group
+		}
+	}
+	return []Ticker{ticker}
+}
+
+// some asset of the allocation has a drift band
+func HasBands(allocation TargetAllocation) bool {
+	for _, alloc := range allocation {
+		if alloc.HasBand() {
+			return true
+		}
+	}
+	return false
+}
+
+// top level keys of the allocation file with their values, in file order
+func parseSections(data []byte) ([]*ast.MappingValueNode, error) {
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	var sections []*ast.MappingValueNode
+	for _, doc := range file.Docs {
+		if doc.Body == nil {
+			continue
+		}
+		mapping, ok := doc.Body.(ast.MapNode)
+		if !ok {
+			return nil, errors.New("expected a mapping of accounts")
+		}
+		for iter := mapping.MapRange(); iter.Next(); {
+			sections = append(sections, iter.KeyValue())
+		}
+	}
+	return sections, nil
+}
 
+// Top level sections of the allocation file by key. Returns a *ValidationError when a key is set more than once,
+// as the validator reports it.
+func loadSections(filepath string) (map[string]*ast.MappingValueNode, error) {
 	data, err := os.ReadFile(filepath)
 	if err != nil {
 		return nil, errors.New("failed to read allocation file: " + err.Error())
 	}
+	parsed, err := parseSections(data)
+	if err != nil {
+		return nil, errors.New("failed to parse allocation file: " + err.Error())
+	}
+	var v validator
+	if v.duplicates(parsed); len(v.problems) > 0 {
+		return nil, &ValidationError{File: filepath, Problems: v.sorted()}
+	}
+	sections := make(map[string]*ast.MappingValueNode)
+	for _, section := range parsed {
+		sections[section.Key.GetToken().Value] = section
+	}
+	return sections, nil
+}
 
+// Account allocations of the allocation file. Returns a *ValidationError with every problem of the allocations,
+// see ValidateTargetAllocations for the problems of the whole file.
+func LoadTargetAllocations(filepath string) (TargetAllocations, error) {
+	sections, err := loadSections(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var v validator
 	result := make(TargetAllocations)
+	for key, section := range sections {
+		if slices.Contains(sectionKeys, key) {
+			continue
+		}
+		result[key] = v.allocation(key, "", section.Key, section.Value, make(map[string]bool))
+	}
+	if len(v.problems) > 0 {
+		return nil, &ValidationError{File: filepath, Problems: v.sorted()}
+	}
+	return result, nil
+}
 
-	err = yaml.Unmarshal(data, &result)
+// Substitutes section of the allocation file, empty without one. A ticker can not substitute for itself
+// and a substitute can not have a substitute of its own.
+func LoadSubstitutes(filepath string) (Substitutes, error) {
+	sections, err := loadSections(filepath)
 	if err != nil {
-		return nil, errors.New("failed to parse allocation file: " + err.Error())
+		return nil, err
+	}
+	substitutes := make(Substitutes)
+	node, ok := sections[SubstitutesKey]
+	if !ok {
+		return substitutes, nil
 	}
+	if err := yaml.NodeToValue(node.Value, &substitutes); err != nil {
+		return nil, errors.New("failed to parse substitutes: " + err.Error())
+	}
+	if problems := substituteProblems(substitutes); len(problems) > 0 {
+		return nil, fmt.Errorf("%v %v: %v", SubstitutesKey, problems[0].field, problems[0].message)
+	}
+	return substitutes, nil
+}
 
-	for _, accountAllocation := range result {
-		sum := 0.0
-		for _, tickerAllocData := range accountAllocation {
-			sum += tickerAllocData.Proportion
-		}
-		if !util.AlmostEqual(sum, 1.0, 1e-7) {
-			return nil, errors.New("allocation proportions do not sum to 1.0")
-		}
+// Substantially identical section of the allocation file, empty without one. A ticker can only be in one group
+// and a substitute can not be substantially identical to its original, buying it would wash the harvested loss.
+func LoadIdenticalTickers(filepath string) (IdenticalTickers, error) {
+	sections, err := loadSections(filepath)
+	if err != nil {
+		return nil, err
+	}
+	identical := make(IdenticalTickers, 0)
+	node, ok := sections[SubstantiallyIdenticalKey]
+	if !ok {
+		return identical, nil
+	}
+	if err := yaml.NodeToValue(node.Value, &identical); err != nil {
+		return nil, errors.New("failed to parse substantially identical tickers: " + err.Error())
+	}
+	substitutes, err := LoadSubstitutes(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if problems, _ := identicalProblems(identical, substitutes); len(problems) > 0 {
+		return nil, fmt.Errorf("%v %v: %v", SubstantiallyIdenticalKey, problems[0].field, problems[0].message)
 	}
+	return identical, nil
+}
 
-	return result, err
+// Accounts section of the allocation file by account identifier, empty without one
+func LoadAccountSettings(filepath string) (map[AccountIdentifier]AccountSettings, error) {
+	sections, err := loadSections(filepath)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[AccountIdentifier]AccountSettings)
+	node, ok := sections[AccountsKey]
+	if !ok {
+		return settings, nil
+	}
+	if err := yaml.NodeToValue(node.Value, &settings); err != nil {
+		return nil, errors.New("failed to parse account settings: " + err.Error())
+	}
+	for _, account := range slices.Sorted(maps.Keys(settings)) {
+		if problems := settings[account].problems(); len(problems) > 0 {
+			return nil, fmt.Errorf("%v %v: %v", AccountsKey, account, problems[0].message)
+		}
+	}
+	return settings, nil
 }
@@ -1,7 +1,10 @@
 package targetAllocation_test
 
 import (
+	"encoding/json"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/josephwest2/schwab-portfolio-manager/targetAllocation"
@@ -120,6 +123,88 @@ func TestLoadAllocations(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			// the substitutes section is not an account
+			filepath: "testing/targetAllocation_targetAllocationTest6.yaml",
+			expected: targetAllocation.TargetAllocations{
+				"global": targetAllocation.TargetAllocation{
+					"DFAC": {Proportion: 0.7},
+					"DFIC": {Proportion: 0.3},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			filepath: "testing/targetAllocation_targetAllocationTest10.yaml",
+			expected: targetAllocation.TargetAllocations{
+				"global": targetAllocation.TargetAllocation{
+					"VTI":  {Proportion: 0.6, ShareIncrement: 0.001},
+					"VXUS": {Proportion: 0.4, DollarIncrement: 1},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			// both a share and a dollar increment
+			filepath: "testing/targetAllocation_targetAllocationTest11.yaml",
+			expected: nil,
+			wantErr:  true,
+		},
+		{
+			filepath: "testing/targetAllocation_targetAllocationTest12.yaml",
+			expected: targetAllocation.TargetAllocations{
+				"global": targetAllocation.TargetAllocation{
+					"DFAC":  {Proportion: 0.6, MinProportion: 0.5, MaxProportion: ptr(0.7)},
+					"DFIC":  {Proportion: 0.4, MaxCashValue: ptr(50000), NeverSell: true},
+					"SWVXX": {FixedCashValue: 1000, MaxCashValue: ptr(1000), NeverBuy: true},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			// proportion above the max proportion
+			filepath: "testing/targetAllocation_targetAllocationTest13.yaml",
+			expected: nil,
+			wantErr:  true,
+		},
+		{
+			// a min proportion of a ticker that is never bought
+			filepath: "testing/targetAllocation_targetAllocationTest14.yaml",
+			expected: nil,
+			wantErr:  true,
+		},
+		{
+			filepath: "testing/targetAllocation_targetAllocationTest15.yaml",
+			expected: targetAllocation.TargetAllocations{
+				"global": targetAllocation.TargetAllocation{
+					"usEquity": {Proportion: 0.6, Tickers: []string{"DFAC", "VTI", "SWTSX"}},
+					"international": {Proportion: 0.3, Classes: targetAllocation.TargetAllocation{
+						"developed": {Proportion: 0.8, Tickers: []string{"DFIC", "VEA"}},
+						"emerging":  {Proportion: 0.2, Tickers: []string{"DFEM", "VWO"}},
+					}},
+					"BND": {Proportion: 0.1},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			// VTI is eligible for two classes
+			filepath: "testing/targetAllocation_targetAllocationTest16.yaml",
+			expected: nil,
+			wantErr:  true,
+		},
+		{
+			// nested proportions sum to 1.1
+			filepath: "testing/targetAllocation_targetAllocationTest17.yaml",
+			expected: nil,
+			wantErr:  true,
+		},
+		{
+			// global is set in two documents
+			filepath: "testing/targetAllocation_targetAllocationTest21.yaml",
+			expected: nil,
+			wantErr:  true,
+		},
 	}
 	for i, test := range tests {
 		allocations, err := targetAllocation.LoadTargetAllocations(test.filepath)
@@ -132,3 +217,244 @@ func TestLoadAllocations(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadSubstitutes(t *testing.T) {
+	tests := []struct {
+		filepath string
+		expected targetAllocation.Substitutes
+		wantErr  bool
+	}{
+		{"testing/targetAllocation_targetAllocationTest6.yaml", targetAllocation.Substitutes{"DFAC": "AVUS", "DFIC": "AVDE"}, false},
+		{"testing/targetAllocation_targetAllocationTest1.yaml", targetAllocation.Substitutes{}, false},
+		// AVUS substitutes for DFAC and has a substitute of its own
+		{"testing/targetAllocation_targetAllocationTest7.yaml", nil, true},
+	}
+	for i, test := range tests {
+		substitutes, err := targetAllocation.LoadSubstitutes(test.filepath)
+		if (err != nil) != test.wantErr {
+			t.Errorf("expected error %v, got %v on test index %v", test.wantErr, err, i)
+		}
+		if !reflect.DeepEqual(substitutes, test.expected) {
+			t.Errorf("expected %v, got %v on test index %v", test.expected, substitutes, i)
+		}
+	}
+	if original, ok := targetAllocation.Substitutes(map[string]string{"DFAC": "AVUS"}).Original("AVUS"); !ok || original != "DFAC" {
+		t.Errorf("expected AVUS to substitute for DFAC, got %v", original)
+	}
+}
+
+func TestLoadIdenticalTickers(t *testing.T) {
+	tests := []struct {
+		filepath string
+		expected targetAllocation.IdenticalTickers
+		wantErr  bool
+	}{
+		{"testing/targetAllocation_targetAllocationTest6.yaml", targetAllocation.IdenticalTickers{{"DFAC", "DFUS"}, {"VOO", "IVV", "SPY"}}, false},
+		{"testing/targetAllocation_targetAllocationTest1.yaml", targetAllocation.IdenticalTickers{}, false},
+		// AVUS substitutes for DFAC and is listed as substantially identical to it
+		{"testing/targetAllocation_targetAllocationTest8.yaml", nil, true},
+	}
+	for i, test := range tests {
+		identical, err := targetAllocation.LoadIdenticalTickers(test.filepath)
+		if (err != nil) != test.wantErr {
+			t.Errorf("expected error %v, got %v on test index %v", test.wantErr, err, i)
+		}
+		if !reflect.DeepEqual(identical, test.expected) {
+			t.Errorf("expected %v, got %v on test index %v", test.expected, identical, i)
+		}
+	}
+	if group := (targetAllocation.IdenticalTickers{{"VOO", "IVV"}}).Of("IVV"); !reflect.DeepEqual(group, []string{"VOO", "IVV"}) {
+		t.Errorf("expected IVV to be identical to VOO, got %v", group)
+	}
+}
+
+func TestLoadAccountSettings(t *testing.T) {
+	maxCash := 500.0
+	tests := []struct {
+		filepath string
+		expected map[targetAllocation.AccountIdentifier]targetAllocation.AccountSettings
+		wantErr  bool
+	}{
+		{"testing/targetAllocation_targetAllocationTest6.yaml", map[string]targetAllocation.AccountSettings{
+			"123": {Type: targetAllocation.Taxable, MaxCash: &maxCash},
+			"456": {Type: targetAllocation.Roth},
+		}, false},
+		{"testing/targetAllocation_targetAllocationTest1.yaml", map[string]targetAllocation.AccountSettings{}, false},
+		// brokerage is not an account type
+		{"testing/targetAllocation_targetAllocationTest9.yaml", nil, true},
+	}
+	for i, test := range tests {
+		settings, err := targetAllocation.LoadAccountSettings(test.filepath)
+		if (err != nil) != test.wantErr {
+			t.Errorf("expected error %v, got %v on test index %v", test.wantErr, err, i)
+		}
+		if !reflect.DeepEqual(settings, test.expected) {
+			t.Errorf("expected %v, got %v on test index %v", test.expected, settings, i)
+		}
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	tests := []struct {
+		allocation targetAllocation.Allocation
+		price      float64
+		expected   float64
+	}{
+		{targetAllocation.Allocation{Proportion: 1}, 10, 1},
+		{targetAllocation.Allocation{Proportion: 1, ShareIncrement: 0.001}, 10, 0.001},
+		{targetAllocation.Allocation{Proportion: 1, DollarIncrement: 5}, 250, 0.02},
+		// no price to turn dollars into shares
+		{targetAllocation.Allocation{Proportion: 1, DollarIncrement: 5}, 0, 1},
+	}
+	for i, test := range tests {
+		if increment := test.allocation.Increment(test.price); increment != test.expected {
+			t.Errorf("expected increment %v, got %v, on test index %v", test.expected, increment, i)
+		}
+	}
+}
+
+func ptr(value float64) *float64 {
+	return &value
+}
+
+func TestResolve(t *testing.T) {
+	allocation := targetAllocation.TargetAllocation{
+		"usEquity": {Proportion: 1, Classes: targetAllocation.TargetAllocation{
+			"large": {Proportion: 0.75, Tickers: []string{"DFAC", "VTI"}, NeverSell: true},
+			"small": {Proportion: 0.25, Tickers: []string{"DFAS", "VB"}},
+		}},
+		"cash": {FixedCashValue: 1000, Classes: targetAllocation.TargetAllocation{
+			"moneyMarket": {Proportion: 1, Tickers: []string{"SWVXX"}},
+		}},
+	}
+	// the last eligible ticker of every class
+	resolved, classes := targetAllocation.Resolve(allocation, func(eligible []string) string {
+		return eligible[len(eligible)-1]
+	})
+	expected := targetAllocation.TargetAllocation{
+		"VTI":   {Proportion: 0.75, NeverSell: true},
+		"VB":    {Proportion: 0.25},
+		"SWVXX": {FixedCashValue: 1000},
+	}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("expected %v, got %v", expected, resolved)
+	}
+	if chosen, ok := classes.Chosen("DFAS"); !ok || chosen != "VB" {
+		t.Errorf("expected DFAS to count toward VB, got %v", chosen)
+	}
+	if class, ok := targetAllocation.ClassOf(allocation, "DFAC"); !ok || class != "large" {
+		t.Errorf("expected DFAC in the large class, got %v", class)
+	}
+}
+
+func TestValidateTargetAllocations(t *testing.T) {
+	tests := []struct {
+		filepath string
+		accounts []targetAllocation.AccountIdentifier
+		expected []targetAllocation.Problem
+	}{
+		{"testing/targetAllocation_targetAllocationTest6.yaml", nil, nil},
+		{"testing/targetAllocation_targetAllocationTest6.yaml", []string{"123", "456"}, nil},
+		// 567 is not an account
+		{"testing/targetAllocation_targetAllocationTest2.yaml", []string{"123"}, []targetAllocation.Problem{
+			{Account: "567", Line: 1, Column: 1, Message: "no account ending in 567, expected global or one of [123]"},
+		}},
+		{"testing/targetAllocation_targetAllocationTest18.yaml", []string{"123"}, []targetAllocation.Problem{
+			{Account: "global", Ticker: "DFAC", Line: 4, Column: 5, Message: "both a proportion and a fixed cash value, an entry has one or the other"},
+			{Account: "global", Ticker: "DFIC", Line: 6, Column: 5, Message: "negative proportion"},
+			{Account: "global", Ticker: "DFIC", Line: 7, Column: 5, Message: "unknown key \"absolutBand\", expected one of proportion, fixedCashValue, absoluteBand, relativeBand, location, shareIncrement, dollarIncrement, minProportion, maxProportion, maxCashValue, neverSell, neverBuy, tickers, classes"},
+			{Account: "global", Ticker: "international", Line: 10, Column: 5, Message: "proportions sum to 0.7, not 1"},
+			{Account: "999", Line: 14, Column: 1, Message: "proportions sum to 0, not 1"},
+			{Account: "999", Line: 14, Column: 1, Message: "no account ending in 999, expected global or one of [123]"},
+			{Account: "999", Ticker: "VTI", Line: 16, Column: 17, Message: "invalid proportion: cannot unmarshal string into Go value of type float64"},
+			{Account: "999", Ticker: "VXUS", Line: 17, Column: 9, Message: "expected a mapping of settings"},
+			{Account: "substitutes", Ticker: "VTI", Line: 19, Column: 3, Message: "invalid substitute \"VTI\""},
+			{Account: "accounts", Ticker: "123", Line: 22, Column: 5, Message: "unknown account type \"brokerage\", expected one of [taxable traditional roth hsa]"},
+		}},
+		// usEquity and total both list VTI
+		{"testing/targetAllocation_targetAllocationTest16.yaml", nil, []targetAllocation.Problem{
+			{Account: "global", Ticker: "total", Line: 7, Column: 5, Message: "VTI is used more than once in the allocation"},
+		}},
+		// the nested classes of international sum to 1.1
+		{"testing/targetAllocation_targetAllocationTest17.yaml", nil, []targetAllocation.Problem{
+			{Account: "global", Ticker: "international", Line: 7, Column: 5, Message: "proportions sum to 1.1, not 1"},
+		}},
+		{"testing/targetAllocation_targetAllocationTest19.yaml", nil, []targetAllocation.Problem{
+			{Line: 3, Column: 17, Message: "sequence end token ']' not found"},
+		}},
+		// every constraint DFAC breaks is reported
+		{"testing/targetAllocation_targetAllocationTest20.yaml", nil, []targetAllocation.Problem{
+			{Account: "global", Ticker: "DFAC", Line: 3, Column: 5, Message: "proportion is outside of the min and max proportion"},
+			{Account: "global", Ticker: "DFAC", Line: 5, Column: 5, Message: "negative max cash value"},
+			{Account: "global", Ticker: "DFAC", Line: 6, Column: 5, Message: "min proportion can not be met when never bought"},
+		}},
+		{"testing/targetAllocation_targetAllocationTest21.yaml", nil, []targetAllocation.Problem{
+			{Account: "global", Line: 5, Column: 1, Message: "global is set more than once"},
+		}},
+	}
+	for i, test := range tests {
+		problems, err := targetAllocation.ValidateTargetAllocations(test.filepath, test.accounts)
+		if err != nil {
+			t.Errorf("unexpected error %v on test index %v", err, i)
+		}
+		if !reflect.DeepEqual(problems, test.expected) {
+			t.Errorf("expected %v, got %v on test index %v", test.expected, problems, i)
+		}
+	}
+
+	_, err := targetAllocation.LoadTargetAllocations("testing/targetAllocation_targetAllocationTest18.yaml")
+	validationErr, ok := err.(*targetAllocation.ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	// the sections are only checked by their own loaders and the account keys by the validator
+	if len(validationErr.Problems) != 7 {
+		t.Errorf("expected the 7 problems of the allocations, got %v", validationErr.Problems)
+	}
+	if line := strings.Split(err.Error(), "\n")[0]; line != "testing/targetAllocation_targetAllocationTest18.yaml:4:5: global DFAC: both a proportion and a fixed cash value, an entry has one or the other" {
+		t.Errorf("unexpected first line %v", line)
+	}
+}
+
+// the schema accepts the same settings as the loader
+func TestSchema(t *testing.T) {
+	var schema struct {
+		Definitions map[string]struct {
+			Enum       []string                   `json:"enum"`
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(targetAllocation.Schema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	keys := func(v any) []string {
+		var result []string
+		typ := reflect.TypeOf(v)
+		for i := range typ.NumField() {
+			result = append(result, typ.Field(i).Tag.Get("yaml"))
+		}
+		slices.Sort(result)
+		return result
+	}
+	properties := func(definition string) []string {
+		var result []string
+		for key := range schema.Definitions[definition].Properties {
+			result = append(result, key)
+		}
+		slices.Sort(result)
+		return result
+	}
+	if expected, got := keys(targetAllocation.Allocation{}), properties("entry"); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected entry properties %v, got %v", expected, got)
+	}
+	if expected, got := keys(targetAllocation.AccountSettings{}), properties("accountSettings"); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected account settings properties %v, got %v", expected, got)
+	}
+	var accountTypes []string
+	for _, accountType := range targetAllocation.AccountTypes {
+		accountTypes = append(accountTypes, string(accountType))
+	}
+	if got := schema.Definitions["accountType"].Enum; !reflect.DeepEqual(accountTypes, got) {
+		t.Errorf("expected account types %v, got %v", accountTypes, got)
+	}
+}
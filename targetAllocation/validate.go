@@ -0,0 +1,495 @@
+package targetAllocation
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/token"
+	"github.com/josephwest2/schwab-portfolio-manager/util"
+)
+
+// JSON Schema of the allocation file, for editors that validate yaml against a schema
+//
+//go:embed targetAllocation.schema.json
+var Schema []byte
+
+// Problem in the allocation file at the line and column of the key or value it is about. Account is the top level
+// key it is under, an account, global or a section, and Ticker the entry, nested asset classes joined by dots.
+type Problem struct {
+	Account AccountIdentifier
+	Ticker  string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	location := fmt.Sprintf("%v:%v", p.Line, p.Column)
+	if keys := strings.TrimSpace(p.Account + " " + p.Ticker); keys != "" {
+		location += ": " + keys
+	}
+	return location + ": " + p.Message
+}
+
+// every problem found in an allocation file, one per line
+type ValidationError struct {
+	File     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		lines[i] = e.File + ":" + problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Every problem of the allocation file ordered by position, none when it is valid. Account keys other than global
+// must be one of the accounts, unless accounts is nil. Returns an error only when the file can not be read.
+func ValidateTargetAllocations(filepath string, accounts []AccountIdentifier) ([]Problem, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, errors.New("failed to read allocation file: " + err.Error())
+	}
+	var v validator
+	sections, err := parseSections(data)
+	if err != nil {
+		var yamlErr yaml.Error
+		if !errors.As(err, &yamlErr) {
+			return nil, errors.New("failed to parse allocation file: " + err.Error())
+		}
+		v.add("", "", yamlErr.GetToken(), "%v", yamlErr.GetMessage())
+		return v.sorted(), nil
+	}
+	v.file(sections, accounts)
+	return v.sorted(), nil
+}
+
+// collects the problems of an allocation file
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(account AccountIdentifier, ticker string, tk *token.Token, format string, args ...any) {
+	problem := Problem{Account: account, Ticker: ticker, Message: fmt.Sprintf(format, args...)}
+	if tk != nil && tk.Position != nil {
+		problem.Line, problem.Column = tk.Position.Line, tk.Position.Column
+	}
+	v.problems = append(v.problems, problem)
+}
+
+func (v *validator) sorted() []Problem {
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return v.problems
+}
+
+// reports the top level keys set more than once
+func (v *validator) duplicates(sections []*ast.MappingValueNode) {
+	seen := make(map[string]bool)
+	for _, section := range sections {
+		key := section.Key.GetToken().Value
+		if seen[key] {
+			v.add(key, "", section.Key.GetToken(), "%v is set more than once", key)
+		}
+		seen[key] = true
+	}
+}
+
+func (v *validator) file(sections []*ast.MappingValueNode, accounts []AccountIdentifier) {
+	v.duplicates(sections)
+	substitutes := make(Substitutes)
+	for _, section := range sections {
+		if section.Key.GetToken().Value == SubstitutesKey {
+			substitutes = v.substitutes(section)
+		}
+	}
+	for _, section := range sections {
+		key := section.Key.GetToken().Value
+		switch key {
+		case SubstitutesKey:
+		case SubstantiallyIdenticalKey:
+			v.identical(section, substitutes)
+		case AccountsKey:
+			v.accountSettings(section, accounts)
+		default:
+			v.allocation(key, "", section.Key, section.Value, make(map[string]bool))
+			if accounts != nil && key != GlobalAccountIdentifier && !slices.Contains(accounts, key) {
+				v.add(key, "", section.Key.GetToken(), "no account ending in %v, expected %v or one of %v", key, GlobalAccountIdentifier, accounts)
+			}
+		}
+	}
+}
+
+// Entries of an account allocation, or of the nested classes of the asset class when class is set, checking them
+// and that their proportions sum to 1. Every entry name and eligible ticker is used once in an account.
+func (v *validator) allocation(account AccountIdentifier, class string, key ast.MapKeyNode, node ast.Node, used map[string]bool) TargetAllocation {
+	allocation := make(TargetAllocation)
+	sum := 0.0
+	if _, ok := node.(*ast.NullNode); !ok {
+		mapping, ok := node.(ast.MapNode)
+		if !ok {
+			v.add(account, class, node.GetToken(), "expected a mapping of tickers and asset classes")
+			return allocation
+		}
+		for iter := mapping.MapRange(); iter.Next(); {
+			name := iter.Key().GetToken().Value
+			ticker := name
+			if class != "" {
+				ticker = class + "." + name
+			}
+			if used[name] {
+				v.add(account, ticker, iter.Key().GetToken(), "%v is used more than once in the allocation", name)
+			}
+			used[name] = true
+			alloc := v.entry(account, ticker, iter.Key(), iter.Value(), used, class != "")
+			allocation[name] = alloc
+			sum += alloc.Proportion
+		}
+	}
+	if !util.AlmostEqual(sum, 1.0, 1e-7) {
+		v.add(account, class, key.GetToken(), "proportions sum to %v, not 1", strconv.FormatFloat(math.Round(sum*1e9)/1e9, 'f', -1, 64))
+	}
+	return allocation
+}
+
+// an entry of an allocation, nested when it is one of the classes of an asset class
+func (v *validator) entry(account AccountIdentifier, ticker string, key ast.MapKeyNode, node ast.Node, used map[string]bool, nested bool) Allocation {
+	var alloc Allocation
+	fields := v.decodeFields(account, ticker, node, &alloc, "classes")
+	if classes, ok := fields["classes"]; ok {
+		alloc.Classes = v.allocation(account, ticker, classes.Key, classes.Value, used)
+	}
+	at := func(field string) *token.Token {
+		if kv, ok := fields[field]; ok {
+			return kv.Key.GetToken()
+		}
+		return key.GetToken()
+	}
+	for _, problem := range alloc.problems(nested) {
+		v.add(account, ticker, at(problem.field), "%v", problem.message)
+	}
+	name := key.GetToken().Value
+	for _, eligible := range alloc.Tickers {
+		if eligible == "" || eligible == name {
+			continue
+		}
+		if used[eligible] {
+			v.add(account, ticker, at("tickers"), "%v is used more than once in the allocation", eligible)
+		}
+		used[eligible] = true
+	}
+	return alloc
+}
+
+// problem with the setting of an entry under the field key
+type fieldProblem struct {
+	field   string
+	message string
+}
+
+// Problems of the settings of an allocation entry on their own, nested when the entry is a nested asset class.
+// Entries whose settings have no problems and whose proportions sum to 1 are a feasible allocation.
+func (a Allocation) problems(nested bool) []fieldProblem {
+	var problems []fieldProblem
+	add := func(field, format string, args ...any) {
+		problems = append(problems, fieldProblem{field, fmt.Sprintf(format, args...)})
+	}
+	if a.Proportion < 0 {
+		add("proportion", "negative proportion")
+	}
+	if a.FixedCashValue < 0 {
+		add("fixedCashValue", "negative fixed cash value")
+	}
+	if a.Proportion != 0 && a.FixedCashValue != 0 {
+		add("fixedCashValue", "both a proportion and a fixed cash value, an entry has one or the other")
+	}
+	if a.AbsoluteBand < 0 {
+		add("absoluteBand", "negative drift band")
+	}
+	if a.RelativeBand < 0 {
+		add("relativeBand", "negative drift band")
+	}
+	if a.ShareIncrement < 0 {
+		add("shareIncrement", "negative increment")
+	}
+	if a.DollarIncrement < 0 {
+		add("dollarIncrement", "negative increment")
+	}
+	if a.ShareIncrement > 0 && a.DollarIncrement > 0 {
+		add("dollarIncrement", "both a share and a dollar increment")
+	}
+
+	// every constraint is checked on its own, those comparing the proportion bounds only once they are valid
+	maxProportion := 1.0
+	if a.MaxProportion != nil {
+		maxProportion = *a.MaxProportion
+	}
+	validBounds := true
+	if a.MinProportion < 0 || a.MinProportion > 1 {
+		add("minProportion", "min proportion must be between 0 and 1")
+		validBounds = false
+	}
+	if maxProportion < 0 || maxProportion > 1 {
+		add("maxProportion", "max proportion must be between 0 and 1")
+		validBounds = false
+	}
+	if validBounds && a.MinProportion > maxProportion {
+		add("minProportion", "min proportion is above the max proportion")
+		validBounds = false
+	}
+	if a.MaxCashValue != nil && *a.MaxCashValue < 0 {
+		add("maxCashValue", "negative max cash value")
+	}
+	if a.FixedCashValue != 0 && a.MinProportion != 0 {
+		add("minProportion", "min proportion does not apply to a fixed cash value")
+	}
+	if a.FixedCashValue != 0 && a.MaxProportion != nil {
+		add("maxProportion", "max proportion does not apply to a fixed cash value")
+	}
+	if a.MaxCashValue != nil && *a.MaxCashValue >= 0 && a.FixedCashValue > *a.MaxCashValue {
+		add("fixedCashValue", "fixed cash value is above the max cash value")
+	}
+	if validBounds && a.FixedCashValue == 0 && a.Proportion >= 0 && (a.Proportion < a.MinProportion || a.Proportion > maxProportion) {
+		add("proportion", "proportion is outside of the min and max proportion")
+	}
+	if a.NeverBuy && a.MinProportion > 0 {
+		add("neverBuy", "min proportion can not be met when never bought")
+	}
+
+	for _, accountType := range a.Location {
+		if !slices.Contains(AccountTypes, accountType) {
+			add("location", "unknown account type %q, expected one of %v", accountType, AccountTypes)
+		}
+	}
+
+	if len(a.Tickers) > 0 && len(a.Classes) > 0 {
+		add("classes", "both tickers and classes, an asset class has one or the other")
+	}
+	if nested && a.FixedCashValue != 0 {
+		add("fixedCashValue", "fixed cash value in a nested asset class, nested classes are proportions of their parent")
+	}
+	if slices.Contains(a.Tickers, "") {
+		add("tickers", "empty ticker")
+	}
+	return problems
+}
+
+// problems of the settings of an account in the accounts section
+func (s AccountSettings) problems() []fieldProblem {
+	var problems []fieldProblem
+	if !slices.Contains(AccountTypes, s.Type) {
+		problems = append(problems, fieldProblem{"type", fmt.Sprintf("unknown account type %q, expected one of %v", s.Type, AccountTypes)})
+	}
+	if s.MaxCash != nil && *s.MaxCash < 0 {
+		problems = append(problems, fieldProblem{"maxCash", "negative maxCash"})
+	}
+	return problems
+}
+
+// problems of the substitutes section by original ticker
+func substituteProblems(substitutes Substitutes) []fieldProblem {
+	var problems []fieldProblem
+	originals := slices.Sorted(maps.Keys(substitutes))
+	for _, original := range originals {
+		substitute := substitutes[original]
+		switch {
+		case substitute == "" || substitute == original:
+			problems = append(problems, fieldProblem{original, fmt.Sprintf("invalid substitute %q", substitute)})
+			continue
+		case substitutes[substitute] != "":
+			problems = append(problems, fieldProblem{original, fmt.Sprintf("substitute %v has a substitute of its own", substitute)})
+		}
+		for _, other := range originals {
+			if other != original && substitutes[other] == substitute {
+				problems = append(problems, fieldProblem{original, fmt.Sprintf("substitute %v also substitutes for %v", substitute, other)})
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// problems of the groups of substantially identical tickers by ticker, with the index of the group it is in
+func identicalProblems(identical IdenticalTickers, substitutes Substitutes) ([]fieldProblem, []int) {
+	var problems []fieldProblem
+	var groups []int
+	seen := make(map[Ticker]bool)
+	for i, group := range identical {
+		for _, ticker := range group {
+			if seen[ticker] {
+				problems = append(problems, fieldProblem{ticker, "in more than one group of substantially identical tickers"})
+				groups = append(groups, i)
+			}
+			seen[ticker] = true
+			if substitute, ok := substitutes[ticker]; ok && slices.Contains(group, substitute) {
+				problems = append(problems, fieldProblem{ticker, fmt.Sprintf("substantially identical to its substitute %v", substitute)})
+				groups = append(groups, i)
+			}
+		}
+	}
+	return problems, groups
+}
+
+func (v *validator) substitutes(section *ast.MappingValueNode) Substitutes {
+	substitutes := make(Substitutes)
+	if !v.decode(SubstitutesKey, section.Value, &substitutes) {
+		return make(Substitutes)
+	}
+	keys := mappingKeys(section.Value)
+	for _, problem := range substituteProblems(substitutes) {
+		v.add(SubstitutesKey, problem.field, keys[problem.field], "%v", problem.message)
+	}
+	return substitutes
+}
+
+func (v *validator) identical(section *ast.MappingValueNode, substitutes Substitutes) {
+	identical := make(IdenticalTickers, 0)
+	if !v.decode(SubstantiallyIdenticalKey, section.Value, &identical) {
+		return
+	}
+	sequence, _ := section.Value.(*ast.SequenceNode)
+	problems, groups := identicalProblems(identical, substitutes)
+	for i, problem := range problems {
+		tk := section.Key.GetToken()
+		if sequence != nil && groups[i] < len(sequence.Values) {
+			tk = sequence.Values[groups[i]].GetToken()
+		}
+		v.add(SubstantiallyIdenticalKey, problem.field, tk, "%v", problem.message)
+	}
+}
+
+func (v *validator) accountSettings(section *ast.MappingValueNode, accounts []AccountIdentifier) {
+	if _, ok := section.Value.(*ast.NullNode); ok {
+		return
+	}
+	mapping, ok := section.Value.(ast.MapNode)
+	if !ok {
+		v.add(AccountsKey, "", section.Value.GetToken(), "expected a mapping of accounts")
+		return
+	}
+	for iter := mapping.MapRange(); iter.Next(); {
+		account := iter.Key().GetToken().Value
+		var settings AccountSettings
+		fields := v.decodeFields(AccountsKey, account, iter.Value(), &settings)
+		for _, problem := range settings.problems() {
+			tk := iter.Key().GetToken()
+			if kv, ok := fields[problem.field]; ok {
+				tk = kv.Key.GetToken()
+			}
+			v.add(AccountsKey, account, tk, "%v", problem.message)
+		}
+		if accounts != nil && !slices.Contains(accounts, account) {
+			v.add(AccountsKey, account, iter.Key().GetToken(), "no account ending in %v, expected one of %v", account, accounts)
+		}
+	}
+}
+
+// decodes the node into target, reporting the error at its position
+func (v *validator) decode(account AccountIdentifier, node ast.Node, target any) bool {
+	err := yaml.NodeToValue(node, target)
+	if err == nil {
+		return true
+	}
+	var yamlErr yaml.Error
+	if errors.As(err, &yamlErr) {
+		v.add(account, "", yamlErr.GetToken(), "%v", yamlErr.GetMessage())
+	} else {
+		v.add(account, "", node.GetToken(), "%v", err)
+	}
+	return false
+}
+
+// Decodes the keys of the mapping into the fields of target, a pointer to a struct, by their yaml tags, except
+// for the skipped keys. Reports keys that are not a field and values that do not decode. Returns the key and
+// value of every field set by key.
+func (v *validator) decodeFields(account AccountIdentifier, ticker string, node ast.Node, target any, skip ...string) map[string]*ast.MappingValueNode {
+	fields := make(map[string]*ast.MappingValueNode)
+	if _, ok := node.(*ast.NullNode); ok {
+		return fields
+	}
+	mapping, ok := node.(ast.MapNode)
+	if !ok {
+		v.add(account, ticker, node.GetToken(), "expected a mapping of settings")
+		return fields
+	}
+	value := reflect.ValueOf(target).Elem()
+	keys := yamlKeys(value.Type())
+	for iter := mapping.MapRange(); iter.Next(); {
+		kv := iter.KeyValue()
+		name := kv.Key.GetToken().Value
+		i := slices.Index(keys, name)
+		if i < 0 {
+			v.add(account, ticker, kv.Key.GetToken(), "unknown key %q, expected one of %v", name, strings.Join(keys, ", "))
+			continue
+		}
+		if _, ok := fields[name]; ok {
+			v.add(account, ticker, kv.Key.GetToken(), "%v is set more than once", name)
+		}
+		fields[name] = kv
+		if slices.Contains(skip, name) {
+			continue
+		}
+		if err := decodeField(kv.Value, value.Field(i)); err != nil {
+			v.add(account, ticker, kv.Value.GetToken(), "invalid %v: %v", name, err)
+		}
+	}
+	return fields
+}
+
+// Decodes the node into the field. Pointer fields are decoded through their element, the decoder leaves them
+// nil on a value of the wrong type.
+func decodeField(node ast.Node, field reflect.Value) error {
+	if _, ok := node.(*ast.NullNode); ok {
+		return nil
+	}
+	target := field
+	if field.Kind() == reflect.Pointer {
+		target = reflect.New(field.Type().Elem()).Elem()
+	}
+	if err := yaml.NodeToValue(node, target.Addr().Interface()); err != nil {
+		var yamlErr yaml.Error
+		if errors.As(err, &yamlErr) {
+			return errors.New(yamlErr.GetMessage())
+		}
+		return err
+	}
+	if field.Kind() == reflect.Pointer {
+		field.Set(target.Addr())
+	}
+	return nil
+}
+
+// yaml keys of the fields of the struct type in field order
+func yamlKeys(t reflect.Type) []string {
+	keys := make([]string, t.NumField())
+	for i := range keys {
+		keys[i], _, _ = strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+	}
+	return keys
+}
+
+// token of every key of the mapping by key
+func mappingKeys(node ast.Node) map[string]*token.Token {
+	keys := make(map[string]*token.Token)
+	if mapping, ok := node.(ast.MapNode); ok {
+		for iter := mapping.MapRange(); iter.Next(); {
+			keys[iter.Key().GetToken().Value] = iter.Key().GetToken()
+		}
+	}
+	return keys
+}
@@ -0,0 +1,282 @@
+package taxlot
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+// Lot relief method, the values are the taxLotMethod of schwab orders
+type Method string
+
+const (
+	FIFO          Method = "FIFO"
+	LIFO          Method = "LIFO"
+	HighestCost   Method = "HIGH_COST"
+	LossHarvester Method = "LOSS_HARVESTER"
+)
+
+var Methods = []Method{FIFO, LIFO, HighestCost, LossHarvester}
+
+// accepts fifo, lifo, highest-cost and loss-harvester as well as the schwab names, in any case
+func ParseMethod(s string) (Method, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(s, "-", "_"))
+	switch normalized {
+	case "HIGHEST_COST":
+		return HighestCost, nil
+	case "TAX_EFFICIENT_LOSS_HARVESTER":
+		return LossHarvester, nil
+	}
+	if slices.Contains(Methods, Method(normalized)) {
+		return Method(normalized), nil
+	}
+	return "", fmt.Errorf("unknown lot method %q, expected fifo, lifo, highest-cost or loss-harvester", s)
+}
+
+// Shares of a ticker bought together. Lots acquired before the transaction history was fetched
+// have a zero Acquired time and are treated as long term.
+type Lot struct {
+	Ticker   string
+	Acquired time.Time
+	Quantity float64
+	// cost basis per share
+	Cost float64
+}
+
+// held more than a year, so gains are long term
+func (l Lot) LongTerm(now time.Time) bool {
+	return now.After(l.Acquired.AddDate(1, 0, 0))
+}
+
+// Open lots per ticker from trade transactions, in the order they were acquired.
+// Sales in the history relieve lots first in, first out.
+func FromTransactions(transactions []trader.Transaction) (map[string][]Lot, error) {
+	lots, _, err := replay(transactions)
+	return lots, err
+}
+
+// Shares sold by a trade with the cost of the lots it relieved first in, first out.
+// Shares acquired before the history have no known cost, they are counted in Unknown instead of Basis.
+type Disposal struct {
+	Ticker   string
+	Sold     time.Time
+	Quantity float64
+	Price    float64
+	Basis    float64
+	Unknown  float64
+}
+
+// sales of the trade transactions in the order they were made
+func Disposals(transactions []trader.Transaction) ([]Disposal, error) {
+	_, disposals, err := replay(transactions)
+	return disposals, err
+}
+
+// every purchase of the trade transactions as a lot, whether it was sold since or not, in the order they were made
+func Purchases(transactions []trader.Transaction) ([]Lot, error) {
+	trades, err := parseTrades(transactions)
+	if err != nil {
+		return nil, err
+	}
+	purchases := make([]Lot, 0)
+	for _, t := range trades {
+		if t.item.Amount > 0 {
+			purchases = append(purchases, Lot{Ticker: t.item.Instrument.Symbol, Acquired: t.time, Quantity: t.item.Amount, Cost: t.item.Price})
+		}
+	}
+	return purchases, nil
+}
+
+// security side of a trade
+type tradedItem struct {
+	time time.Time
+	item trader.TransferItem
+}
+
+// trades of the transactions in the order they were made
+func parseTrades(transactions []trader.Transaction) ([]tradedItem, error) {
+	trades := make([]tradedItem, 0)
+	for _, transaction := range transactions {
+		if transaction.Type != "TRADE" {
+			continue
+		}
+		traded, err := time.Parse(trader.TimeFormat, transaction.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time of transaction %v: %w", transaction.ActivityID, err)
+		}
+		for _, item := range transaction.TransferItems {
+			// fees and the cash side of the trade are separate items
+			if item.FeeType != "" || item.Instrument.AssetType == "CURRENCY" || item.Instrument.Symbol == "" {
+				continue
+			}
+			trades = append(trades, tradedItem{traded, item})
+		}
+	}
+	slices.SortStableFunc(trades, func(a, b tradedItem) int { return a.time.Compare(b.time) })
+	return trades, nil
+}
+
+func replay(transactions []trader.Transaction) (map[string][]Lot, []Disposal, error) {
+	trades, err := parseTrades(transactions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lots := make(map[string][]Lot)
+	disposals := make([]Disposal, 0)
+	for _, t := range trades {
+		ticker := t.item.Instrument.Symbol
+		if t.item.Amount > 0 {
+			lots[ticker] = append(lots[ticker], Lot{Ticker: ticker, Acquired: t.time, Quantity: t.item.Amount, Cost: t.item.Price})
+			continue
+		}
+		disposal := Disposal{Ticker: ticker, Sold: t.time, Quantity: -t.item.Amount, Price: t.item.Price, Unknown: -t.item.Amount}
+		for _, sale := range Select(lots[ticker], disposal.Quantity, t.item.Price, FIFO, t.time) {
+			disposal.Basis += sale.Basis
+			disposal.Unknown -= sale.Quantity
+		}
+		disposal.Unknown = math.Max(0, disposal.Unknown)
+		disposals = append(disposals, disposal)
+		lots[ticker] = relieve(lots[ticker], disposal.Quantity)
+	}
+	for ticker := range lots {
+		if len(lots[ticker]) == 0 {
+			delete(lots, ticker)
+		}
+	}
+	return lots, disposals, nil
+}
+
+// removes quantity from the oldest lots
+func relieve(lots []Lot, quantity float64) []Lot {
+	for len(lots) > 0 && quantity > 1e-9 {
+		taken := math.Min(lots[0].Quantity, quantity)
+		lots[0].Quantity -= taken
+		quantity -= taken
+		if lots[0].Quantity <= 1e-9 {
+			lots = lots[1:]
+		}
+	}
+	return lots
+}
+
+// Lots making up a position of quantity shares at averagePrice. Shares the lots do not account for were
+// acquired before the history and are added as one lot carrying the rest of the average cost, lots for
+// more shares than are held are relieved oldest first.
+func Reconcile(lots []Lot, ticker string, quantity float64, averagePrice float64) []Lot {
+	lots = slices.Clone(lots)
+	known, knownCost := 0.0, 0.0
+	for _, lot := range lots {
+		known += lot.Quantity
+		knownCost += lot.Quantity * lot.Cost
+	}
+	if known > quantity {
+		return relieve(lots, known-quantity)
+	}
+	missing := quantity - known
+	if missing <= 1e-9 {
+		return lots
+	}
+	cost := math.Max(0, (quantity*averagePrice-knownCost)/missing)
+	return append([]Lot{{Ticker: ticker, Quantity: missing, Cost: cost}}, lots...)
+}
+
+// shares of a lot relieved by a sale
+type Sale struct {
+	Lot      Lot
+	Quantity float64
+	Proceeds float64
+	Basis    float64
+	LongTerm bool
+}
+
+func (s Sale) Gain() float64 {
+	return s.Proceeds - s.Basis
+}
+
+// order the method relieves lots in
+func (m Method) sort(lots []Lot, price float64, now time.Time) {
+	switch m {
+	case LIFO:
+		slices.SortStableFunc(lots, func(a, b Lot) int { return b.Acquired.Compare(a.Acquired) })
+	case HighestCost:
+		slices.SortStableFunc(lots, func(a, b Lot) int { return cmp.Compare(b.Cost, a.Cost) })
+	case LossHarvester:
+		// short term losses, long term losses, long term gains and then short term gains,
+		// largest losses and smallest gains first
+		rank := func(l Lot) int {
+			loss := l.Cost > price
+			switch {
+			case loss && !l.LongTerm(now):
+				return 0
+			case loss:
+				return 1
+			case l.LongTerm(now):
+				return 2
+			}
+			return 3
+		}
+		slices.SortStableFunc(lots, func(a, b Lot) int {
+			return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(b.Cost, a.Cost))
+		})
+	default:
+		slices.SortStableFunc(lots, func(a, b Lot) int { return a.Acquired.Compare(b.Acquired) })
+	}
+}
+
+// Lots the method relieves to sell quantity shares at price. Sells less when the lots hold less.
+func Select(lots []Lot, quantity float64, price float64, method Method, now time.Time) []Sale {
+	lots = slices.Clone(lots)
+	method.sort(lots, price, now)
+	sales := make([]Sale, 0)
+	for _, lot := range lots {
+		if quantity <= 1e-9 {
+			break
+		}
+		taken := math.Min(lot.Quantity, quantity)
+		quantity -= taken
+		sales = append(sales, Sale{
+			Lot:      lot,
+			Quantity: taken,
+			Proceeds: taken * price,
+			Basis:    taken * lot.Cost,
+			LongTerm: lot.LongTerm(now),
+		})
+	}
+	return sales
+}
+
+// estimated realized gains, negative for losses
+type Gains struct {
+	ShortTerm float64
+	LongTerm  float64
+}
+
+func Realized(sales []Sale) Gains {
+	gains := Gains{}
+	for _, sale := range sales {
+		if sale.LongTerm {
+			gains.LongTerm += sale.Gain()
+		} else {
+			gains.ShortTerm += sale.Gain()
+		}
+	}
+	return gains
+}
+
+// realized gains of the sales of every ticker
+func RealizedAll(sales map[string][]Sale) Gains {
+	total := Gains{}
+	for _, ticker := range slices.Sorted(maps.Keys(sales)) {
+		gains := Realized(sales[ticker])
+		total.ShortTerm += gains.ShortTerm
+		total.LongTerm += gains.LongTerm
+	}
+	return total
+}
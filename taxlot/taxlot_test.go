@@ -0,0 +1,164 @@
+package taxlot
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/josephwest2/schwab-portfolio-manager/types/schwab/trader"
+)
+
+func trade(at time.Time, ticker string, amount float64, price float64) trader.Transaction {
+	return trader.Transaction{
+		Type: "TRADE",
+		Time: at.Format(trader.TimeFormat),
+		TransferItems: []trader.TransferItem{
+			{Instrument: trader.Instrument{Symbol: "CURRENCY_USD", AssetType: "CURRENCY"}, Amount: -amount * price},
+			{Instrument: trader.Instrument{Symbol: ticker, AssetType: "EQUITY"}, Amount: amount, Price: price},
+			{Instrument: trader.Instrument{Symbol: "CURRENCY_USD", AssetType: "CURRENCY"}, Amount: -0.01, FeeType: "SEC_FEE"},
+		},
+	}
+}
+
+func TestFromTransactions(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	lots, err := FromTransactions([]trader.Transaction{
+		trade(now.AddDate(0, -3, 0), "VTI", 5, 20),
+		trade(now.AddDate(0, -10, 0), "VTI", 10, 10),
+		trade(now.AddDate(0, -1, 0), "VTI", -12, 25),
+		trade(now.AddDate(0, -2, 0), "VXUS", 3, 50),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]Lot{
+		"VTI":  {{Ticker: "VTI", Acquired: now.AddDate(0, -3, 0), Quantity: 3, Cost: 20}},
+		"VXUS": {{Ticker: "VXUS", Acquired: now.AddDate(0, -2, 0), Quantity: 3, Cost: 50}},
+	}
+	for ticker, tickerLots := range lots {
+		for i := range tickerLots {
+			tickerLots[i].Acquired = tickerLots[i].Acquired.UTC()
+		}
+		lots[ticker] = tickerLots
+	}
+	if !reflect.DeepEqual(lots, expected) {
+		t.Errorf("expected lots %+v, got %+v", expected, lots)
+	}
+
+	// 13 shares at an average of 17, the 10 shares before the history carry the rest of the cost
+	reconciled := Reconcile(lots["VTI"], "VTI", 13, 17)
+	if len(reconciled) != 2 || reconciled[0].Quantity != 10 || math.Abs(reconciled[0].Cost-16.1) > 1e-9 || reconciled[1] != lots["VTI"][0] {
+		t.Errorf("expected 10 shares at 16.1 before the lot of the history, got %+v", reconciled)
+	}
+	if trimmed := Reconcile(lots["VTI"], "VTI", 1, 20); len(trimmed) != 1 || trimmed[0].Quantity != 1 {
+		t.Errorf("expected the lot to be trimmed to the position, got %+v", trimmed)
+	}
+}
+
+func TestDisposals(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	disposals, err := Disposals([]trader.Transaction{
+		trade(now.AddDate(0, -3, 0), "VTI", 5, 20),
+		trade(now.AddDate(0, -10, 0), "VTI", 10, 10),
+		trade(now.AddDate(0, -1, 0), "VTI", -12, 25),
+		trade(now.AddDate(0, -2, 0), "VXUS", 3, 50),
+		trade(now.AddDate(0, 0, -5), "VXUS", -5, 40),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first 10 VTI at 10 and 2 at 20, then 3 VXUS of the history and 2 from before it
+	expected := []Disposal{
+		{Ticker: "VTI", Quantity: 12, Price: 25, Basis: 140},
+		{Ticker: "VXUS", Quantity: 5, Price: 40, Basis: 150, Unknown: 2},
+	}
+	if len(disposals) != len(expected) {
+		t.Fatalf("expected %v disposals, got %+v", len(expected), disposals)
+	}
+	for i, disposal := range disposals {
+		disposal.Sold = time.Time{}
+		if disposal != expected[i] {
+			t.Errorf("expected %+v, got %+v on test index %v", expected[i], disposal, i)
+		}
+	}
+}
+
+func TestPurchases(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	purchases, err := Purchases([]trader.Transaction{
+		trade(now.AddDate(0, -1, 0), "VTI", -12, 25),
+		trade(now.AddDate(0, -3, 0), "VTI", 15, 20),
+		trade(now.AddDate(0, 0, -5), "VXUS", 3, 50),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sold VTI are purchases all the same
+	if len(purchases) != 2 || purchases[0].Ticker != "VTI" || purchases[0].Quantity != 15 || purchases[1].Ticker != "VXUS" || !purchases[1].Acquired.Equal(now.AddDate(0, 0, -5)) {
+		t.Errorf("expected the VTI and VXUS purchases, got %+v", purchases)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	lots := []Lot{
+		{Ticker: "VTI", Quantity: 10, Cost: 5},
+		{Ticker: "VTI", Acquired: now.AddDate(0, -18, 0), Quantity: 10, Cost: 14},
+		{Ticker: "VTI", Acquired: now.AddDate(0, -6, 0), Quantity: 10, Cost: 9},
+		{Ticker: "VTI", Acquired: now.AddDate(0, -1, 0), Quantity: 10, Cost: 12},
+	}
+
+	tests := []struct {
+		method         Method
+		quantity       float64
+		expectedCosts  []float64
+		expectedGains  Gains
+		expectedAmount float64
+	}{
+		{FIFO, 15, []float64{5, 14}, Gains{LongTerm: 50 - 20}, 15},
+		{LIFO, 15, []float64{12, 9}, Gains{ShortTerm: -20 + 5}, 15},
+		{HighestCost, 15, []float64{14, 12}, Gains{ShortTerm: -10, LongTerm: -40}, 15},
+		{LossHarvester, 25, []float64{12, 14, 5}, Gains{ShortTerm: -20, LongTerm: -40 + 25}, 25},
+		{FIFO, 50, []float64{5, 14, 9, 12}, Gains{ShortTerm: 10 - 20, LongTerm: 50 - 40}, 40},
+	}
+	for i, test := range tests {
+		sales := Select(lots, test.quantity, 10, test.method, now)
+		costs := make([]float64, 0)
+		amount := 0.0
+		for _, sale := range sales {
+			costs = append(costs, sale.Lot.Cost)
+			amount += sale.Quantity
+		}
+		if !reflect.DeepEqual(costs, test.expectedCosts) {
+			t.Errorf("expected lots with costs %v, got %v on test index %v", test.expectedCosts, costs, i)
+		}
+		if amount != test.expectedAmount {
+			t.Errorf("expected %v shares sold, got %v on test index %v", test.expectedAmount, amount, i)
+		}
+		if gains := Realized(sales); gains != test.expectedGains {
+			t.Errorf("expected gains %+v, got %+v on test index %v", test.expectedGains, gains, i)
+		}
+	}
+}
+
+func TestParseMethod(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Method
+		err      bool
+	}{
+		{"fifo", FIFO, false},
+		{"LIFO", LIFO, false},
+		{"highest-cost", HighestCost, false},
+		{"HIGH_COST", HighestCost, false},
+		{"loss-harvester", LossHarvester, false},
+		{"average", "", true},
+	}
+	for i, test := range tests {
+		method, err := ParseMethod(test.input)
+		if (err != nil) != test.err || method != test.expected {
+			t.Errorf("expected %q, error %v, got %q, %v on test index %v", test.expected, test.err, method, err, i)
+		}
+	}
+}
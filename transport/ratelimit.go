@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// Token bucket refilled continuously at rate tokens per second, holding at most burst tokens.
+// Tokens may go negative, callers then wait until their token has been refilled.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func NewTokenBucket(perMinute int, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// takes a token and returns how long to wait before using it
+func (tb *TokenBucket) Reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	tb.tokens = min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
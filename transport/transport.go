@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// schwab allows 120 trader api requests per minute
+const DefaultRequestsPerMinute = 120
+const DefaultBurst = 20
+const DefaultMaxRetries = 4
+const DefaultBaseDelay = 500 * time.Millisecond
+const DefaultMaxDelay = 30 * time.Second
+
+// statuses worth retrying, the request did not reach or was not handled by the api
+var RetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// Rate limits every request and retries GET and HEAD requests answered with a RetryStatuses status,
+// with exponential backoff and jitter or after the Retry-After delay of the response.
+// A Retry-After longer than MaxDelay returns the response as is.
+// Other methods are never retried, a POST that timed out may still have placed an order.
+type Transport struct {
+	Base       http.RoundTripper
+	Limiter    *TokenBucket
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	// waits between attempts, sleep when nil
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func New(base http.RoundTripper) *Transport {
+	return &Transport{
+		Base:       base,
+		Limiter:    NewTokenBucket(DefaultRequestsPerMinute, DefaultBurst),
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultBaseDelay,
+		MaxDelay:   DefaultMaxDelay,
+		sleep:      sleep,
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// waits d unless the context ends first, a Transport literal has no sleep of its own
+func (t *Transport) wait(ctx context.Context, d time.Duration) error {
+	if t.sleep == nil {
+		return sleep(ctx, d)
+	}
+	return t.sleep(ctx, d)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if t.Limiter != nil {
+			if err := t.wait(req.Context(), t.Limiter.Reserve()); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.base().RoundTrip(req)
+		if err != nil || !idempotent(req) || !slices.Contains(RetryStatuses, resp.StatusCode) || attempt >= t.MaxRetries {
+			return resp, err
+		}
+
+		delay, ok := retryAfter(resp)
+		if !ok {
+			delay = t.backoff(attempt)
+		} else if delay > t.MaxDelay {
+			// retrying earlier than asked would only be rejected again
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if err := t.wait(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func idempotent(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body == nil || req.Body == http.NoBody)
+}
+
+// exponential delay for the attempt, randomized between half and the full delay
+func (t *Transport) backoff(attempt int) time.Duration {
+	delay := min(t.BaseDelay<<attempt, t.MaxDelay)
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// Retry-After is either a number of seconds or an http date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date)), true
+	}
+	return 0, false
+}
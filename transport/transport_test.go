@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransportRetry(t *testing.T) {
+	tests := []struct {
+		method           string
+		statuses         []int
+		retryAfter       string
+		expectedStatus   int
+		expectedRequests int
+		expectedDelays   []time.Duration
+	}{
+		{
+			method:           http.MethodGet,
+			statuses:         []int{503, 502, 200},
+			expectedStatus:   200,
+			expectedRequests: 3,
+		},
+		{
+			method:           http.MethodGet,
+			statuses:         []int{429, 200},
+			retryAfter:       "2",
+			expectedStatus:   200,
+			expectedRequests: 2,
+			expectedDelays:   []time.Duration{2 * time.Second},
+		},
+		{
+			method:           http.MethodGet,
+			statuses:         []int{429, 200},
+			retryAfter:       "120",
+			expectedStatus:   429,
+			expectedRequests: 1,
+		},
+		{
+			method:           http.MethodGet,
+			statuses:         []int{504, 504, 504, 504, 504, 504},
+			expectedStatus:   504,
+			expectedRequests: 5,
+		},
+		{
+			method:           http.MethodGet,
+			statuses:         []int{500, 200},
+			expectedStatus:   500,
+			expectedRequests: 1,
+		},
+		{
+			method:           http.MethodPost,
+			statuses:         []int{503, 201},
+			expectedStatus:   503,
+			expectedRequests: 1,
+		},
+	}
+
+	for i, test := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.retryAfter != "" {
+				w.Header().Set("Retry-After", test.retryAfter)
+			}
+			w.WriteHeader(test.statuses[requests])
+			requests++
+		}))
+
+		delays := make([]time.Duration, 0)
+		tr := New(http.DefaultTransport)
+		tr.Limiter = nil
+		tr.sleep = func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return nil
+		}
+
+		var body io.Reader
+		if test.method == http.MethodPost {
+			body = strings.NewReader("{}")
+		}
+		req, _ := http.NewRequest(test.method, server.URL, body)
+		resp, err := (&http.Client{Transport: tr}).Do(req)
+		server.Close()
+		if err != nil {
+			t.Fatalf("request failed on test index %v: %v", i, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.expectedStatus {
+			t.Errorf("expected status %v, got %v, on test index %v", test.expectedStatus, resp.StatusCode, i)
+		}
+		if requests != test.expectedRequests {
+			t.Errorf("expected %v requests, got %v, on test index %v", test.expectedRequests, requests, i)
+		}
+		if test.expectedDelays != nil && !slices.Equal(delays, test.expectedDelays) {
+			t.Errorf("expected delays %v, got %v, on test index %v", test.expectedDelays, delays, i)
+		}
+		for attempt, delay := range delays {
+			if test.retryAfter == "" && (delay < DefaultBaseDelay<<attempt/2 || delay > DefaultBaseDelay<<attempt) {
+				t.Errorf("backoff %v of attempt %v out of range on test index %v", delay, attempt, i)
+			}
+		}
+	}
+}
+
+// a Transport literal retries with the default sleep
+func TestTransportLiteral(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		requests++
+	}))
+	defer server.Close()
+
+	tr := &Transport{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests != 2 {
+		t.Errorf("expected a retried 200, got %v after %v requests", resp.StatusCode, requests)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	tb := NewTokenBucket(60, 2)
+	tb.now = func() time.Time { return now }
+	tb.last = now
+
+	expected := []time.Duration{0, 0, time.Second, 2 * time.Second}
+	for i, e := range expected {
+		if d := tb.Reserve(); d != e {
+			t.Errorf("expected wait %v, got %v, on reservation %v", e, d, i)
+		}
+	}
+
+	// refilled after the queued reservations are used up
+	now = now.Add(4 * time.Second)
+	if d := tb.Reserve(); d != 0 {
+		t.Errorf("expected no wait after refill, got %v", d)
+	}
+}
@@ -1,5 +1,8 @@
 package trader
 
+// time format of order and transaction timestamps returned by the trader api
+const TimeFormat = "2006-01-02T15:04:05-0700"
+
 type AllAccountsResponse []struct {
 	SecuritiesAccount SecuritiesAccount `json:"securitiesAccount"`
 }
@@ -9,6 +12,7 @@ type AccountResponse struct {
 }
 
 type SecuritiesAccount struct {
+	Type                    string            `json:"type,omitempty"`
 	AccountNumber           string            `json:"accountNumber,omitempty"`
 	RoundTrips              int               `json:"roundTrips,omitempty"`
 	IsDayTrader             bool              `json:"isDayTrader,omitempty"`
@@ -185,3 +189,131 @@ type ExecutionLeg struct {
 	InstrumentID      int64   `json:"instrumentId,omitempty"`
 	Time              string  `json:"time,omitempty"`
 }
+
+type PreviewOrder struct {
+	OrderID               int64                 `json:"orderId,omitempty"`
+	OrderStrategy         OrderStrategy         `json:"orderStrategy"`
+	OrderValidationResult OrderValidationResult `json:"orderValidationResult"`
+	CommissionAndFee      CommissionAndFee      `json:"commissionAndFee"`
+}
+
+type OrderStrategy struct {
+	AccountNumber     string       `json:"accountNumber,omitempty"`
+	Status            string       `json:"status,omitempty"`
+	OrderType         string       `json:"orderType,omitempty"`
+	OrderStrategyType string       `json:"orderStrategyType,omitempty"`
+	Price             float64      `json:"price,omitempty"`
+	Quantity          float64      `json:"quantity,omitempty"`
+	OrderBalance      OrderBalance `json:"orderBalance"`
+	OrderLegs         []PreviewLeg `json:"orderLegs,omitempty"`
+}
+
+type OrderBalance struct {
+	OrderValue             float64 `json:"orderValue,omitempty"`
+	ProjectedAvailableFund float64 `json:"projectedAvailableFund,omitempty"`
+	ProjectedBuyingPower   float64 `json:"projectedBuyingPower,omitempty"`
+	ProjectedCommission    float64 `json:"projectedCommission,omitempty"`
+}
+
+type PreviewLeg struct {
+	AskPrice            float64 `json:"askPrice,omitempty"`
+	BidPrice            float64 `json:"bidPrice,omitempty"`
+	LastPrice           float64 `json:"lastPrice,omitempty"`
+	MarkPrice           float64 `json:"markPrice,omitempty"`
+	ProjectedCommission float64 `json:"projectedCommission,omitempty"`
+	Quantity            float64 `json:"quantity,omitempty"`
+	FinalSymbol         string  `json:"finalSymbol,omitempty"`
+	LegID               int64   `json:"legId,omitempty"`
+	AssetType           string  `json:"assetType,omitempty"`
+	Instruction         string  `json:"instruction,omitempty"`
+}
+
+type OrderValidationResult struct {
+	Alerts  []OrderValidationDetail `json:"alerts,omitempty"`
+	Accepts []OrderValidationDetail `json:"accepts,omitempty"`
+	Rejects []OrderValidationDetail `json:"rejects,omitempty"`
+	Reviews []OrderValidationDetail `json:"reviews,omitempty"`
+	Warns   []OrderValidationDetail `json:"warns,omitempty"`
+}
+
+type OrderValidationDetail struct {
+	ValidationRuleName string `json:"validationRuleName,omitempty"`
+	Message            string `json:"message,omitempty"`
+	ActivityMessage    string `json:"activityMessage,omitempty"`
+	OriginalSeverity   string `json:"originalSeverity,omitempty"`
+	OverrideName       string `json:"overrideName,omitempty"`
+	OverrideSeverity   string `json:"overrideSeverity,omitempty"`
+}
+
+type CommissionAndFee struct {
+	Commission     Commission `json:"commission"`
+	Fee            Fees       `json:"fee"`
+	TrueCommission Commission `json:"trueCommission"`
+}
+
+type Commission struct {
+	CommissionLegs []CommissionLeg `json:"commissionLegs,omitempty"`
+}
+
+type CommissionLeg struct {
+	CommissionValues []FeeValue `json:"commissionValues,omitempty"`
+}
+
+type Fees struct {
+	FeeLegs []FeeLeg `json:"feeLegs,omitempty"`
+}
+
+type FeeLeg struct {
+	FeeValues []FeeValue `json:"feeValues,omitempty"`
+}
+
+type FeeValue struct {
+	Value float64 `json:"value,omitempty"`
+	Type  string  `json:"type,omitempty"`
+}
+
+func (c Commission) Total() float64 {
+	total := 0.0
+	for _, leg := range c.CommissionLegs {
+		for _, value := range leg.CommissionValues {
+			total += value.Value
+		}
+	}
+	return total
+}
+
+func (f Fees) Total() float64 {
+	total := 0.0
+	for _, leg := range f.FeeLegs {
+		for _, value := range leg.FeeValues {
+			total += value.Value
+		}
+	}
+	return total
+}
+
+type Transaction struct {
+	ActivityID     int64          `json:"activityId,omitempty"`
+	Time           string         `json:"time,omitempty"`
+	Description    string         `json:"description,omitempty"`
+	AccountNumber  string         `json:"accountNumber,omitempty"`
+	Type           string         `json:"type,omitempty"`
+	Status         string         `json:"status,omitempty"`
+	SubAccount     string         `json:"subAccount,omitempty"`
+	TradeDate      string         `json:"tradeDate,omitempty"`
+	SettlementDate string         `json:"settlementDate,omitempty"`
+	PositionID     int64          `json:"positionId,omitempty"`
+	OrderID        int64          `json:"orderId,omitempty"`
+	NetAmount      float64        `json:"netAmount,omitempty"`
+	ActivityType   string         `json:"activityType,omitempty"`
+	TransferItems  []TransferItem `json:"transferItems,omitempty"`
+}
+
+type TransferItem struct {
+	Instrument     Instrument `json:"instrument"`
+	Amount         float64    `json:"amount,omitempty"`
+	Cost           float64    `json:"cost,omitempty"`
+	Price          float64    `json:"price,omitempty"`
+	FeeType        string     `json:"feeType,omitempty"`
+	PositionEffect string     `json:"positionEffect,omitempty"`
+}